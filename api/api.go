@@ -19,6 +19,11 @@ func Configure(api_url string) {
 	conf.api_url = api_url
 }
 
+// URL returns the URL of the API configured using Configure.
+func URL() string {
+	return conf.api_url
+}
+
 // ProcessRequest handles a single request
 // Note that this can return an actual response, an error (when the HTTP
 // response doesn't have status 200) or neither. The latter is used to
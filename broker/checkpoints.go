@@ -0,0 +1,191 @@
+package broker
+
+// This file implements resuming the processing of events from checkpoints.
+//
+// A consumer saves the ID of the last event it handled as its checkpoint, if
+// the store supports that. In order not to write to the store for every event,
+// the checkpoint is saved every CheckpointInterval events, at the latest
+// CheckpointDelay after an event was handled, and when the consumer stops.
+// When it is restarted without an explicit position, it resumes after that
+// event instead of replaying the whole history. Events handled after the last
+// saved checkpoint are handled again. Only the configuration is restored from
+// the events before the checkpoint. Requests from before the checkpoint are
+// unknown to the consumer, so events caused by them are reported as failing to
+// locate the request and then ignored.
+
+import (
+	"api-broker-prototype/events"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+const (
+	// ProcessorConsumer is the default consumer name of the RequestProcessor.
+	ProcessorConsumer = "request-processor"
+	// WatcherConsumer is the default consumer name of the RequestWatcher.
+	WatcherConsumer = "request-watcher"
+)
+
+const (
+	// CheckpointInterval is the number of events after which a consumer saves
+	// its checkpoint.
+	CheckpointInterval = 100
+	// CheckpointDelay is the maximum duration after handling an event until
+	// a consumer saves its checkpoint.
+	CheckpointDelay = time.Second
+)
+
+// maximum duration for saving the checkpoint when a consumer stops
+const checkpointFlushTimeout = 5 * time.Second
+
+// saves the checkpoint of a consumer periodically
+type checkpointer struct {
+	store    events.EventStore
+	logger   log15.Logger
+	consumer string
+	// mutex guarding the fields below, which the timer accesses, too
+	mutex sync.Mutex
+	// ID of the last handled event and of the last saved checkpoint
+	handled events.EventID
+	saved   events.EventID
+	// number of events handled since the checkpoint was saved
+	count int
+	// timer saving the checkpoint after CheckpointDelay, nil if not running
+	timer *time.Timer
+}
+
+func newCheckpointer(store events.EventStore, logger log15.Logger, consumer string, position events.EventID) *checkpointer {
+	return &checkpointer{
+		store:    store,
+		logger:   logger,
+		consumer: consumer,
+		handled:  position,
+		saved:    position,
+	}
+}
+
+// record that the event was handled
+// The checkpoint is saved right away every CheckpointInterval events, else
+// after CheckpointDelay.
+func (c *checkpointer) Handled(ctx context.Context, id events.EventID) {
+	if c.consumer == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.handled = id
+	c.count++
+	if c.count >= CheckpointInterval {
+		c.save(ctx)
+		return
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(CheckpointDelay, func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.save(ctx)
+		})
+	}
+}
+
+// save the checkpoint of the last handled event right away
+// This works after the context was canceled, too, so it can be used when the
+// consumer stops.
+func (c *checkpointer) Flush(ctx context.Context) {
+	if c.consumer == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), checkpointFlushTimeout)
+	defer cancel()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.save(ctx)
+}
+
+// save the checkpoint if it changed, the mutex must be held
+func (c *checkpointer) save(ctx context.Context) {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.handled == c.saved {
+		return
+	}
+	saveCheckpoint(ctx, c.store, c.logger, c.consumer, c.handled)
+	c.saved = c.handled
+	c.count = 0
+}
+
+// determine the ID of the event after which to resume
+// An explicit position takes precedence over the checkpoint. Without a
+// consumer name, or if the store doesn't support checkpoints, processing
+// starts at the beginning.
+func resumePosition(ctx context.Context, store events.EventStore, consumer string, lastProcessedID events.EventID) (events.EventID, error) {
+	if lastProcessedID != 0 || consumer == "" {
+		return lastProcessedID, nil
+	}
+	checkpoints, ok := store.(events.CheckpointStore)
+	if !ok {
+		return 0, nil
+	}
+	id, err := checkpoints.LoadCheckpoint(ctx, consumer)
+	if errors.Is(err, events.CheckpointsNotSupported) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// save the ID of the handled event as checkpoint of the consumer
+// A failure is only logged, because it just means that more events are
+// replayed after a restart.
+func saveCheckpoint(ctx context.Context, store events.EventStore, logger log15.Logger, consumer string, id events.EventID) {
+	if consumer == "" {
+		return
+	}
+	checkpoints, ok := store.(events.CheckpointStore)
+	if !ok {
+		return
+	}
+	err := checkpoints.SaveCheckpoint(ctx, consumer, id)
+	if err != nil && !errors.Is(err, events.CheckpointsNotSupported) && ctx.Err() == nil {
+		logger.Error("failed to save checkpoint", "consumer", consumer, "id", id, "error", err)
+	}
+}
+
+// determine the configuration in effect after the given event
+// This replays only the configuration events up to that event.
+func loadConfiguration(ctx context.Context, store events.EventStore, lastProcessedID events.EventID) (uint, *time.Duration, error) {
+	var retries uint
+	var timeout *time.Duration
+	if lastProcessedID == 0 {
+		return retries, timeout, nil
+	}
+
+	ch, err := store.LoadEvents(
+		ctx,
+		events.Range{StartBefore: lastProcessedID + 1},
+		events.Filter{Classes: []string{ConfigurationEvent{}.Class()}},
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	for envelope := range ch {
+		event, ok := envelope.Event().(ConfigurationEvent)
+		if !ok {
+			continue
+		}
+		if event.Retries >= 0 {
+			retries = uint(event.Retries)
+		}
+		if event.Timeout >= 0 {
+			timeout = durationFromFloat(event.Timeout)
+		}
+	}
+	return retries, timeout, store.Error()
+}
@@ -0,0 +1,25 @@
+package broker
+
+// This file registers the codecs for the events defined in events.go.
+
+import (
+	"api-broker-prototype/events"
+)
+
+// RegisterCodecs registers the codecs for the events defined here.
+// The codecs are derived from the struct tags of the events.
+func RegisterCodecs(registry *events.CodecRegistry) error {
+	for _, prototype := range []events.Event{
+		ConfigurationEvent{},
+		RequestEvent{},
+		APIRequestEvent{},
+		APIResponseEvent{},
+		APIFailureEvent{},
+		APITimeoutEvent{},
+	} {
+		if err := registry.RegisterEvent(prototype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
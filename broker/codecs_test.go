@@ -0,0 +1,238 @@
+package broker
+
+import (
+	"api-broker-prototype/events"
+	"reflect"
+	"testing"
+)
+
+type codecTestcase struct {
+	event events.Event
+	data  events.Payload
+	// only test deserializing, e.g. for numbers as decoded by other backends
+	decodeOnly bool
+	// expect deserializing to fail
+	fails bool
+}
+
+func runCodecTestcases(cases map[string]codecTestcase, prototype events.Event, t *testing.T) {
+	codec, err := events.NewStructCodec(prototype)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if c.event != nil && codec.Class() != c.event.Class() {
+				t.Fatal("codec/event class mismatch")
+			}
+
+			// test deserializing
+			event, err := codec.Deserialize(c.data)
+			if c.fails {
+				if err == nil {
+					t.Errorf("expected error missing, received event %v", event)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(c.event, event) {
+				t.Log("expected event", c.event)
+				t.Log("received event", event)
+				t.Errorf("events differ")
+			}
+			if c.decodeOnly {
+				return
+			}
+
+			// test serializing
+			data, err := codec.Serialize(c.event)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !reflect.DeepEqual(c.data, data) {
+				t.Log("expected data", c.data)
+				t.Log("received data", data)
+				t.Errorf("data differ")
+			}
+		})
+	}
+}
+
+func TestRegisterCodecs(t *testing.T) {
+	registry := events.NewCodecRegistry()
+	if err := RegisterCodecs(registry); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// registering twice fails, because the classes are taken
+	if err := RegisterCodecs(registry); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+func TestConfigurationCodec(t *testing.T) {
+	cases := map[string]codecTestcase{
+		"test configuration": {
+			event: ConfigurationEvent{
+				Retries: 2,
+				Timeout: 2.5,
+			},
+			data: events.Payload{
+				"retries": int32(2),
+				"timeout": float64(2.5),
+			},
+		},
+		"test decoded numbers": {
+			event: ConfigurationEvent{
+				Retries: 2,
+				Timeout: 3,
+			},
+			data: events.Payload{
+				"retries": float64(2),
+				"timeout": int64(3),
+			},
+			decodeOnly: true,
+		},
+		"test missing timeout": {
+			data: events.Payload{
+				"retries": int32(2),
+			},
+			fails: true,
+		},
+	}
+	runCodecTestcases(cases, ConfigurationEvent{}, t)
+}
+
+func TestRequestCodec(t *testing.T) {
+	cases := map[string]codecTestcase{
+		"test request": {
+			event: RequestEvent{
+				Request: "some request",
+			},
+			data: events.Payload{
+				"request": "some request",
+			},
+		},
+		"test invalid request": {
+			data: events.Payload{
+				"request": int64(1),
+			},
+			fails: true,
+		},
+	}
+	runCodecTestcases(cases, RequestEvent{}, t)
+}
+
+func TestAPIRequestCodec(t *testing.T) {
+	cases := map[string]codecTestcase{
+		"test request": {
+			event: APIRequestEvent{
+				Attempt: uint(0),
+			},
+			data: events.Payload{
+				"attempt": int64(0),
+			},
+		},
+		"test decoded attempt": {
+			event: APIRequestEvent{
+				Attempt: uint(1),
+			},
+			data: events.Payload{
+				"attempt": float64(1),
+			},
+			decodeOnly: true,
+		},
+		"test fractional attempt": {
+			data: events.Payload{
+				"attempt": float64(1.5),
+			},
+			fails: true,
+		},
+	}
+	runCodecTestcases(cases, APIRequestEvent{}, t)
+}
+
+func TestAPIResponseCodec(t *testing.T) {
+	cases := map[string]codecTestcase{
+		"test response": {
+			event: APIResponseEvent{
+				Attempt:  uint(0),
+				Response: "some response",
+			},
+			data: events.Payload{
+				"attempt":  int64(0),
+				"response": "some response",
+			},
+		},
+		"test decoded attempt": {
+			event: APIResponseEvent{
+				Attempt:  uint(2),
+				Response: "some response",
+			},
+			data: events.Payload{
+				"attempt":  int32(2),
+				"response": "some response",
+			},
+			decodeOnly: true,
+		},
+		"test missing response": {
+			data: events.Payload{
+				"attempt": int64(0),
+			},
+			fails: true,
+		},
+	}
+	runCodecTestcases(cases, APIResponseEvent{}, t)
+}
+
+func TestAPIFailureCodec(t *testing.T) {
+	cases := map[string]codecTestcase{
+		"test failure": {
+			event: APIFailureEvent{
+				Attempt: uint(0),
+				Failure: "some failure",
+			},
+			data: events.Payload{
+				"attempt": int64(0),
+				"failure": "some failure",
+			},
+		},
+		"test missing attempt": {
+			data: events.Payload{
+				"failure": "some failure",
+			},
+			fails: true,
+		},
+	}
+	runCodecTestcases(cases, APIFailureEvent{}, t)
+}
+
+func TestAPITimeoutCodec(t *testing.T) {
+	cases := map[string]codecTestcase{
+		"test timeout": {
+			event: APITimeoutEvent{
+				Attempt: uint(0),
+			},
+			data: events.Payload{
+				"attempt": int64(0),
+			},
+		},
+		"test decoded attempt": {
+			event: APITimeoutEvent{
+				Attempt: uint(3),
+			},
+			data: events.Payload{
+				"attempt": float64(3),
+			},
+			decodeOnly: true,
+		},
+		"test negative attempt": {
+			data: events.Payload{
+				"attempt": int64(-1),
+			},
+			fails: true,
+		},
+	}
+	runCodecTestcases(cases, APITimeoutEvent{}, t)
+}
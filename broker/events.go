@@ -7,8 +7,8 @@ import ()
 // ConfigurationEvent models an event that contains configuration settings
 // for the way the API is used.
 type ConfigurationEvent struct {
-	Retries int32   // number of retries after a failure
-	Timeout float64 // timeout for each attempt
+	Retries int32   `event:"retries"` // number of retries after a failure
+	Timeout float64 `event:"timeout"` // timeout for each attempt
 }
 
 // Class implements the Event interface.
@@ -18,7 +18,7 @@ func (e ConfigurationEvent) Class() string {
 
 // the RequestEvent represents a request that should be sent to the API
 type RequestEvent struct {
-	Request string
+	Request string `event:"request"`
 }
 
 // Class implements the Event interface.
@@ -29,7 +29,7 @@ func (e RequestEvent) Class() string {
 // the APIRequestEvent represents a communication attempt with the API
 // When starting the communication attempt, this event is emitted.
 type APIRequestEvent struct {
-	Attempt uint // zero-based index of the attempt
+	Attempt uint `event:"attempt"` // zero-based index of the attempt
 }
 
 // Class implements the Event interface.
@@ -41,8 +41,8 @@ func (e APIRequestEvent) Class() string {
 // Note that this does not discriminate between success or failure. Rather,
 // any response is stored here without interpretation.
 type APIResponseEvent struct {
-	Attempt  uint // zero-based index of the attempt
-	Response string
+	Attempt  uint   `event:"attempt"` // zero-based index of the attempt
+	Response string `event:"response"`
 }
 
 // Class implements the Event interface.
@@ -55,8 +55,8 @@ func (e APIResponseEvent) Class() string {
 // the failure to resolve the remote DNS name to an IP. It does not represent a
 // response received from remote which contains an error.
 type APIFailureEvent struct {
-	Attempt uint // zero-based index of the attempt
-	Failure string
+	Attempt uint   `event:"attempt"` // zero-based index of the attempt
+	Failure string `event:"failure"`
 }
 
 // Class implements the Event interface.
@@ -71,10 +71,20 @@ func (e APIFailureEvent) Class() string {
 // event type is only tied to the attempt that timed out and doesn't carry
 // any further data, simply because it represents the absence of data.
 type APITimeoutEvent struct {
-	Attempt uint // zero-based index of the attempt
+	Attempt uint `event:"attempt"` // zero-based index of the attempt
 }
 
 // Class implements the Event interface.
 func (e APITimeoutEvent) Class() string {
 	return "api-timeout"
 }
+
+// classes of the events handled by the RequestProcessor and RequestWatcher
+var requestClasses = []string{
+	ConfigurationEvent{}.Class(),
+	RequestEvent{}.Class(),
+	APIRequestEvent{}.Class(),
+	APIResponseEvent{}.Class(),
+	APIFailureEvent{}.Class(),
+	APITimeoutEvent{}.Class(),
+}
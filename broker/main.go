@@ -6,7 +6,6 @@ import (
 	"context"
 	"time"
 
-	"github.com/gofrs/uuid"
 	"github.com/inconshreveable/log15"
 )
 
@@ -72,10 +71,25 @@ func (request *requestData) Event() RequestEvent {
 }
 
 // retrieve the ID from the envelope
-func (request *requestData) ID() int32 {
+func (request *requestData) ID() events.EventID {
 	return request.envelope.ID()
 }
 
+// retrieve the correlation ID for events caused by the request
+// If the request doesn't belong to a chain of events already, it starts a new
+// one, so its own ID is used.
+func (request *requestData) CorrelationID() events.EventID {
+	if id := request.envelope.CorrelationID(); id != 0 {
+		return id
+	}
+	return request.envelope.ID()
+}
+
+// retrieve the metadata from the envelope
+func (request *requestData) Metadata() map[string]string {
+	return request.envelope.Metadata()
+}
+
 // query the number of retries for this request
 func (request *requestData) Retries() uint {
 	return uint(len(request.attempts) - 1)
@@ -135,55 +149,78 @@ func (request *requestData) State() requestState {
 type RequestProcessor struct {
 	store  events.EventStore
 	logger log15.Logger
+	// name under which the checkpoint is saved, empty to disable checkpoints
+	consumer string
 	// number of retries after a failed request
 	retries uint
 	// maximum duration before considering an attempt failed
 	timeout *time.Duration
 }
 
-func NewRequestProcessor(store events.EventStore, logger log15.Logger) (*RequestProcessor, error) {
+// NewRequestProcessor creates a RequestProcessor.
+// The consumer is the name under which it saves its checkpoint, see Run().
+// An empty name disables checkpoints.
+func NewRequestProcessor(store events.EventStore, logger log15.Logger, consumer string) (*RequestProcessor, error) {
 	return &RequestProcessor{
-		store:  store,
-		logger: logger,
+		store:    store,
+		logger:   logger,
+		consumer: consumer,
 	}, nil
 }
 
+// store an event caused by the request
+// The event inherits the correlation ID and the metadata of the request.
+func (handler *RequestProcessor) insert(ctx context.Context, request *requestData, event events.Event) error {
+	_, err := handler.store.InsertMany(
+		ctx,
+		[]events.NewEvent{{
+			Event:         event,
+			CausationID:   request.ID(),
+			CorrelationID: request.CorrelationID(),
+			Metadata:      request.Metadata(),
+		}},
+	)
+	return err
+}
+
+// insert a timeout event for the attempt after the duration
+// TODO: This accesses `store` asynchronously, which may need synchronization.
+func (handler *RequestProcessor) scheduleTimeout(ctx context.Context, request *requestData, attempt uint, timeout time.Duration) {
+	time.AfterFunc(
+		timeout,
+		func() {
+			err := handler.insert(
+				ctx,
+				request,
+				APITimeoutEvent{
+					Attempt: attempt,
+				},
+			)
+			if err != nil {
+				handler.logger.Error("failed to insert timeout event", "error", err)
+			}
+		},
+	)
+}
+
 // utility function to invoke the API and store the result as event
 func (handler *RequestProcessor) startApiCall(ctx context.Context, request *requestData) {
 	event := request.Event()
-	causationID := request.ID()
 	attempt := request.NextAttempt()
 	timeout := request.Timeout()
 
 	// emit event that a request was started
-	handler.store.Insert(
+	handler.insert(
 		ctx,
-		uuid.Nil,
+		request,
 		APIRequestEvent{
 			Attempt: attempt,
 		},
-		causationID,
 	)
 
 	// if a timeout is configured, trigger async creation of a timeout event
-	// TODO: This accesses `store` asynchronously, which may need synchronization.
 	if timeout != nil {
-		time.AfterFunc(
-			*timeout,
-			func() {
-				_, err := handler.store.Insert(
-					ctx,
-					uuid.Nil,
-					APITimeoutEvent{
-						Attempt: attempt,
-					},
-					causationID,
-				)
-				if err != nil {
-					handler.logger.Error("failed to insert timeout event", "error", err)
-				}
-			},
-		)
+		handler.scheduleTimeout(ctx, request, attempt, *timeout)
 	}
 
 	// TODO: this accesses `store` asynchronously, which may need synchronization
@@ -193,24 +230,22 @@ func (handler *RequestProcessor) startApiCall(ctx context.Context, request *requ
 
 		// store results as event
 		if response != nil {
-			handler.store.Insert(
+			handler.insert(
 				ctx,
-				uuid.Nil,
+				request,
 				APIResponseEvent{
 					Attempt:  attempt,
 					Response: *response,
 				},
-				causationID,
 			)
 		} else if err != nil {
-			handler.store.Insert(
+			handler.insert(
 				ctx,
-				uuid.Nil,
+				request,
 				APIFailureEvent{
 					Attempt: attempt,
 					Failure: err.Error(),
 				},
-				causationID,
 			)
 		} else {
 			handler.logger.Info("No response from API.")
@@ -218,185 +253,263 @@ func (handler *RequestProcessor) startApiCall(ctx context.Context, request *requ
 	}()
 }
 
-// ProcessRequests processes request events from the store.
-func (handler *RequestProcessor) Run(ctx context.Context, lastProcessedID int32) error {
-	ch, err := handler.store.FollowEvents(ctx, lastProcessedID)
-	if err != nil {
-		return err
-	}
-
-	// map of requests being processed currently
-	// Key is the event ID of the initial event (`RequestEvent`), which is
-	// used as causation ID in future events associated with this request.
-	requests := make(map[int32]*requestData)
+// handle an event of one of the request classes
+// While replaying, the state is updated without side effects, i.e. without
+// starting API calls.
+func (handler *RequestProcessor) handleEvent(ctx context.Context, envelope events.Envelope, requests map[events.EventID]*requestData, replaying bool) {
+	handler.logger.Info(
+		"processing event",
+		"id", envelope.ID(),
+		"class", envelope.Event().Class(),
+		"created", envelope.Created().Format(time.RFC3339),
+		"causation_id", envelope.CausationID(),
+		"correlation_id", envelope.CorrelationID(),
+		"data", envelope.Event(),
+	)
 
-	// process events from the channel
-	for envelope := range ch {
+	switch event := envelope.Event().(type) {
+	case ConfigurationEvent:
 		handler.logger.Info(
-			"processing event",
-			"id", envelope.ID(),
-			"class", envelope.Event().Class(),
-			"created", envelope.Created().Format(time.RFC3339),
-			"causation_id", envelope.CausationID(),
-			"data", envelope.Event(),
+			"updating API configuration",
+			"retries", event.Retries,
+			"timeout", event.Timeout,
 		)
 
-		switch event := envelope.Event().(type) {
-		case ConfigurationEvent:
-			handler.logger.Info(
-				"updating API configuration",
-				"retries", event.Retries,
-				"timeout", event.Timeout,
-			)
+		// store configuration
+		if event.Retries >= 0 {
+			handler.retries = uint(event.Retries)
+		}
+		if event.Timeout >= 0 {
+			handler.timeout = durationFromFloat(event.Timeout)
+		}
+		handler.logger.Info(
+			"updated API configuration",
+			"retries", handler.retries,
+			"timeout", handler.timeout,
+		)
 
-			// store configuration
-			if event.Retries >= 0 {
-				handler.retries = uint(event.Retries)
-			}
-			if event.Timeout >= 0 {
-				handler.timeout = durationFromFloat(event.Timeout)
-			}
-			handler.logger.Info(
-				"updated API configuration",
-				"retries", handler.retries,
-				"timeout", handler.timeout,
-			)
+	case RequestEvent:
+		handler.logger.Info("starting request processing")
 
-		case RequestEvent:
-			handler.logger.Info("starting request processing")
+		// create record to correlate the results with it
+		request := newRequestData(envelope, handler.retries, handler.timeout)
+		requests[envelope.ID()] = request
 
-			// create record to correlate the results with it
-			request := newRequestData(envelope, handler.retries, handler.timeout)
-			requests[envelope.ID()] = request
-
-			// try event processing asynchronously
+		// try event processing asynchronously
+		if !replaying {
 			handler.startApiCall(ctx, request)
+		}
 
-		case APIRequestEvent:
-			// fetch the request data
-			requestID := envelope.CausationID()
-			if requestID == 0 {
-				handler.logger.Error("event lacks a causation ID to locate the request")
-				break
-			}
-			request := requests[requestID]
-			if request == nil {
-				handler.logger.Error("failed to locate request data")
-				break
-			}
+	case APIRequestEvent:
+		// fetch the request data
+		requestID := envelope.CausationID()
+		if requestID == 0 {
+			handler.logger.Error("event lacks a causation ID to locate the request")
+			break
+		}
+		request := requests[requestID]
+		if request == nil {
+			handler.logger.Error("failed to locate request data")
+			break
+		}
 
-			// mark request as pending
-			request.attempts[event.Attempt] = state_pending
+		// mark request as pending
+		request.attempts[event.Attempt] = state_pending
 
-			handler.logger.Info(
-				"starting API call",
-				"attempt", event.Attempt,
-			)
+		handler.logger.Info(
+			"starting API call",
+			"attempt", event.Attempt,
+		)
 
-		case APIResponseEvent:
-			// fetch the request data
-			requestID := envelope.CausationID()
-			if requestID == 0 {
-				handler.logger.Error("event lacks a causation ID to locate the request")
-				break
-			}
-			request := requests[requestID]
-			if request == nil {
-				handler.logger.Error("failed to locate request data")
-				break
-			}
+	case APIResponseEvent:
+		// fetch the request data
+		requestID := envelope.CausationID()
+		if requestID == 0 {
+			handler.logger.Error("event lacks a causation ID to locate the request")
+			break
+		}
+		request := requests[requestID]
+		if request == nil {
+			handler.logger.Error("failed to locate request data")
+			break
+		}
 
-			// mark request as successful
-			request.attempts[event.Attempt] = state_success
-			handler.logger.Info("completed API call")
+		// mark request as successful
+		request.attempts[event.Attempt] = state_success
+		handler.logger.Info("completed API call")
 
-		case APIFailureEvent:
-			// fetch the request data
-			requestID := envelope.CausationID()
-			if requestID == 0 {
-				handler.logger.Error("event lacks a causation ID to locate the request")
-				break
-			}
-			request := requests[requestID]
-			if request == nil {
-				handler.logger.Error("failed to locate request event")
-				break
-			}
+	case APIFailureEvent:
+		// fetch the request data
+		requestID := envelope.CausationID()
+		if requestID == 0 {
+			handler.logger.Error("event lacks a causation ID to locate the request")
+			break
+		}
+		request := requests[requestID]
+		if request == nil {
+			handler.logger.Error("failed to locate request event")
+			break
+		}
 
-			// mark request as failed
-			request.attempts[event.Attempt] = state_failure
-			handler.logger.Info("failed API call")
+		// mark request as failed
+		request.attempts[event.Attempt] = state_failure
+		handler.logger.Info("failed API call")
 
-			// check if any retries remain
-			if event.Attempt == request.Retries() {
-				handler.logger.Info("retries exhausted")
-				break
-			}
+		// check if any retries remain
+		if event.Attempt == request.Retries() {
+			handler.logger.Info("retries exhausted")
+			break
+		}
 
-			// If a retry for this unsuccessful attempt was already made, there
-			// is nothing to do here. This happens when the timeout elapsed
-			// before the failure response was received.
-			if event.Attempt+1 != request.NextAttempt() {
-				handler.logger.Info("retry attempt already started")
-				break
-			}
+		// If a retry for this unsuccessful attempt was already made, there
+		// is nothing to do here. This happens when the timeout elapsed
+		// before the failure response was received.
+		if event.Attempt+1 != request.NextAttempt() {
+			handler.logger.Info("retry attempt already started")
+			break
+		}
 
-			// check if a retry or a previous attempt succeeded in the meantime
-			if request.Succeeded() {
-				handler.logger.Info("request already succeeded, no need for a retry")
-				break
-			}
+		// check if a retry or a previous attempt succeeded in the meantime
+		if request.Succeeded() {
+			handler.logger.Info("request already succeeded, no need for a retry")
+			break
+		}
 
-			// retry event processing asynchronously
+		// retry event processing asynchronously
+		if !replaying {
 			handler.startApiCall(ctx, request)
+		}
 
-		case APITimeoutEvent:
-			// fetch the request data
-			requestID := envelope.CausationID()
-			if requestID == 0 {
-				handler.logger.Error("timeout event lacks a causation ID to locate the request")
-				break
-			}
-			request := requests[requestID]
-			if request == nil {
-				handler.logger.Error("failed to locate request event")
-				break
-			}
+	case APITimeoutEvent:
+		// fetch the request data
+		requestID := envelope.CausationID()
+		if requestID == 0 {
+			handler.logger.Error("timeout event lacks a causation ID to locate the request")
+			break
+		}
+		request := requests[requestID]
+		if request == nil {
+			handler.logger.Error("failed to locate request event")
+			break
+		}
 
-			// A timeout event can only transition the state from "pending" to
-			// "timeout". Other states like "failure" or "success" are final.
-			if request.attempts[event.Attempt] != state_pending {
-				break
-			}
+		// A timeout event can only transition the state from "pending" to
+		// "timeout". Other states like "failure" or "success" are final.
+		if request.attempts[event.Attempt] != state_pending {
+			break
+		}
 
-			// mark request as timed out
-			request.attempts[event.Attempt] = state_timeout
-			handler.logger.Info("API call timed out")
+		// mark request as timed out
+		request.attempts[event.Attempt] = state_timeout
+		handler.logger.Info("API call timed out")
 
-			// check if any retries remain
-			if event.Attempt == request.Retries() {
-				handler.logger.Info("retries exhausted")
-				break
-			}
+		// check if any retries remain
+		if event.Attempt == request.Retries() {
+			handler.logger.Info("retries exhausted")
+			break
+		}
 
-			// If a retry for this unsuccessful attempt was already made, there
-			// is nothing to do here. This happens when the failure response was
-			// received before the timeout elapsed.
-			if event.Attempt+1 != request.NextAttempt() {
-				handler.logger.Info("retry attempt already started")
-				break
-			}
+		// If a retry for this unsuccessful attempt was already made, there
+		// is nothing to do here. This happens when the failure response was
+		// received before the timeout elapsed.
+		if event.Attempt+1 != request.NextAttempt() {
+			handler.logger.Info("retry attempt already started")
+			break
+		}
 
-			// check if a retry or a previous attempt succeeded in the meantime
-			if request.Succeeded() {
-				handler.logger.Info("request already succeeded, no need for a retry")
-				break
-			}
+		// check if a retry or a previous attempt succeeded in the meantime
+		if request.Succeeded() {
+			handler.logger.Info("request already succeeded, no need for a retry")
+			break
+		}
 
-			// retry event processing asynchronously
+		// retry event processing asynchronously
+		if !replaying {
 			handler.startApiCall(ctx, request)
 		}
 	}
+}
+
+// ProcessRequests processes request events from the store.
+// Without an explicit ID of the last processed event, this resumes after the
+// event recorded in the consumer's checkpoint. The checkpoint is saved
+// periodically and when the processing stops. The state is restored from the
+// latest snapshot, which is saved every SnapshotInterval events.
+func (handler *RequestProcessor) Run(ctx context.Context, lastProcessedID events.EventID) error {
+	explicit := lastProcessedID != 0
+	lastProcessedID, err := resumePosition(ctx, handler.store, handler.consumer, lastProcessedID)
+	if err != nil {
+		return err
+	}
+
+	// map of requests being processed currently
+	// Key is the event ID of the initial event (`RequestEvent`), which is
+	// used as causation ID in future events associated with this request.
+	requests := make(map[events.EventID]*requestData)
+
+	// Events up to the checkpoint were handled already. When resuming from a
+	// snapshot, the events between it and the checkpoint are replayed to
+	// restore the state, but without side effects. Afterwards, the attempts
+	// that were pending at the time of the restart are re-armed.
+	checkpoint := lastProcessedID
+	var snapshot *processorSnapshot
+	if !explicit {
+		snapshot, err = handler.loadSnapshot(ctx, checkpoint)
+		if err != nil {
+			return err
+		}
+	}
+	if snapshot != nil {
+		requests, err = handler.restoreSnapshot(ctx, snapshot)
+		if err != nil {
+			return err
+		}
+		handler.logger.Info("restored snapshot", "id", snapshot.EventID, "requests", len(requests))
+		if err := handler.replayEvents(ctx, snapshot.EventID, checkpoint, requests); err != nil {
+			return err
+		}
+		handler.rearmRequests(ctx, requests)
+	} else {
+		handler.retries, handler.timeout, err = loadConfiguration(ctx, handler.store, lastProcessedID)
+		if err != nil {
+			return err
+		}
+		if checkpoint != 0 {
+			if err := handler.logAbandonedRequests(ctx, checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+	if checkpoint != 0 {
+		handler.logger.Info("resuming processing", "after", checkpoint)
+	}
+
+	ch, err := handler.store.FollowEvents(ctx, lastProcessedID, events.Filter{Classes: requestClasses})
+	if err != nil {
+		return err
+	}
+
+	// number of events handled since the start
+	handled := 0
+	checkpoints := newCheckpointer(handler.store, handler.logger, handler.consumer, checkpoint)
+	defer checkpoints.Flush(ctx)
+
+	// process events from the channel
+	for envelope := range ch {
+		handler.handleEvent(ctx, envelope, requests, false)
+
+		// record that the event was handled
+		checkpoints.Handled(ctx, envelope.ID())
+
+		// save the state periodically
+		// A snapshot after the checkpoint would be ignored, so the checkpoint
+		// is saved before.
+		handled++
+		if handled%SnapshotInterval == 0 {
+			checkpoints.Flush(ctx)
+			handler.saveSnapshot(ctx, envelope.ID(), requests)
+		}
+	}
 
 	return handler.store.Error()
 }
@@ -408,22 +521,47 @@ func (handler *RequestProcessor) Run(ctx context.Context, lastProcessedID int32)
 type RequestWatcher struct {
 	store  events.EventStore
 	logger log15.Logger
+	// name under which the checkpoint is saved, empty to disable checkpoints
+	consumer string
 	// number of retries after a failed request
 	retries uint
 	// maximum duration before considering an attempt failed
 	timeout *time.Duration
 }
 
-func NewRequestWatcher(store events.EventStore, logger log15.Logger) (*RequestWatcher, error) {
+// NewRequestWatcher creates a RequestWatcher.
+// The consumer is the name under which it saves its checkpoint, see Run().
+// An empty name disables checkpoints.
+func NewRequestWatcher(store events.EventStore, logger log15.Logger, consumer string) (*RequestWatcher, error) {
 	return &RequestWatcher{
-		store:  store,
-		logger: logger,
+		store:    store,
+		logger:   logger,
+		consumer: consumer,
 	}, nil
 }
 
 // WatchRequests watches requests as they are processed
-func (handler *RequestWatcher) Run(ctx context.Context, lastProcessedID int32) error {
-	ch, err := handler.store.FollowEvents(ctx, lastProcessedID)
+// The filter can be used to restrict the events taken into account, e.g. to a
+// time range. Without classes selected, only the classes of events related to
+// requests are loaded. The filter must not drop events caused by the requests
+// it selects, otherwise their state is never updated.
+// Without an explicit ID of the last processed event, this resumes after the
+// event recorded in the consumer's checkpoint. The checkpoint is saved
+// periodically and when watching stops.
+func (handler *RequestWatcher) Run(ctx context.Context, lastProcessedID events.EventID, filter events.Filter) error {
+	if len(filter.Classes) == 0 {
+		filter.Classes = requestClasses
+	}
+	lastProcessedID, err := resumePosition(ctx, handler.store, handler.consumer, lastProcessedID)
+	if err != nil {
+		return err
+	}
+	handler.retries, handler.timeout, err = loadConfiguration(ctx, handler.store, lastProcessedID)
+	if err != nil {
+		return err
+	}
+
+	ch, err := handler.store.FollowEvents(ctx, lastProcessedID, filter)
 	if err != nil {
 		return err
 	}
@@ -431,7 +569,10 @@ func (handler *RequestWatcher) Run(ctx context.Context, lastProcessedID int32) e
 	// map of requests being processed currently
 	// Key is the event ID of the initial event (`RequestEvent`), which is
 	// used as causation ID in future events associated with this request.
-	requests := make(map[int32]*requestData)
+	requests := make(map[events.EventID]*requestData)
+
+	checkpoints := newCheckpointer(handler.store, handler.logger, handler.consumer, lastProcessedID)
+	defer checkpoints.Flush(ctx)
 
 	// process events from the channel
 	for envelope := range ch {
@@ -552,7 +693,15 @@ func (handler *RequestWatcher) Run(ctx context.Context, lastProcessedID int32) e
 				"state", request.State(),
 				"attempt", event.Attempt,
 			)
+
+		case events.RawEvent:
+			// event of a class unknown to this version, e.g. from a newer
+			// producer, which the filter selected
+			handler.logger.Debug("ignoring event of unknown class", "id", envelope.ID(), "class", event.Class())
 		}
+
+		// record that the event was handled
+		checkpoints.Handled(ctx, envelope.ID())
 	}
 
 	return handler.store.Error()
@@ -1,17 +1,23 @@
 package broker
 
 import (
+	"api-broker-prototype/api"
 	"api-broker-prototype/events"
+	"api-broker-prototype/memory"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/inconshreveable/log15"
 )
 
 // mock for the events.Envelope interface
 type envelopeMock struct{}
 
-func (envelope envelopeMock) ID() int32 {
+func (envelope envelopeMock) ID() events.EventID {
 	return 42
 }
 
@@ -23,16 +29,54 @@ func (envelope envelopeMock) Created() time.Time {
 	return time.Now()
 }
 
-func (envelope envelopeMock) CausationID() int32 {
+func (envelope envelopeMock) CausationID() events.EventID {
 	return 23
 }
 
+func (envelope envelopeMock) CorrelationID() events.EventID {
+	return 0
+}
+
+func (envelope envelopeMock) Metadata() map[string]string {
+	return map[string]string{"actor": "test"}
+}
+
+func (envelope envelopeMock) Stream() string {
+	return ""
+}
+
+func (envelope envelopeMock) Version() int32 {
+	return 0
+}
+
 func (envelope envelopeMock) Event() events.Event {
 	return RequestEvent{
 		Request: "test request data",
 	}
 }
 
+// wait until the checkpoint of the consumer reaches the event
+func waitForCheckpoint(t *testing.T, store events.CheckpointStore, consumer string, id events.EventID) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for {
+		checkpoint, err := store.LoadCheckpoint(ctx, consumer)
+		if err != nil {
+			t.Fatalf("failed to load checkpoint: %v", err)
+		}
+		if checkpoint >= id {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("checkpoint not updated, still at %d", checkpoint)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
 func TestRequestData(t *testing.T) {
 	envelope := envelopeMock{}
 	retries := uint(1)
@@ -51,6 +95,12 @@ func TestRequestData(t *testing.T) {
 		if request.ID() != 42 {
 			t.Errorf("ID() is unexpected")
 		}
+		if request.CorrelationID() != 42 {
+			t.Errorf("CorrelationID() is unexpected")
+		}
+		if request.Metadata()["actor"] != "test" {
+			t.Errorf("Metadata() is unexpected")
+		}
 		if len(request.attempts) != 2 {
 			t.Errorf("attempt count is unexpected")
 		}
@@ -207,3 +257,336 @@ func TestRequestData(t *testing.T) {
 		}
 	})
 }
+
+func TestRequestProcessor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// simulate an API that always fails
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	previousURL := api.URL()
+	t.Cleanup(func() {
+		api.Configure(previousURL)
+	})
+	api.Configure(server.URL)
+
+	store, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	processor, err := NewRequestProcessor(store, logger, "")
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	go processor.Run(ctx, 0)
+
+	// configure a single retry and issue a request
+	if _, err := store.Insert(ctx, uuid.Nil, ConfigurationEvent{Retries: 1, Timeout: 0}, 0); err != nil {
+		t.Fatalf("failed to insert configuration: %v", err)
+	}
+	requests, err := store.InsertMany(ctx, []events.NewEvent{{
+		Event:         RequestEvent{Request: "test"},
+		CorrelationID: 7,
+		Metadata:      map[string]string{"actor": "test"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to insert request: %v", err)
+	}
+	request := requests[0]
+
+	ch, err := store.FollowEvents(ctx, request.ID(), events.Filter{})
+	if err != nil {
+		t.Fatalf("failed to follow events: %v", err)
+	}
+
+	// expect two failed attempts
+	failures := uint(0)
+	for envelope := range ch {
+		if envelope.CausationID() != request.ID() {
+			t.Errorf("unexpected causation ID %d", envelope.CausationID())
+		}
+		if envelope.CorrelationID() != 7 {
+			t.Errorf("unexpected correlation ID %d", envelope.CorrelationID())
+		}
+		if envelope.Metadata()["actor"] != "test" {
+			t.Errorf("unexpected metadata %v", envelope.Metadata())
+		}
+		event, ok := envelope.Event().(APIFailureEvent)
+		if !ok {
+			continue
+		}
+		if event.Attempt != failures {
+			t.Errorf("unexpected attempt %d", event.Attempt)
+		}
+		failures++
+		if failures == 2 {
+			break
+		}
+	}
+	if failures != 2 {
+		t.Errorf("unexpected number of failures %d", failures)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	configuration, err := store.Insert(ctx, uuid.Nil, ConfigurationEvent{Retries: 2, Timeout: 1.5}, 0)
+	if err != nil {
+		t.Fatalf("failed to insert configuration: %v", err)
+	}
+	if err := store.SaveCheckpoint(ctx, "test", configuration.ID()); err != nil {
+		t.Fatalf("failed to save checkpoint: %v", err)
+	}
+
+	// an explicit position takes precedence over the checkpoint
+	for _, c := range []struct {
+		consumer        string
+		lastProcessedID events.EventID
+		expected        events.EventID
+	}{
+		{"test", 0, configuration.ID()},
+		{"test", 42, 42},
+		{"other", 0, 0},
+		{"", 0, 0},
+	} {
+		id, err := resumePosition(ctx, store, c.consumer, c.lastProcessedID)
+		if err != nil || id != c.expected {
+			t.Errorf("unexpected position %d (error %v) for %q", id, err, c.consumer)
+		}
+	}
+
+	// the configuration before the checkpoint is restored
+	retries, timeout, err := loadConfiguration(ctx, store, configuration.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if retries != 2 || timeout == nil || *timeout != 1500*time.Millisecond {
+		t.Errorf("unexpected configuration %d %v", retries, timeout)
+	}
+
+	// the processor resumes and saves the checkpoint after every event
+	processor, err := NewRequestProcessor(store, logger, "test")
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	go processor.Run(ctx, 0)
+
+	next, err := store.Insert(ctx, uuid.Nil, ConfigurationEvent{Retries: 1, Timeout: -1}, 0)
+	if err != nil {
+		t.Fatalf("failed to insert configuration: %v", err)
+	}
+	waitForCheckpoint(t, store, "test", next.ID())
+}
+
+func TestAbandonedRequests(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	insert := func(event events.Event, causationID events.EventID) events.EventID {
+		envelope, err := store.Insert(ctx, uuid.Nil, event, causationID)
+		if err != nil {
+			t.Fatalf("failed to insert event: %v", err)
+		}
+		return envelope.ID()
+	}
+
+	// one request is finished, the other one has a pending first attempt
+	insert(ConfigurationEvent{Retries: 1, Timeout: 0}, 0)
+	finished := insert(RequestEvent{Request: "finished"}, 0)
+	insert(APIRequestEvent{Attempt: 0}, finished)
+	insert(APIResponseEvent{Attempt: 0}, finished)
+	pending := insert(RequestEvent{Request: "pending"}, 0)
+	checkpoint := insert(APIRequestEvent{Attempt: 0}, pending)
+
+	// only the unfinished request is logged
+	var records []*log15.Record
+	logger := log15.New()
+	logger.SetHandler(log15.FuncHandler(func(r *log15.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+	processor, err := NewRequestProcessor(store, logger, "test")
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	if err := processor.logAbandonedRequests(ctx, checkpoint); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(records) != 1 || records[0].Lvl != log15.LvlWarn || records[0].Ctx[1] != pending {
+		t.Errorf("unexpected log records %v", records)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	insert := func(event events.Event, causationID events.EventID) events.EventID {
+		envelope, err := store.Insert(ctx, uuid.Nil, event, causationID)
+		if err != nil {
+			t.Fatalf("failed to insert event: %v", err)
+		}
+		return envelope.ID()
+	}
+
+	// one request is finished, the other one has a pending first attempt
+	insert(ConfigurationEvent{Retries: 1, Timeout: 0.1}, 0)
+	finished := insert(RequestEvent{Request: "finished"}, 0)
+	insert(APIRequestEvent{Attempt: 0}, finished)
+	insert(APIResponseEvent{Attempt: 0}, finished)
+	pending := insert(RequestEvent{Request: "pending"}, 0)
+	last := insert(APIRequestEvent{Attempt: 0}, pending)
+
+	processor, err := NewRequestProcessor(store, logger, "test")
+	if err != nil {
+		t.Fatalf("failed to create processor: %v", err)
+	}
+	timeout := 100 * time.Millisecond
+	requests := make(map[events.EventID]*requestData)
+	for _, id := range []events.EventID{finished, pending} {
+		envelope, err := store.RetrieveOne(ctx, id)
+		if err != nil {
+			t.Fatalf("failed to retrieve request: %v", err)
+		}
+		requests[id] = newRequestData(envelope, 1, &timeout)
+	}
+	requests[finished].attempts[0] = state_success
+	requests[pending].attempts[0] = state_pending
+	processor.retries = 1
+	processor.timeout = &timeout
+	processor.saveSnapshot(ctx, last, requests)
+
+	// finished requests are left out of the snapshot, but kept in memory
+	if len(requests) != 2 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+
+	// the snapshot is only used up to the checkpoint
+	if snapshot, err := processor.loadSnapshot(ctx, last-1); err != nil || snapshot != nil {
+		t.Errorf("unexpected snapshot %v (error %v)", snapshot, err)
+	}
+	snapshot, err := processor.loadSnapshot(ctx, last)
+	if err != nil || snapshot == nil {
+		t.Fatalf("unexpected snapshot %v (error %v)", snapshot, err)
+	}
+	if snapshot.EventID != last {
+		t.Errorf("unexpected snapshot ID %d", snapshot.EventID)
+	}
+
+	// restoring the snapshot gives the same state
+	processor.retries = 0
+	processor.timeout = nil
+	restored, err := processor.restoreSnapshot(ctx, snapshot)
+	if err != nil {
+		t.Fatalf("failed to restore snapshot: %v", err)
+	}
+	if processor.retries != 1 || processor.timeout == nil || *processor.timeout != timeout {
+		t.Errorf("unexpected configuration %d %v", processor.retries, processor.timeout)
+	}
+	request := restored[pending]
+	if len(restored) != 1 || request == nil {
+		t.Fatalf("unexpected requests %v", restored)
+	}
+	if request.Event().Request != "pending" || request.NextAttempt() != 1 || request.Timeout() == nil || *request.Timeout() != timeout {
+		t.Errorf("unexpected request %v", request)
+	}
+
+	// the events between snapshot and checkpoint are replayed without starting
+	// API calls, the retry after the failure was started before the restart
+	// and is re-armed to time out
+	insert(APIFailureEvent{Attempt: 0}, pending)
+	retry := insert(APIRequestEvent{Attempt: 1}, pending)
+	if err := store.SaveCheckpoint(ctx, "test", retry); err != nil {
+		t.Fatalf("failed to save checkpoint: %v", err)
+	}
+	go processor.Run(ctx, 0)
+
+	next := insert(ConfigurationEvent{Retries: -1, Timeout: -1}, 0)
+	waitForCheckpoint(t, store, "test", next)
+	followCtx, followCancel := context.WithCancel(ctx)
+	defer followCancel()
+	ch, err := store.FollowEvents(followCtx, retry, events.Filter{Classes: []string{APITimeoutEvent{}.Class()}})
+	if err != nil {
+		t.Fatalf("failed to follow events: %v", err)
+	}
+	envelope, ok := <-ch
+	if !ok {
+		t.Fatalf("timeout event missing")
+	}
+	if event, ok := envelope.Event().(APITimeoutEvent); !ok || event.Attempt != 1 || envelope.CausationID() != pending {
+		t.Errorf("unexpected event %v", envelope)
+	}
+
+	ch, err = store.LoadEvents(ctx, events.Range{StartAfter: retry}, events.Filter{Classes: []string{APIRequestEvent{}.Class()}})
+	if err != nil {
+		t.Fatalf("failed to load events: %v", err)
+	}
+	for envelope := range ch {
+		t.Errorf("unexpected API request %d", envelope.ID())
+	}
+}
+
+func TestRawEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store, err := memory.NewEventStore()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+
+	// the filter of the watcher selects a class unknown to it, its events are
+	// ignored, but the watcher moves past them
+	watcher, err := NewRequestWatcher(store, logger, "test")
+	if err != nil {
+		t.Fatalf("failed to create watcher: %v", err)
+	}
+	go watcher.Run(ctx, 0, events.Filter{Classes: append([]string{"future"}, requestClasses...)})
+
+	if _, err := store.Insert(ctx, uuid.Nil, events.RawEvent{EventClass: "future", Payload: events.Payload{"text": "value"}}, 0); err != nil {
+		t.Fatalf("failed to insert event: %v", err)
+	}
+	request, err := store.Insert(ctx, uuid.Nil, RequestEvent{Request: "test"}, 0)
+	if err != nil {
+		t.Fatalf("failed to insert request: %v", err)
+	}
+	waitForCheckpoint(t, store, "test", request.ID())
+}
@@ -0,0 +1,251 @@
+package broker
+
+// This file implements snapshots of the state of the request processor.
+//
+// Checkpoints alone only allow restoring the configuration, because replaying
+// all requests from the beginning would take too long for large stores. So,
+// the processor periodically saves the requests it is still working on and
+// the configuration as snapshot, if the store supports that. When it resumes
+// from its checkpoint, it restores the state from the snapshot and replays the
+// events between the snapshot and the checkpoint without side effects, i.e.
+// without starting API calls for them.
+//
+// Requests that are finished are left out of the snapshot. The API calls that
+// were pending at the time of the restart were lost with the previous
+// process, so their outcome is never stored. Instead, they are re-armed to
+// time out after the configured timeout, or immediately without one, which
+// then starts a retry if any remain.
+
+import (
+	"api-broker-prototype/events"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/inconshreveable/log15"
+)
+
+// SnapshotInterval is the number of events after which the RequestProcessor
+// saves a snapshot of its state.
+const SnapshotInterval = 1000
+
+// version of the format of the processor snapshots
+// Snapshots with a different version are ignored.
+const processorSnapshotVersion = 1
+
+// serialized state of the request processor
+type processorSnapshot struct {
+	Version  int               `json:"version"`
+	Retries  uint              `json:"retries"`
+	Timeout  *time.Duration    `json:"timeout,omitempty"`
+	Requests []requestSnapshot `json:"requests"`
+	// ID of the last event reflected in the snapshot
+	EventID events.EventID `json:"-"`
+}
+
+// serialized state of a request
+// The request event itself is loaded from the store when restoring it.
+type requestSnapshot struct {
+	ID       events.EventID `json:"id"`
+	Attempts []requestState `json:"attempts"`
+	Timeout  *time.Duration `json:"timeout,omitempty"`
+}
+
+// query whether the processor has nothing left to do for the request
+// That is the case when an attempt succeeded or when all attempts were made
+// and failed or timed out.
+func (request *requestData) Finished() bool {
+	if request.Succeeded() {
+		return true
+	}
+	for _, val := range request.attempts {
+		if val != state_failure && val != state_timeout {
+			return false
+		}
+	}
+	return true
+}
+
+// load the latest snapshot of the processor state
+// This returns nil if there is no usable snapshot, e.g. because the store
+// doesn't support them or the snapshot is more recent than the checkpoint.
+func (handler *RequestProcessor) loadSnapshot(ctx context.Context, checkpoint events.EventID) (*processorSnapshot, error) {
+	if handler.consumer == "" || checkpoint == 0 {
+		return nil, nil
+	}
+	snapshots, ok := handler.store.(events.SnapshotStore)
+	if !ok {
+		return nil, nil
+	}
+	snapshot, err := snapshots.LoadSnapshot(ctx, handler.consumer)
+	if errors.Is(err, events.SnapshotsNotSupported) {
+		return nil, nil
+	}
+	if err != nil || snapshot == nil {
+		return nil, err
+	}
+
+	// A snapshot after the checkpoint remains when the checkpoint was moved
+	// back, so the events in between must be handled again.
+	if snapshot.EventID > checkpoint {
+		handler.logger.Info("ignoring snapshot after checkpoint", "id", snapshot.EventID, "checkpoint", checkpoint)
+		return nil, nil
+	}
+
+	var res processorSnapshot
+	if err := json.Unmarshal(snapshot.Data, &res); err != nil {
+		handler.logger.Warn("ignoring invalid snapshot", "id", snapshot.EventID, "error", err)
+		return nil, nil
+	}
+	if res.Version != processorSnapshotVersion {
+		handler.logger.Warn("ignoring snapshot with unknown version", "id", snapshot.EventID, "version", res.Version)
+		return nil, nil
+	}
+	res.EventID = snapshot.EventID
+	return &res, nil
+}
+
+// restore the processor state from the snapshot
+// This sets the configuration and returns the requests being processed.
+func (handler *RequestProcessor) restoreSnapshot(ctx context.Context, snapshot *processorSnapshot) (map[events.EventID]*requestData, error) {
+	handler.retries = snapshot.Retries
+	handler.timeout = snapshot.Timeout
+
+	requests := make(map[events.EventID]*requestData, len(snapshot.Requests))
+	for _, request := range snapshot.Requests {
+		envelope, err := handler.store.RetrieveOne(ctx, request.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load request %d from snapshot: %w", request.ID, err)
+		}
+		if _, ok := envelope.Event().(RequestEvent); !ok {
+			return nil, fmt.Errorf("event %d from snapshot is no request", request.ID)
+		}
+		requests[request.ID] = &requestData{
+			envelope: envelope,
+			attempts: request.Attempts,
+			timeout:  request.Timeout,
+		}
+	}
+	return requests, nil
+}
+
+// log the unfinished requests from before the checkpoint
+// Without a snapshot, only the configuration is restored, so these requests
+// are never retried or timed out. Finding them requires replaying the events
+// up to the checkpoint, which is only done when there is no snapshot yet.
+func (handler *RequestProcessor) logAbandonedRequests(ctx context.Context, checkpoint events.EventID) error {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	replay := &RequestProcessor{store: handler.store, logger: logger}
+
+	requests := make(map[events.EventID]*requestData)
+	ch, err := handler.store.LoadEvents(
+		ctx,
+		events.Range{StartBefore: checkpoint + 1},
+		events.Filter{Classes: requestClasses},
+	)
+	if err != nil {
+		return err
+	}
+	for envelope := range ch {
+		replay.handleEvent(ctx, envelope, requests, true)
+	}
+	if err := handler.store.Error(); err != nil {
+		return err
+	}
+
+	var abandoned []events.EventID
+	for id, request := range requests {
+		if !request.Finished() {
+			abandoned = append(abandoned, id)
+		}
+	}
+	slices.Sort(abandoned)
+	for _, id := range abandoned {
+		handler.logger.Warn("abandoning unfinished request from before the checkpoint", "request", id, "state", requests[id].State(), "checkpoint", checkpoint)
+	}
+	return nil
+}
+
+// replay the events between the snapshot and the checkpoint
+// This updates the restored requests without side effects.
+func (handler *RequestProcessor) replayEvents(ctx context.Context, snapshotID events.EventID, checkpoint events.EventID, requests map[events.EventID]*requestData) error {
+	ch, err := handler.store.LoadEvents(
+		ctx,
+		events.Range{StartAfter: snapshotID, StartBefore: checkpoint + 1},
+		events.Filter{Classes: requestClasses},
+	)
+	if err != nil {
+		return err
+	}
+	for envelope := range ch {
+		handler.handleEvent(ctx, envelope, requests, true)
+	}
+	return handler.store.Error()
+}
+
+// re-arm the requests whose latest attempt is pending after a restart
+// A timeout event is inserted for the attempt after the timeout of the
+// request, or immediately if it has none. If the outcome of the attempt was
+// stored before the restart, the timeout is ignored.
+func (handler *RequestProcessor) rearmRequests(ctx context.Context, requests map[events.EventID]*requestData) {
+	for _, request := range requests {
+		attempt := request.NextAttempt()
+		if request.Finished() || attempt == 0 || request.attempts[attempt-1] != state_pending {
+			continue
+		}
+		var timeout time.Duration
+		if request.Timeout() != nil {
+			timeout = *request.Timeout()
+		}
+		handler.logger.Info("re-arming pending attempt", "request", request.ID(), "attempt", attempt-1, "timeout", timeout)
+		handler.scheduleTimeout(ctx, request, attempt-1, timeout)
+	}
+}
+
+// save a snapshot of the processor state after the given event
+// Finished requests are left out. A failure is only logged, because it just
+// means that more events are replayed after a restart.
+func (handler *RequestProcessor) saveSnapshot(ctx context.Context, id events.EventID, requests map[events.EventID]*requestData) {
+	if handler.consumer == "" {
+		return
+	}
+	snapshots, ok := handler.store.(events.SnapshotStore)
+	if !ok {
+		return
+	}
+
+	snapshot := processorSnapshot{
+		Version:  processorSnapshotVersion,
+		Retries:  handler.retries,
+		Timeout:  handler.timeout,
+		Requests: make([]requestSnapshot, 0, len(requests)),
+	}
+	for requestID, request := range requests {
+		if request.Finished() {
+			continue
+		}
+		snapshot.Requests = append(snapshot.Requests, requestSnapshot{
+			ID:       requestID,
+			Attempts: request.attempts,
+			Timeout:  request.timeout,
+		})
+	}
+	slices.SortFunc(snapshot.Requests, func(a, b requestSnapshot) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		handler.logger.Error("failed to encode snapshot", "id", id, "error", err)
+		return
+	}
+	err = snapshots.SaveSnapshot(ctx, handler.consumer, id, data)
+	if err != nil && !errors.Is(err, events.SnapshotsNotSupported) && ctx.Err() == nil {
+		handler.logger.Error("failed to save snapshot", "consumer", handler.consumer, "id", id, "error", err)
+	}
+}
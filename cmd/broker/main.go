@@ -4,13 +4,18 @@ import (
 	"api-broker-prototype/api"
 	"api-broker-prototype/broker"
 	"api-broker-prototype/events"
+	"api-broker-prototype/filelog"
 	"api-broker-prototype/logging"
+	"api-broker-prototype/memory"
 	"api-broker-prototype/mongodb"
 	"api-broker-prototype/postgresql"
+	"api-broker-prototype/sqlite"
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -19,10 +24,37 @@ import (
 )
 
 var (
-	eventStoreDriver   string
-	eventStoreDBHost   string
-	eventStoreLoglevel string
-	logger             log15.Logger
+	eventStoreDriver                 string
+	eventStoreDBDSN                  string
+	eventStoreDBHost                 string
+	eventStoreDBPort                 string
+	eventStoreDBUser                 string
+	eventStoreDBPassword             string
+	eventStoreDBPasswordFile         string
+	eventStoreDBName                 string
+	eventStoreDBSSLMode              string
+	eventStoreDBSSLCert              string
+	eventStoreDBSSLKey               string
+	eventStoreDBSSLRootCert          string
+	eventStoreDBEventCollection      string
+	eventStoreDBNoteCollection       string
+	eventStoreDBCounterCollection    string
+	eventStoreDBVersionCollection    string
+	eventStoreDBCheckpointCollection string
+	eventStoreDBSnapshotCollection   string
+	eventStoreDBQuarantineCollection string
+	eventStoreDBFollowMode           string
+	eventStoreDBConnectTimeout       time.Duration
+	eventStoreDBPath                 string
+	eventStoreLoglevel               string
+	eventStoreMigrate                bool
+	eventStoreDecodePolicy           string
+	eventStoreEncoding               string
+	eventStorePoolMaxConns           int
+	eventStorePoolMinConns           int
+	eventStorePoolMaxConnIdleTime    time.Duration
+	eventStorePoolHealthCheckPeriod  time.Duration
+	logger                           log15.Logger
 )
 
 func main() {
@@ -39,16 +71,156 @@ func main() {
 				Name:        "eventstore-driver",
 				EnvVars:     []string{"EVENTSTORE_DRIVER"},
 				Value:       "mongodb",
-				Usage:       "Driver for the event store, one of [mongodb, postgresql].",
+				Usage:       "Driver for the event store, one of [mongodb, postgresql, sqlite, filelog, memory].",
 				Destination: &eventStoreDriver,
 			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-dsn",
+				EnvVars:     []string{"EVENTSTORE_DB_DSN"},
+				Value:       "",
+				Usage:       "Connection string for the DB server, a URI (mongodb) or a URL or keyword/value string (postgresql).",
+				Destination: &eventStoreDBDSN,
+			},
 			&cli.StringFlag{
 				Name:        "eventstore-db-host",
 				EnvVars:     []string{"EVENTSTORE_DB_HOST"},
-				Value:       "localhost",
-				Usage:       "Hostname of the DB server for the event store.",
+				Value:       "",
+				Usage:       "Hostname of the DB server for the event store (default: localhost).",
 				Destination: &eventStoreDBHost,
 			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-port",
+				EnvVars:     []string{"EVENTSTORE_DB_PORT"},
+				Value:       "",
+				Usage:       "TCP port of the DB server (postgresql only, default: 5432).",
+				Destination: &eventStoreDBPort,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-user",
+				EnvVars:     []string{"EVENTSTORE_DB_USER"},
+				Value:       "",
+				Usage:       "Name of the DB user (postgresql only, default: postgres).",
+				Destination: &eventStoreDBUser,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-password",
+				EnvVars:     []string{"EVENTSTORE_DB_PASSWORD"},
+				Value:       "",
+				Usage:       "Password of the DB user (postgresql only, default: postgres).",
+				Destination: &eventStoreDBPassword,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-password-file",
+				EnvVars:     []string{"EVENTSTORE_DB_PASSWORD_FILE"},
+				Value:       "",
+				Usage:       "File containing the password of the DB user (postgresql only).",
+				Destination: &eventStoreDBPasswordFile,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-name",
+				EnvVars:     []string{"EVENTSTORE_DB_NAME"},
+				Value:       "",
+				Usage:       "Name of the DB (default: test for mongodb, postgres for postgresql).",
+				Destination: &eventStoreDBName,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-sslmode",
+				EnvVars:     []string{"EVENTSTORE_DB_SSLMODE"},
+				Value:       "",
+				Usage:       "TLS mode for the DB connection, e.g. disable, require or verify-full (postgresql only).",
+				Destination: &eventStoreDBSSLMode,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-sslcert",
+				EnvVars:     []string{"EVENTSTORE_DB_SSLCERT"},
+				Value:       "",
+				Usage:       "File containing the client certificate for the DB connection (postgresql only).",
+				Destination: &eventStoreDBSSLCert,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-sslkey",
+				EnvVars:     []string{"EVENTSTORE_DB_SSLKEY"},
+				Value:       "",
+				Usage:       "File containing the key of the client certificate (postgresql only).",
+				Destination: &eventStoreDBSSLKey,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-sslrootcert",
+				EnvVars:     []string{"EVENTSTORE_DB_SSLROOTCERT"},
+				Value:       "",
+				Usage:       "File containing the CA certificates to verify the DB server (postgresql only).",
+				Destination: &eventStoreDBSSLRootCert,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-event-collection",
+				EnvVars:     []string{"EVENTSTORE_DB_EVENT_COLLECTION"},
+				Value:       "",
+				Usage:       "Name of the collection with the events (mongodb only, default: events).",
+				Destination: &eventStoreDBEventCollection,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-notification-collection",
+				EnvVars:     []string{"EVENTSTORE_DB_NOTIFICATION_COLLECTION"},
+				Value:       "",
+				Usage:       "Name of the collection with the notifications (mongodb only, default: notifications).",
+				Destination: &eventStoreDBNoteCollection,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-counter-collection",
+				EnvVars:     []string{"EVENTSTORE_DB_COUNTER_COLLECTION"},
+				Value:       "",
+				Usage:       "Name of the collection with the ID counters (mongodb only, default: counters).",
+				Destination: &eventStoreDBCounterCollection,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-checkpoint-collection",
+				EnvVars:     []string{"EVENTSTORE_DB_CHECKPOINT_COLLECTION"},
+				Value:       "",
+				Usage:       "Name of the collection with the consumer checkpoints (mongodb only, default: checkpoints).",
+				Destination: &eventStoreDBCheckpointCollection,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-snapshot-collection",
+				EnvVars:     []string{"EVENTSTORE_DB_SNAPSHOT_COLLECTION"},
+				Value:       "",
+				Usage:       "Name of the collection with the consumer snapshots (mongodb only, default: snapshots).",
+				Destination: &eventStoreDBSnapshotCollection,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-quarantine-collection",
+				EnvVars:     []string{"EVENTSTORE_DB_QUARANTINE_COLLECTION"},
+				Value:       "",
+				Usage:       "Name of the collection with the quarantined events (mongodb only, default: quarantine).",
+				Destination: &eventStoreDBQuarantineCollection,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-schema-version-collection",
+				EnvVars:     []string{"EVENTSTORE_DB_SCHEMA_VERSION_COLLECTION"},
+				Value:       "",
+				Usage:       "Name of the collection with the schema versions (mongodb only, default: schema_version).",
+				Destination: &eventStoreDBVersionCollection,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-follow-mode",
+				EnvVars:     []string{"EVENTSTORE_DB_FOLLOW_MODE"},
+				Value:       "",
+				Usage:       "How to follow new events, one of [capped, changestream] (mongodb only, default: capped).",
+				Destination: &eventStoreDBFollowMode,
+			},
+			&cli.DurationFlag{
+				Name:        "eventstore-db-connect-timeout",
+				EnvVars:     []string{"EVENTSTORE_DB_CONNECT_TIMEOUT"},
+				Value:       0,
+				Usage:       "Timeout for connecting to the DB server (mongodb only, 0 for the default of 1s).",
+				Destination: &eventStoreDBConnectTimeout,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-db-path",
+				EnvVars:     []string{"EVENTSTORE_DB_PATH"},
+				Value:       "",
+				Usage:       "Path of the DB file (sqlite) or directory (filelog) for the event store.",
+				Destination: &eventStoreDBPath,
+			},
 			&cli.StringFlag{
 				Name:        "eventstore-loglevel",
 				EnvVars:     []string{"EVENTSTORE_LOGLEVEL"},
@@ -56,6 +228,55 @@ func main() {
 				Usage:       "Minimum loglevel for event store operations.",
 				Destination: &eventStoreLoglevel,
 			},
+			&cli.BoolFlag{
+				Name:        "eventstore-auto-migrate",
+				EnvVars:     []string{"EVENTSTORE_AUTO_MIGRATE"},
+				Value:       false,
+				Usage:       "Create or update the DB schema of the event store automatically.",
+				Destination: &eventStoreMigrate,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-decode-policy",
+				EnvVars:     []string{"EVENTSTORE_DECODE_POLICY"},
+				Value:       "fail",
+				Usage:       "How to handle stored events that can't be decoded, one of [fail, skip, quarantine] (not for memory).",
+				Destination: &eventStoreDecodePolicy,
+			},
+			&cli.StringFlag{
+				Name:        "eventstore-encoding",
+				EnvVars:     []string{"EVENTSTORE_ENCODING"},
+				Value:       "json",
+				Usage:       "Encoding of the payloads of new events, one of [json, cbor] (not for memory).",
+				Destination: &eventStoreEncoding,
+			},
+			&cli.IntFlag{
+				Name:        "eventstore-pool-max-conns",
+				EnvVars:     []string{"EVENTSTORE_POOL_MAX_CONNS"},
+				Value:       0,
+				Usage:       "Maximum number of pooled DB connections (postgresql only, 0 for the default).",
+				Destination: &eventStorePoolMaxConns,
+			},
+			&cli.IntFlag{
+				Name:        "eventstore-pool-min-conns",
+				EnvVars:     []string{"EVENTSTORE_POOL_MIN_CONNS"},
+				Value:       0,
+				Usage:       "Minimum number of pooled DB connections kept open (postgresql only).",
+				Destination: &eventStorePoolMinConns,
+			},
+			&cli.DurationFlag{
+				Name:        "eventstore-pool-max-idle-time",
+				EnvVars:     []string{"EVENTSTORE_POOL_MAX_IDLE_TIME"},
+				Value:       0,
+				Usage:       "Duration after which idle pooled DB connections are closed (postgresql only, 0 for the default).",
+				Destination: &eventStorePoolMaxConnIdleTime,
+			},
+			&cli.DurationFlag{
+				Name:        "eventstore-pool-health-check-period",
+				EnvVars:     []string{"EVENTSTORE_POOL_HEALTH_CHECK_PERIOD"},
+				Value:       0,
+				Usage:       "Interval between health checks of idle pooled DB connections (postgresql only, 0 for the default).",
+				Destination: &eventStorePoolHealthCheckPeriod,
+			},
 		},
 		Commands: []*cli.Command{
 			{
@@ -105,6 +326,25 @@ func main() {
 						Value: "0",
 						Usage: "`ID` of the event to register as causation",
 					},
+					&cli.StringFlag{
+						Name:  "correlation",
+						Value: "0",
+						Usage: "`ID` of the event to register as correlation",
+					},
+					&cli.StringSliceFlag{
+						Name:  "metadata",
+						Usage: "`KEY=VALUE` pair to attach as metadata, can be repeated",
+					},
+					&cli.StringFlag{
+						Name:  "stream",
+						Value: "",
+						Usage: "`NAME` of the stream to append the event to",
+					},
+					&cli.IntFlag{
+						Name:  "expected-version",
+						Value: int(events.AnyVersion),
+						Usage: "`VERSION` the stream must have before appending, -1 for any",
+					},
 				},
 				Action: func(c *cli.Context) error {
 					args := c.Args()
@@ -115,26 +355,123 @@ func main() {
 					if err != nil {
 						return err
 					}
-					return insertMain(c.Context, args.Get(0), args.Get(1), externalUUID, c.String("causation"))
+					metadata, err := parseMetadata(c.StringSlice("metadata"))
+					if err != nil {
+						return err
+					}
+					return insertMain(c.Context, args.Get(0), args.Get(1), externalUUID, c.String("causation"), c.String("correlation"), metadata, c.String("stream"), int32(c.Int("expected-version")))
 				},
 			},
 			{
 				Name:      "list",
 				Usage:     "List all events in the store.",
 				ArgsUsage: " ", // no arguments expected
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:  "start-after",
-						Value: "",
-						Usage: "`ID` of the event after which to start processing",
+				Flags: append(
+					[]cli.Flag{
+						&cli.StringFlag{
+							Name:  "start-after",
+							Value: "",
+							Usage: "`ID` of the event after which to start processing",
+						},
+						&cli.StringFlag{
+							Name:  "start-before",
+							Value: "",
+							Usage: "`ID` of the event before which to stop processing",
+						},
+						&cli.IntFlag{
+							Name:  "limit",
+							Value: 0,
+							Usage: "maximum `NUMBER` of events to list, zero for no limit",
+						},
+						&cli.BoolFlag{
+							Name:  "reverse",
+							Usage: "list the events newest first",
+						},
+						&cli.IntFlag{
+							Name:  "last",
+							Value: 0,
+							Usage: "list only the last `NUMBER` of events, oldest first",
+						},
+						&cli.StringFlag{
+							Name:  "stream",
+							Value: "",
+							Usage: "`NAME` of the stream to list instead of all events",
+						},
+						&cli.IntFlag{
+							Name:  "from-version",
+							Value: 1,
+							Usage: "`VERSION` of the first event to list from the stream",
+						},
 					},
+					filterFlags()...,
+				),
+				Action: func(c *cli.Context) error {
+					if c.NArg() > 0 {
+						return errors.New("no arguments expected")
+					}
+					filter, err := parseFilter(c)
+					if err != nil {
+						return err
+					}
+					if c.String("stream") != "" {
+						for _, name := range []string{"start-after", "start-before", "limit", "reverse", "last"} {
+							if c.IsSet(name) {
+								return errors.New(name + " can't be combined with stream")
+							}
+						}
+						for _, flag := range filterFlags() {
+							if c.IsSet(flag.Names()[0]) {
+								return errors.New("filters can't be combined with stream")
+							}
+						}
+						return listStreamMain(c.Context, c.String("stream"), int32(c.Int("from-version")))
+					}
+
+					// listing the last events reads them backward and
+					// reverses them again for output
+					bounds := events.Range{
+						Limit:    c.Int("limit"),
+						Backward: c.Bool("reverse"),
+					}
+					if c.IsSet("last") {
+						if c.IsSet("limit") || c.IsSet("reverse") {
+							return errors.New("last can't be combined with limit or reverse")
+						}
+						if c.Int("last") <= 0 {
+							return errors.New("last must be positive")
+						}
+						bounds.Limit = c.Int("last")
+						bounds.Backward = true
+					}
+					if bounds.Limit < 0 {
+						return errors.New("limit must not be negative")
+					}
+
+					return listMain(c.Context, c.String("start-after"), c.String("start-before"), bounds, c.IsSet("last"), filter, c.String("causation"))
 				},
+			},
+			{
+				Name:      "trace",
+				Usage:     "Show the tree of events caused by an event.",
+				ArgsUsage: "<id or external UUID>",
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						return errors.New("exactly one argument expected")
+					}
+
+					return traceMain(c.Context, c.Args().First())
+				},
+			},
+			{
+				Name:      "migrate",
+				Usage:     "Create or update the DB schema of the event store.",
+				ArgsUsage: " ", // no arguments expected
 				Action: func(c *cli.Context) error {
 					if c.NArg() > 0 {
 						return errors.New("no arguments expected")
 					}
 
-					return listMain(c.Context, c.String("start-after"))
+					return migrateMain(c.Context)
 				},
 			},
 			{
@@ -150,7 +487,12 @@ func main() {
 					&cli.StringFlag{
 						Name:  "start-after",
 						Value: "",
-						Usage: "`ID` of the event after which to start processing",
+						Usage: "`ID` of the event after which to start processing, instead of the checkpoint",
+					},
+					&cli.StringFlag{
+						Name:  "consumer",
+						Value: broker.ProcessorConsumer,
+						Usage: "`NAME` under which the checkpoint is saved, empty to disable checkpoints",
 					},
 				},
 				Action: func(c *cli.Context) error {
@@ -161,7 +503,7 @@ func main() {
 					// configure remote API
 					api.Configure(c.String("api-url"))
 
-					return processMain(c.Context, c.String("start-after"))
+					return processMain(c.Context, c.String("start-after"), c.String("consumer"))
 				},
 			},
 			{
@@ -196,19 +538,86 @@ func main() {
 				Name:      "watch-requests",
 				Usage:     "Watch requests as they are processed.",
 				ArgsUsage: " ", // no arguments expected
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:  "start-after",
-						Value: "",
-						Usage: "`ID` of the event after which to start watching",
+				Flags: append(
+					[]cli.Flag{
+						&cli.StringFlag{
+							Name:  "start-after",
+							Value: "",
+							Usage: "`ID` of the event after which to start watching, instead of the checkpoint",
+						},
+						&cli.StringFlag{
+							Name:  "consumer",
+							Value: broker.WatcherConsumer,
+							Usage: "`NAME` under which the checkpoint is saved, empty to disable checkpoints",
+						},
 					},
-				},
+					filterFlags()...,
+				),
 				Action: func(c *cli.Context) error {
 					if c.NArg() > 0 {
 						return errors.New("no arguments expected")
 					}
+					// The watcher needs the requests and all events caused by
+					// them, so selections that drop some of them are rejected.
+					for _, name := range []string{"causation", "with-external-uuid", "without-external-uuid"} {
+						if c.IsSet(name) {
+							return errors.New(name + " can't be used to watch requests")
+						}
+					}
+					filter, err := parseFilter(c)
+					if err != nil {
+						return err
+					}
+
+					return watchRequestsMain(c.Context, c.String("start-after"), c.String("consumer"), filter)
+				},
+			},
+			{
+				Name:  "checkpoints",
+				Usage: "Inspect and reset the checkpoints of consumers.",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "list",
+						Usage:     "List the checkpoints of all consumers.",
+						ArgsUsage: " ", // no arguments expected
+						Action: func(c *cli.Context) error {
+							if c.NArg() > 0 {
+								return errors.New("no arguments expected")
+							}
 
-					return watchRequestsMain(c.Context, c.String("start-after"))
+							return listCheckpointsMain(c.Context)
+						},
+					},
+					{
+						Name:      "reset",
+						Usage:     "Remove the checkpoint of a consumer, so it starts from the beginning.",
+						ArgsUsage: "<consumer>",
+						Action: func(c *cli.Context) error {
+							if c.NArg() != 1 {
+								return errors.New("exactly one argument expected")
+							}
+
+							return resetCheckpointMain(c.Context, c.Args().First())
+						},
+					},
+				},
+			},
+			{
+				Name:  "quarantine",
+				Usage: "Inspect the events that could not be decoded.",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "list",
+						Usage:     "List the quarantined events.",
+						ArgsUsage: " ", // no arguments expected
+						Action: func(c *cli.Context) error {
+							if c.NArg() > 0 {
+								return errors.New("no arguments expected")
+							}
+
+							return listQuarantinedMain(c.Context)
+						},
+					},
 				},
 			},
 		},
@@ -236,6 +645,102 @@ func parseUUID(arg string) (uuid.UUID, error) {
 	return uuid.FromString(arg)
 }
 
+// flags to select events, see parseFilter()
+// The causation ID can only be parsed by the store, so it is not included in
+// the filter but taken from the "causation" flag separately.
+func filterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "class",
+			Usage: "`CLASS` of events to select, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "causation",
+			Value: "",
+			Usage: "`ID` of the event that caused the events to select",
+		},
+		&cli.BoolFlag{
+			Name:  "with-external-uuid",
+			Usage: "select only events with an external UUID",
+		},
+		&cli.BoolFlag{
+			Name:  "without-external-uuid",
+			Usage: "select only events without an external UUID",
+		},
+		&cli.StringFlag{
+			Name:  "created-since",
+			Value: "",
+			Usage: "`TIME` (RFC 3339) at or after which the events to select were created",
+		},
+		&cli.StringFlag{
+			Name:  "created-before",
+			Value: "",
+			Usage: "`TIME` (RFC 3339) before which the events to select were created",
+		},
+	}
+}
+
+// build the filter from the flags returned by filterFlags()
+func parseFilter(c *cli.Context) (events.Filter, error) {
+	filter := events.Filter{
+		Classes: c.StringSlice("class"),
+	}
+
+	switch {
+	case c.Bool("with-external-uuid") && c.Bool("without-external-uuid"):
+		return filter, errors.New("with-external-uuid can't be combined with without-external-uuid")
+	case c.Bool("with-external-uuid"):
+		filter.ExternalUUID = events.Present
+	case c.Bool("without-external-uuid"):
+		filter.ExternalUUID = events.Absent
+	}
+
+	var err error
+	if filter.CreatedSince, err = parseTime(c.String("created-since")); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseTime(c.String("created-before")); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parse a string to a time
+// Only a malformed string creates an error, an empty one will return a zero
+// time value.
+func parseTime(arg string) (time.Time, error) {
+	if arg == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, arg)
+}
+
+// parse a list of "key=value" pairs into a map
+func parseMetadata(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+
+	res := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, errors.New("invalid metadata " + arg + ", expected KEY=VALUE")
+		}
+		res[key] = value
+	}
+	return res, nil
+}
+
+// return the path or the given default if it is empty
+func defaultPath(path string, fallback string) string {
+	if path == "" {
+		return fallback
+	}
+	return path
+}
+
 func initEventStore() (events.EventStore, error) {
 	// setup log handler
 	loglevel, err := log15.LvlFromString(eventStoreLoglevel)
@@ -248,13 +753,73 @@ func initEventStore() (events.EventStore, error) {
 	esLogger := log15.New("context", "event store")
 	esLogger.SetHandler(handler)
 
+	// register the codecs for the events stored
+	codecs := events.NewCodecRegistry()
+	if err := broker.RegisterCodecs(codecs); err != nil {
+		return nil, err
+	}
+	policy, err := events.ParseDecodePolicy(eventStoreDecodePolicy)
+	if err != nil {
+		return nil, err
+	}
+	codecs.SetDecodePolicy(policy, esLogger)
+	encoding, err := events.ParseEncoding(eventStoreEncoding)
+	if err != nil {
+		return nil, err
+	}
+	codecs.SetEncoding(encoding)
+
 	// create an event store facade
 	var store events.EventStore
 	switch eventStoreDriver {
 	case "mongodb":
-		store, err = mongodb.NewEventStore(eventStoreDBHost)
+		store, err = mongodb.NewEventStore(mongodb.Config{
+			URI:                     eventStoreDBDSN,
+			Host:                    eventStoreDBHost,
+			Database:                eventStoreDBName,
+			EventCollection:         eventStoreDBEventCollection,
+			NotificationCollection:  eventStoreDBNoteCollection,
+			CounterCollection:       eventStoreDBCounterCollection,
+			CheckpointCollection:    eventStoreDBCheckpointCollection,
+			SnapshotCollection:      eventStoreDBSnapshotCollection,
+			QuarantineCollection:    eventStoreDBQuarantineCollection,
+			SchemaVersionCollection: eventStoreDBVersionCollection,
+			FollowMode:              eventStoreDBFollowMode,
+			ConnectTimeout:          eventStoreDBConnectTimeout,
+			AutoMigrate:             eventStoreMigrate,
+			Logger:                  esLogger,
+		}, codecs)
 	case "postgresql":
-		store, err = postgresql.NewEventStore(eventStoreDBHost)
+		store, err = postgresql.NewEventStore(postgresql.Config{
+			DSN:               eventStoreDBDSN,
+			Host:              eventStoreDBHost,
+			Port:              eventStoreDBPort,
+			User:              eventStoreDBUser,
+			Password:          eventStoreDBPassword,
+			PasswordFile:      eventStoreDBPasswordFile,
+			Database:          eventStoreDBName,
+			SSLMode:           eventStoreDBSSLMode,
+			SSLCert:           eventStoreDBSSLCert,
+			SSLKey:            eventStoreDBSSLKey,
+			SSLRootCert:       eventStoreDBSSLRootCert,
+			AutoMigrate:       eventStoreMigrate,
+			MaxConns:          int32(eventStorePoolMaxConns),
+			MinConns:          int32(eventStorePoolMinConns),
+			MaxConnIdleTime:   eventStorePoolMaxConnIdleTime,
+			HealthCheckPeriod: eventStorePoolHealthCheckPeriod,
+		}, codecs)
+	case "sqlite":
+		store, err = sqlite.NewEventStore(defaultPath(eventStoreDBPath, "eventstore.sqlite"), codecs)
+	case "filelog":
+		store, err = filelog.NewEventStore(defaultPath(eventStoreDBPath, "eventstore.log"), codecs)
+	case "memory":
+		// The events are kept as they are, so they are neither encoded nor
+		// decoded. Reject settings that would have no effect.
+		if policy != events.DecodeFail || encoding != events.JSON {
+			err = errors.New("the memory driver doesn't support decode policies and encodings")
+			break
+		}
+		store, err = memory.NewEventStore()
 	default:
 		err = errors.New("invalid driver selected")
 	}
@@ -302,7 +867,7 @@ func configureMain(ctx context.Context, externalUUID uuid.UUID, retries int, tim
 }
 
 // insert a new event
-func insertMain(ctx context.Context, class string, data string, externalUUID uuid.UUID, causation string) error {
+func insertMain(ctx context.Context, class string, data string, externalUUID uuid.UUID, causation string, correlation string, metadata map[string]string, stream string, expectedVersion int32) error {
 	store, err := initEventStore()
 	if err != nil {
 		return err
@@ -330,70 +895,258 @@ func insertMain(ctx context.Context, class string, data string, externalUUID uui
 		return err
 	}
 
+	// parse correlation ID
+	correlationID, err := store.ParseEventID(correlation)
+	if err != nil {
+		return err
+	}
+
 	// insert a document
-	envelope, err := store.Insert(ctx, externalUUID, event, causationID)
+	envelopes, err := store.InsertMany(ctx, []events.NewEvent{{
+		ExternalUUID:    externalUUID,
+		Event:           event,
+		CausationID:     causationID,
+		CorrelationID:   correlationID,
+		Metadata:        metadata,
+		Stream:          stream,
+		ExpectedVersion: expectedVersion,
+	}})
 	if err != nil {
 		return err
 	}
 
-	logger.Debug("inserted new document", "id", envelope.ID())
+	logger.Debug("inserted new document", "id", envelopes[0].ID(), "stream", envelopes[0].Stream(), "version", envelopes[0].Version())
 	return nil
 }
 
 // list existing elements
-func listMain(ctx context.Context, lastProcessed string) error {
+// The IDs parsed from `startAfter` and `startBefore` complete the range. If
+// `chronological` is set, the events are listed in ascending order even if the
+// range is read backward.
+func listMain(ctx context.Context, startAfter, startBefore string, bounds events.Range, chronological bool, filter events.Filter, causation string) error {
 	store, err := initEventStore()
 	if err != nil {
 		return err
 	}
 	defer finalizeEventStore(store)
 
-	// parse optional event ID
-	var lastProcessedID int32
-	if lastProcessed != "" {
-		id, err := store.ParseEventID(lastProcessed)
+	// parse optional event IDs
+	if startAfter != "" {
+		id, err := store.ParseEventID(startAfter)
+		if err != nil {
+			return err
+		}
+		bounds.StartAfter = id
+	}
+	if startBefore != "" {
+		id, err := store.ParseEventID(startBefore)
+		if err != nil {
+			return err
+		}
+		bounds.StartBefore = id
+	}
+
+	// parse optional causation ID
+	if causation != "" {
+		id, err := store.ParseEventID(causation)
 		if err != nil {
 			return err
 		}
-		lastProcessedID = id
+		filter.CausationID = id
 	}
 
-	ch, err := store.LoadEvents(ctx, lastProcessedID)
+	ch, err := store.LoadEvents(ctx, bounds, filter)
 	if err != nil {
 		return err
 	}
 
 	// process events from the channel
+	if bounds.Backward && chronological {
+		var envelopes []events.Envelope
+		for envelope := range ch {
+			envelopes = append(envelopes, envelope)
+		}
+		for i := len(envelopes) - 1; i >= 0; i-- {
+			logEnvelope(envelopes[i])
+		}
+	} else {
+		for envelope := range ch {
+			logEnvelope(envelope)
+		}
+	}
+
+	return store.Error()
+}
+
+// show the tree of events caused by an event
+// The event is identified by its ID or external UUID. Every event in the tree
+// is shown with its time relative to the root and to the event causing it.
+func traceMain(ctx context.Context, ref string) error {
+	store, err := initEventStore()
+	if err != nil {
+		return err
+	}
+	defer finalizeEventStore(store)
+
+	loader, ok := store.(events.CausationLoader)
+	if !ok {
+		return events.CausationNotSupported
+	}
+
+	// resolve the root event
+	var id events.EventID
+	if externalUUID, err := uuid.FromString(ref); err == nil {
+		id, err = store.ResolveUUID(ctx, externalUUID)
+		if err != nil {
+			return err
+		}
+	} else {
+		id, err = store.ParseEventID(ref)
+		if err != nil {
+			return err
+		}
+	}
+	root, err := store.RetrieveOne(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// load the tree, indexed by the causing event
+	ch, err := loader.LoadCaused(ctx, id)
+	if err != nil {
+		return err
+	}
+	children := make(map[events.EventID][]events.Envelope)
 	for envelope := range ch {
-		logger.Info(
-			"event",
-			"id", envelope.ID(),
-			"external_uuid", envelope.ExternalUUID(),
-			"class", envelope.Event().Class(),
-			"created", envelope.Created().Format(time.RFC3339),
-			"causation_id", envelope.CausationID(),
-			"data", envelope.Event(),
+		children[envelope.CausationID()] = append(children[envelope.CausationID()], envelope)
+	}
+	if err := store.Error(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d %s%s at %s\n", root.ID(), root.Event().Class(), describeAttempt(root.Event()), root.Created().Format(time.RFC3339Nano))
+	printTrace(root, root, children, "")
+	return nil
+}
+
+// print the events caused by the parent as branches of the tree
+func printTrace(root events.Envelope, parent events.Envelope, children map[events.EventID][]events.Envelope, indent string) {
+	for i, child := range children[parent.ID()] {
+		branch, next := "├── ", "│   "
+		if i == len(children[parent.ID()])-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Printf(
+			"%s%s%d %s%s +%s (+%s after %d)\n",
+			indent,
+			branch,
+			child.ID(),
+			child.Event().Class(),
+			describeAttempt(child.Event()),
+			child.Created().Sub(root.Created()).Round(time.Millisecond),
+			child.Created().Sub(parent.Created()).Round(time.Millisecond),
+			parent.ID(),
 		)
+		printTrace(root, child, children, indent+next)
+	}
+}
+
+// describe the API attempt an event belongs to, if any
+func describeAttempt(event events.Event) string {
+	switch ev := event.(type) {
+	case broker.APIRequestEvent:
+		return fmt.Sprintf(" attempt=%d", ev.Attempt)
+	case broker.APIResponseEvent:
+		return fmt.Sprintf(" attempt=%d", ev.Attempt)
+	case broker.APIFailureEvent:
+		return fmt.Sprintf(" attempt=%d", ev.Attempt)
+	case broker.APITimeoutEvent:
+		return fmt.Sprintf(" attempt=%d", ev.Attempt)
+	}
+	return ""
+}
+
+// list the elements of a stream
+func listStreamMain(ctx context.Context, stream string, fromVersion int32) error {
+	store, err := initEventStore()
+	if err != nil {
+		return err
+	}
+	defer finalizeEventStore(store)
+
+	loader, ok := store.(events.StreamLoader)
+	if !ok {
+		return events.StreamsNotSupported
+	}
+
+	ch, err := loader.LoadStream(ctx, stream, fromVersion)
+	if err != nil {
+		return err
+	}
+
+	// process events from the channel
+	for envelope := range ch {
+		logEnvelope(envelope)
 	}
 
 	return store.Error()
 }
 
+// log the content of an envelope
+func logEnvelope(envelope events.Envelope) {
+	logger.Info(
+		"event",
+		"id", envelope.ID(),
+		"external_uuid", envelope.ExternalUUID(),
+		"class", envelope.Event().Class(),
+		"created", envelope.Created().Format(time.RFC3339),
+		"causation_id", envelope.CausationID(),
+		"correlation_id", envelope.CorrelationID(),
+		"metadata", envelope.Metadata(),
+		"stream", envelope.Stream(),
+		"version", envelope.Version(),
+		"data", envelope.Event(),
+	)
+}
+
+// create or update the DB schema
+func migrateMain(ctx context.Context) error {
+	store, err := initEventStore()
+	if err != nil {
+		return err
+	}
+	defer finalizeEventStore(store)
+
+	migrator, ok := store.(events.Migrator)
+	if !ok {
+		return events.MigrationsNotSupported
+	}
+
+	version, err := migrator.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("migrated schema", "version", version)
+
+	return store.Error()
+}
+
 // process existing elements
-func processMain(ctx context.Context, startAfter string) error {
+func processMain(ctx context.Context, startAfter string, consumer string) error {
 	store, err := initEventStore()
 	if err != nil {
 		return err
 	}
 	defer finalizeEventStore(store)
 
-	handler, err := broker.NewRequestProcessor(store, logger)
+	handler, err := broker.NewRequestProcessor(store, logger, consumer)
 	if err != nil {
 		return err
 	}
 
 	// parse optional event ID
-	var startAfterID int32
+	var startAfterID events.EventID
 	if startAfter != "" {
 		id, err := store.ParseEventID(startAfter)
 		if err != nil {
@@ -445,20 +1198,20 @@ func watchNotificationsMain(ctx context.Context) error {
 }
 
 // watch requests as they are processed
-func watchRequestsMain(ctx context.Context, startAfter string) error {
+func watchRequestsMain(ctx context.Context, startAfter string, consumer string, filter events.Filter) error {
 	store, err := initEventStore()
 	if err != nil {
 		return err
 	}
 	defer finalizeEventStore(store)
 
-	handler, err := broker.NewRequestWatcher(store, logger)
+	handler, err := broker.NewRequestWatcher(store, logger, consumer)
 	if err != nil {
 		return err
 	}
 
 	// parse optional event ID
-	var startAfterID int32
+	var startAfterID events.EventID
 	if startAfter != "" {
 		id, err := store.ParseEventID(startAfter)
 		if err != nil {
@@ -467,5 +1220,86 @@ func watchRequestsMain(ctx context.Context, startAfter string) error {
 		startAfterID = id
 	}
 
-	return handler.Run(ctx, startAfterID)
+	return handler.Run(ctx, startAfterID, filter)
+}
+
+// list the checkpoints of all consumers
+func listCheckpointsMain(ctx context.Context) error {
+	store, err := initEventStore()
+	if err != nil {
+		return err
+	}
+	defer finalizeEventStore(store)
+
+	checkpoints, ok := store.(events.CheckpointStore)
+	if !ok {
+		return events.CheckpointsNotSupported
+	}
+
+	res, err := checkpoints.ListCheckpoints(ctx)
+	if err != nil {
+		return err
+	}
+	for _, checkpoint := range res {
+		logger.Info(
+			"checkpoint",
+			"consumer", checkpoint.Consumer,
+			"id", checkpoint.EventID,
+			"updated", checkpoint.Updated.Format(time.RFC3339),
+		)
+	}
+
+	return store.Error()
+}
+
+// remove the checkpoint of a consumer
+func resetCheckpointMain(ctx context.Context, consumer string) error {
+	store, err := initEventStore()
+	if err != nil {
+		return err
+	}
+	defer finalizeEventStore(store)
+
+	checkpoints, ok := store.(events.CheckpointStore)
+	if !ok {
+		return events.CheckpointsNotSupported
+	}
+
+	if err := checkpoints.ResetCheckpoint(ctx, consumer); err != nil {
+		return err
+	}
+
+	logger.Info("reset checkpoint", "consumer", consumer)
+
+	return store.Error()
+}
+
+// list the events that could not be decoded
+func listQuarantinedMain(ctx context.Context) error {
+	store, err := initEventStore()
+	if err != nil {
+		return err
+	}
+	defer finalizeEventStore(store)
+
+	quarantine, ok := store.(events.Quarantine)
+	if !ok {
+		return events.QuarantineNotSupported
+	}
+
+	res, err := quarantine.ListQuarantined(ctx)
+	if err != nil {
+		return err
+	}
+	for _, event := range res {
+		logger.Info(
+			"quarantined event",
+			"id", event.EventID,
+			"class", event.Class,
+			"reason", event.Reason,
+			"quarantined", event.Quarantined.Format(time.RFC3339),
+		)
+	}
+
+	return store.Error()
 }
@@ -0,0 +1,66 @@
+package events
+
+// This file implements the CBOR encoding of payloads, see RFC 8949.
+//
+// Payloads are encoded deterministically as specified by the RFC, i.e. with
+// the shortest form of integers, lengths and floating point numbers that
+// preserves their value, and with sorted map keys. When decoding, maps must
+// have string keys, and tags are rejected.
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// modes of the CBOR library used for payloads
+var (
+	cborEncMode = mustCBOREncMode(cbor.CoreDetEncOptions())
+	cborDecMode = mustCBORDecMode(cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}{}),
+		IntDec:         cbor.IntDecConvertSignedOrFail,
+		TagsMd:         cbor.TagsForbidden,
+	})
+)
+
+func mustCBOREncMode(opts cbor.EncOptions) cbor.EncMode {
+	mode, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}
+
+func mustCBORDecMode(opts cbor.DecOptions) cbor.DecMode {
+	mode, err := opts.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}
+
+// CBOR encoding
+type cborEncoding struct{}
+
+// ContentType implements the Encoding interface.
+func (cborEncoding) ContentType() string {
+	return ContentTypeCBOR
+}
+
+// Marshal implements the Encoding interface.
+func (cborEncoding) Marshal(data Payload) ([]byte, error) {
+	return cborEncMode.Marshal(map[string]interface{}(data))
+}
+
+// Unmarshal implements the Encoding interface.
+func (cborEncoding) Unmarshal(b []byte) (Payload, error) {
+	var data map[string]interface{}
+	if err := cborDecMode.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("CBOR payload is no map")
+	}
+	return Payload(data), nil
+}
@@ -0,0 +1,256 @@
+package events
+
+// This file defines the codecs converting events for storage.
+//
+// The codecs are independent of the storage backend. They convert events to
+// a generic map, which the event stores then encode as bytes, see
+// encoding.go, or convert to their own format, like a MongoDB document. The
+// codecs are registered in a CodecRegistry by the packages defining the
+// events, and the registry is passed to the event stores. Usually, the codecs
+// are derived from the struct tags of the event types, see structcodec.go.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/inconshreveable/log15"
+)
+
+// Payload is the backend-neutral representation of the data of an event.
+// The values are strings, numbers and booleans. When reading a payload from a
+// store, integers may be decoded as any integer or float type depending on
+// the backend, so use the accessors below to read fields.
+type Payload map[string]interface{}
+
+// StringField returns the value of a string field.
+func (p Payload) StringField(name string) (string, error) {
+	val, ok := p[name].(string)
+	if !ok {
+		return "", fieldError(name, "not a string")
+	}
+	return val, nil
+}
+
+// IntField returns the value of an integer field.
+func (p Payload) IntField(name string) (int64, error) {
+	switch val := p[name].(type) {
+	case int:
+		return int64(val), nil
+	case int32:
+		return int64(val), nil
+	case int64:
+		return val, nil
+	case float64:
+		if val != math.Trunc(val) || math.Abs(val) > 1<<53 {
+			return 0, fieldError(name, "not an integer")
+		}
+		return int64(val), nil
+	default:
+		return 0, fieldError(name, "not an integer")
+	}
+}
+
+// FloatField returns the value of a floating point field.
+func (p Payload) FloatField(name string) (float64, error) {
+	switch val := p[name].(type) {
+	case int:
+		return float64(val), nil
+	case int32:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	case float64:
+		return val, nil
+	default:
+		return 0, fieldError(name, "not a number")
+	}
+}
+
+// BoolField returns the value of a boolean field.
+func (p Payload) BoolField(name string) (bool, error) {
+	val, ok := p[name].(bool)
+	if !ok {
+		return false, fieldError(name, "not a boolean")
+	}
+	return val, nil
+}
+
+// The Codec interface defines methods common to event codecs.
+// The codecs convert between the internal representation (Event) and the
+// backend-neutral representation (Payload).
+// See also the Event interface, which it is closely related to.
+type Codec interface {
+	// Class returns a string that identifies the event type this codec handles.
+	Class() string
+	// Serialize the event in a way that allows storing it.
+	Serialize(event Event) (Payload, error)
+	// Deserialize an event from stored data.
+	Deserialize(data Payload) (Event, error)
+}
+
+// The Upcaster interface defines methods common to upcasters.
+// An upcaster converts the payload of events stored with an older schema
+// version of their class to the next version. That way, the fields of an
+// event can change without rewriting stored events, and the codecs only have
+// to handle the current version. Schema versions start at 1, the current
+// version of a class is one more than the highest version an upcaster is
+// registered for.
+type Upcaster interface {
+	// Class returns a string that identifies the event type this upcaster handles.
+	Class() string
+	// Version returns the schema version of the payload it converts.
+	Version() int
+	// Upcast converts the payload to the next schema version.
+	Upcast(data Payload) (Payload, error)
+}
+
+// CodecRegistry holds the codecs and upcasters for the known event classes.
+// It must be populated before passing it to an event store, it is not safe
+// to register codecs while the store is in use.
+type CodecRegistry struct {
+	codecs map[string]Codec
+	// upcasters by class and schema version of the payload they convert
+	upcasters map[string]map[int]Upcaster
+	// handling of events that can't be decoded, see decode.go
+	policy DecodePolicy
+	logger log15.Logger
+	// encoding of new payloads, see encoding.go
+	encoding Encoding
+}
+
+// NewCodecRegistry creates a CodecRegistry.
+// It contains the codecs for the events defined in this package already.
+func NewCodecRegistry() *CodecRegistry {
+	r := &CodecRegistry{
+		codecs:    make(map[string]Codec),
+		upcasters: make(map[string]map[int]Upcaster),
+		policy:    DecodeFail,
+		logger:    log15.New(),
+		encoding:  JSON,
+	}
+	r.logger.SetHandler(log15.DiscardHandler())
+	r.RegisterEvent(SimpleEvent{})
+	return r
+}
+
+// Register registers a codec that allows conversion of Events.
+// Every class can only be registered once.
+func (r *CodecRegistry) Register(codec Codec) error {
+	if codec == nil {
+		return errors.New("nil codec registered")
+	}
+	class := codec.Class()
+	if _, ok := r.codecs[class]; ok {
+		return fmt.Errorf("codec for class %s registered already", class)
+	}
+	r.codecs[class] = codec
+	return nil
+}
+
+// RegisterEvent registers a codec derived from the struct tags of the event type.
+// See NewStructCodec for the supported event types.
+func (r *CodecRegistry) RegisterEvent(prototype Event) error {
+	codec, err := NewStructCodec(prototype)
+	if err != nil {
+		return err
+	}
+	return r.Register(codec)
+}
+
+// RegisterUpcaster registers an upcaster for payloads of an older schema version.
+func (r *CodecRegistry) RegisterUpcaster(upcaster Upcaster) error {
+	if upcaster == nil {
+		return errors.New("nil upcaster registered")
+	}
+	class := upcaster.Class()
+	version := upcaster.Version()
+	if version < 1 {
+		return fmt.Errorf("invalid schema version %d for class %s", version, class)
+	}
+	if r.upcasters[class] == nil {
+		r.upcasters[class] = make(map[int]Upcaster)
+	}
+	if _, ok := r.upcasters[class][version]; ok {
+		return fmt.Errorf("upcaster for schema version %d of class %s registered already", version, class)
+	}
+	r.upcasters[class][version] = upcaster
+	return nil
+}
+
+// SchemaVersion returns the current schema version of the payload of the class.
+// This is one more than the highest version an upcaster is registered for.
+func (r *CodecRegistry) SchemaVersion(class string) int {
+	res := 1
+	for version := range r.upcasters[class] {
+		if version >= res {
+			res = version + 1
+		}
+	}
+	return res
+}
+
+// Serialize converts the event using the codec for its class.
+// This returns the payload and its schema version, which must be stored
+// alongside the class. The payload of a RawEvent is returned unchanged.
+func (r *CodecRegistry) Serialize(event Event) (Payload, int, error) {
+	if raw, ok := event.(RawEvent); ok {
+		if raw.Payload == nil {
+			return Payload{}, max(raw.SchemaVersion, 1), nil
+		}
+		return raw.Payload, max(raw.SchemaVersion, 1), nil
+	}
+	class := event.Class()
+	codec := r.codecs[class]
+	if codec == nil {
+		return nil, 0, errors.New("failed to locate codec for event")
+	}
+	data, err := codec.Serialize(event)
+	if err != nil {
+		return nil, 0, err
+	}
+	if data == nil {
+		data = Payload{}
+	}
+	return data, r.SchemaVersion(class), nil
+}
+
+// Deserialize converts the stored payload of the event with the ID.
+// A payload of an older schema version is converted to the current one first.
+// Events stored before schema versions were recorded may lack one, which is
+// passed as zero and treated as the first version. Events of a class without
+// codec are returned as RawEvent. Any failure, including a panic in a codec
+// or upcaster, is returned as DecodeError.
+func (r *CodecRegistry) Deserialize(id EventID, class string, schemaVersion int, data Payload) (res Event, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic in codec: %v", p)
+		}
+		if err != nil {
+			res, err = nil, newDecodeError(id, class, err)
+		}
+	}()
+
+	if schemaVersion == 0 {
+		schemaVersion = 1
+	}
+	codec := r.codecs[class]
+	if codec == nil {
+		return RawEvent{EventClass: class, SchemaVersion: schemaVersion, Payload: data}, nil
+	}
+
+	current := r.SchemaVersion(class)
+	if schemaVersion > current {
+		return nil, fmt.Errorf("unknown schema version %d for class %s", schemaVersion, class)
+	}
+	for ; schemaVersion < current; schemaVersion++ {
+		upcaster := r.upcasters[class][schemaVersion]
+		if upcaster == nil {
+			return nil, fmt.Errorf("failed to locate upcaster for schema version %d of class %s", schemaVersion, class)
+		}
+		if data, err = upcaster.Upcast(data); err != nil {
+			return nil, err
+		}
+	}
+	return codec.Deserialize(data)
+}
@@ -0,0 +1,164 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPayloadFields(t *testing.T) {
+	data := Payload{
+		"string":   "text",
+		"int":      int(1),
+		"int32":    int32(2),
+		"int64":    int64(3),
+		"float":    float64(4),
+		"fraction": float64(4.5),
+	}
+
+	if val, err := data.StringField("string"); err != nil || val != "text" {
+		t.Errorf("unexpected value %v (error %v)", val, err)
+	}
+	for name, expected := range map[string]int64{"int": 1, "int32": 2, "int64": 3, "float": 4} {
+		if val, err := data.IntField(name); err != nil || val != expected {
+			t.Errorf("unexpected value %v (error %v) for %s", val, err, name)
+		}
+		if val, err := data.FloatField(name); err != nil || val != float64(expected) {
+			t.Errorf("unexpected value %v (error %v) for %s", val, err, name)
+		}
+	}
+	if val, err := data.FloatField("fraction"); err != nil || val != 4.5 {
+		t.Errorf("unexpected value %v (error %v)", val, err)
+	}
+
+	// missing fields and fields of the wrong type are errors
+	for _, name := range []string{"int", "missing"} {
+		if _, err := data.StringField(name); err == nil {
+			t.Errorf("expected error missing for %s", name)
+		}
+	}
+	for _, name := range []string{"string", "fraction", "missing"} {
+		if _, err := data.IntField(name); err == nil {
+			t.Errorf("expected error missing for %s", name)
+		}
+	}
+	for _, name := range []string{"string", "missing"} {
+		if _, err := data.FloatField(name); err == nil {
+			t.Errorf("expected error missing for %s", name)
+		}
+	}
+}
+
+// event without a registered codec
+type unknownEvent struct{}
+
+// Class implements the Event interface.
+func (e unknownEvent) Class() string {
+	return "unknown"
+}
+
+func TestCodecRegistry(t *testing.T) {
+	registry := NewCodecRegistry()
+
+	// the events of this package are registered already
+	data, schemaVersion, err := registry.Serialize(SimpleEvent{Message: "some message"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(data, Payload{"message": "some message"}) || schemaVersion != 1 {
+		t.Errorf("unexpected data %v (version %d)", data, schemaVersion)
+	}
+	event, err := registry.Deserialize(1, "simple", schemaVersion, data)
+	if err != nil || event != (SimpleEvent{Message: "some message"}) {
+		t.Errorf("unexpected event %v (error %v)", event, err)
+	}
+
+	// every class can only be registered once
+	if err := registry.RegisterEvent(SimpleEvent{}); err == nil {
+		t.Errorf("expected error missing")
+	}
+	if err := registry.Register(nil); err == nil {
+		t.Errorf("expected error missing")
+	}
+
+	// events without codec can't be converted
+	if _, _, err := registry.Serialize(unknownEvent{}); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+func TestRawEvent(t *testing.T) {
+	registry := NewCodecRegistry()
+
+	// stored events of unknown classes are passed through
+	event, err := registry.Deserialize(1, "unknown", 2, Payload{"text": "value"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := RawEvent{EventClass: "unknown", SchemaVersion: 2, Payload: Payload{"text": "value"}}
+	if !reflect.DeepEqual(event, expected) || event.Class() != "unknown" {
+		t.Errorf("unexpected event %#v", event)
+	}
+	data, schemaVersion, err := registry.Serialize(event)
+	if err != nil || !reflect.DeepEqual(data, expected.Payload) || schemaVersion != 2 {
+		t.Errorf("unexpected data %v (version %d, error %v)", data, schemaVersion, err)
+	}
+
+	// a missing schema version is the first one
+	event, err = registry.Deserialize(2, "unknown", 0, Payload{})
+	if err != nil || event.(RawEvent).SchemaVersion != 1 {
+		t.Errorf("unexpected event %#v (error %v)", event, err)
+	}
+	data, schemaVersion, err = registry.Serialize(RawEvent{EventClass: "unknown"})
+	if err != nil || !reflect.DeepEqual(data, Payload{}) || schemaVersion != 1 {
+		t.Errorf("unexpected data %v (version %d, error %v)", data, schemaVersion, err)
+	}
+}
+
+// upcaster renaming the field of simple events from "text" to "message"
+type simpleEventUpcaster struct{}
+
+func (upcaster *simpleEventUpcaster) Class() string {
+	return "simple"
+}
+
+func (upcaster *simpleEventUpcaster) Version() int {
+	return 1
+}
+
+func (upcaster *simpleEventUpcaster) Upcast(data Payload) (Payload, error) {
+	return Payload{"message": data["text"]}, nil
+}
+
+func TestUpcast(t *testing.T) {
+	registry := NewCodecRegistry()
+	if err := registry.RegisterUpcaster(&simpleEventUpcaster{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := registry.RegisterUpcaster(&simpleEventUpcaster{}); err == nil {
+		t.Errorf("expected error missing")
+	}
+
+	// new events are stored with the next schema version
+	if _, schemaVersion, err := registry.Serialize(SimpleEvent{}); err != nil || schemaVersion != 2 {
+		t.Errorf("unexpected version %d (error %v)", schemaVersion, err)
+	}
+
+	// events stored without schema version have the first one
+	for _, schemaVersion := range []int{0, 1} {
+		event, err := registry.Deserialize(1, "simple", schemaVersion, Payload{"text": "old"})
+		if err != nil || event != (SimpleEvent{Message: "old"}) {
+			t.Errorf("unexpected event %v (error %v)", event, err)
+		}
+	}
+
+	// the current version is decoded directly
+	event, err := registry.Deserialize(1, "simple", 2, Payload{"message": "current"})
+	if err != nil || event != (SimpleEvent{Message: "current"}) {
+		t.Errorf("unexpected event %v (error %v)", event, err)
+	}
+
+	// unknown versions are rejected
+	if _, err := registry.Deserialize(1, "simple", 3, Payload{"message": "future"}); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
@@ -0,0 +1,114 @@
+package events
+
+// This file defines the handling of stored events that can't be decoded.
+//
+// Events may become undecodable, e.g. when a document was edited by hand or
+// when a codec is changed without registering an upcaster. When such an event
+// is encountered while loading several events, the event store applies the
+// DecodePolicy of its CodecRegistry. By default, loading stops and the error
+// state of the store is set. Alternatively, the event can be skipped, which
+// lets consumers continue with the following events.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/inconshreveable/log15"
+)
+
+// DecodePolicy defines how event stores handle events that can't be decoded
+// while loading several events, e.g. in LoadEvents or FollowEvents.
+// Retrieving a single event always returns the DecodeError.
+type DecodePolicy int
+
+const (
+	// DecodeFail stops loading and sets the error state of the store.
+	DecodeFail DecodePolicy = iota
+	// DecodeSkip logs the event and skips it.
+	DecodeSkip
+	// DecodeQuarantine records the event in the quarantine of the store and
+	// skips it. Stores that don't implement Quarantine fail instead.
+	DecodeQuarantine
+)
+
+// names of the decode policies, indexed by their value
+var decodePolicyNames = []string{"fail", "skip", "quarantine"}
+
+// String implements the fmt.Stringer interface.
+func (p DecodePolicy) String() string {
+	if p < 0 || int(p) >= len(decodePolicyNames) {
+		return fmt.Sprintf("DecodePolicy(%d)", int(p))
+	}
+	return decodePolicyNames[p]
+}
+
+// ParseDecodePolicy parses the name of a decode policy.
+func ParseDecodePolicy(str string) (DecodePolicy, error) {
+	for i, name := range decodePolicyNames {
+		if str == name {
+			return DecodePolicy(i), nil
+		}
+	}
+	return DecodeFail, fmt.Errorf("invalid decode policy %q", str)
+}
+
+// SetDecodePolicy configures how the event stores using the registry handle
+// events that can't be decoded. The logger receives a warning for every event
+// that is skipped, it can be nil.
+func (r *CodecRegistry) SetDecodePolicy(policy DecodePolicy, logger log15.Logger) {
+	r.policy = policy
+	if logger != nil {
+		r.logger = logger
+	}
+}
+
+// DecodePolicy returns the configured decode policy.
+func (r *CodecRegistry) DecodePolicy() DecodePolicy {
+	return r.policy
+}
+
+// HandleDecodeError applies the decode policy to an error that occurred
+// while loading an event as part of several events. It returns nil if the
+// event is to be skipped, otherwise the error, which the store then sets as
+// its error state. Errors other than DecodeErrors are always returned. The
+// quarantine is used for the DecodeQuarantine policy, it can be nil.
+func (r *CodecRegistry) HandleDecodeError(ctx context.Context, err error, quarantine Quarantine) error {
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		return err
+	}
+
+	switch r.policy {
+	case DecodeSkip:
+		r.logger.Warn("skipping undecodable event", "id", decodeErr.EventID, "class", decodeErr.Class, "error", err)
+		return nil
+	case DecodeQuarantine:
+		if quarantine == nil {
+			return err
+		}
+		qerr := quarantine.QuarantineEvent(ctx, decodeErr.EventID, decodeErr.Class, err.Error())
+		if errors.Is(qerr, QuarantineNotSupported) {
+			return err
+		}
+		if qerr != nil {
+			return fmt.Errorf("failed to quarantine event %d: %w", decodeErr.EventID, qerr)
+		}
+		r.logger.Warn("quarantined undecodable event", "id", decodeErr.EventID, "class", decodeErr.Class, "error", err)
+		return nil
+	default:
+		return err
+	}
+}
+
+// create a DecodeError for the event
+// Details of a DecodeError returned by a codec, like the field, are kept.
+func newDecodeError(id EventID, class string, err error) error {
+	res := &DecodeError{EventID: id, Class: class, Err: err}
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		res.Field = decodeErr.Field
+		res.Err = decodeErr.Err
+	}
+	return res
+}
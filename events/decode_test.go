@@ -0,0 +1,134 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseDecodePolicy(t *testing.T) {
+	for _, policy := range []DecodePolicy{DecodeFail, DecodeSkip, DecodeQuarantine} {
+		res, err := ParseDecodePolicy(policy.String())
+		if err != nil || res != policy {
+			t.Errorf("unexpected policy %v (error %v)", res, err)
+		}
+	}
+	if _, err := ParseDecodePolicy("ignore"); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+// codec for SimpleEvents that panics when decoding
+type panickingCodec struct{}
+
+// Class implements the Codec interface.
+func (codec *panickingCodec) Class() string {
+	return "simple"
+}
+
+// Serialize implements the Codec interface.
+func (codec *panickingCodec) Serialize(e Event) (Payload, error) {
+	return Payload{"message": e.(SimpleEvent).Message}, nil
+}
+
+// Deserialize implements the Codec interface.
+func (codec *panickingCodec) Deserialize(data Payload) (Event, error) {
+	panic("broken codec")
+}
+
+func TestDeserializeErrors(t *testing.T) {
+	registry := NewCodecRegistry()
+
+	// the details of a failure are returned
+	_, err := registry.Deserialize(42, "simple", 1, Payload{"message": 1})
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if decodeErr.EventID != 42 || decodeErr.Class != "simple" || decodeErr.Field != "message" {
+		t.Errorf("unexpected details %+v", decodeErr)
+	}
+
+	// so are unknown schema versions
+	_, err = registry.Deserialize(43, "simple", 2, Payload{"message": "text"})
+	if !errors.As(err, &decodeErr) || decodeErr.EventID != 43 || decodeErr.Class != "simple" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// a panic in a codec is turned into an error
+	registry = &CodecRegistry{codecs: make(map[string]Codec)}
+	if err := registry.Register(&panickingCodec{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = registry.Deserialize(44, "simple", 1, Payload{"message": "text"})
+	if !errors.As(err, &decodeErr) || decodeErr.EventID != 44 {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// quarantine recording the IDs of the events
+type testQuarantine struct {
+	ids []EventID
+	err error
+}
+
+// QuarantineEvent implements the Quarantine interface.
+func (q *testQuarantine) QuarantineEvent(ctx context.Context, id EventID, class string, reason string) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.ids = append(q.ids, id)
+	return nil
+}
+
+// ListQuarantined implements the Quarantine interface.
+func (q *testQuarantine) ListQuarantined(ctx context.Context) ([]QuarantinedEvent, error) {
+	return nil, nil
+}
+
+func TestHandleDecodeError(t *testing.T) {
+	ctx := context.Background()
+	decodeErr := &DecodeError{EventID: 42, Class: "simple", Err: errors.New("broken")}
+	otherErr := errors.New("connection lost")
+
+	cases := map[string]struct {
+		policy     DecodePolicy
+		err        error
+		quarantine *testQuarantine
+		fails      bool
+		ids        []EventID
+	}{
+		"fail":                     {policy: DecodeFail, err: decodeErr, fails: true},
+		"skip":                     {policy: DecodeSkip, err: decodeErr},
+		"skip other error":         {policy: DecodeSkip, err: otherErr, fails: true},
+		"quarantine":               {policy: DecodeQuarantine, err: decodeErr, quarantine: &testQuarantine{}, ids: []EventID{42}},
+		"quarantine other error":   {policy: DecodeQuarantine, err: otherErr, quarantine: &testQuarantine{}, fails: true},
+		"quarantine failure":       {policy: DecodeQuarantine, err: decodeErr, quarantine: &testQuarantine{err: otherErr}, fails: true},
+		"quarantine not supported": {policy: DecodeQuarantine, err: decodeErr, quarantine: &testQuarantine{err: QuarantineNotSupported}, fails: true},
+		"quarantine without store": {policy: DecodeQuarantine, err: decodeErr, fails: true},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			registry := NewCodecRegistry()
+			registry.SetDecodePolicy(c.policy, nil)
+			if registry.DecodePolicy() != c.policy {
+				t.Errorf("unexpected policy %v", registry.DecodePolicy())
+			}
+
+			var quarantine Quarantine
+			if c.quarantine != nil {
+				quarantine = c.quarantine
+			}
+			err := registry.HandleDecodeError(ctx, c.err, quarantine)
+			if c.fails && err == nil {
+				t.Errorf("expected error missing")
+			}
+			if !c.fails && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if c.quarantine != nil && len(c.quarantine.ids) != len(c.ids) {
+				t.Errorf("unexpected quarantined events %v", c.quarantine.ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package events
+
+// This file defines the encodings converting payloads to bytes.
+//
+// The codecs convert events to a Payload, the encodings then convert the
+// payload to bytes for storage. The content type of the encoding is stored
+// alongside the class of the event, so events written with different
+// encodings can be read from the same store, and consumers in other languages
+// know how to decode them. Stores that support JSON natively, like PostgreSQL
+// and MongoDB, keep storing JSON payloads in their own format, so they remain
+// readable and searchable in the DB.
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// content types of the supported encodings
+const (
+	// ContentTypeJSON is the content type of JSON payloads.
+	ContentTypeJSON = "application/json"
+	// ContentTypeCBOR is the content type of CBOR payloads, see RFC 8949.
+	ContentTypeCBOR = "application/cbor"
+)
+
+// The Encoding interface defines methods common to payload encodings.
+type Encoding interface {
+	// ContentType returns the MIME type of the encoded payloads.
+	ContentType() string
+	// Marshal encodes the payload.
+	Marshal(data Payload) ([]byte, error)
+	// Unmarshal decodes a payload.
+	Unmarshal(b []byte) (Payload, error)
+}
+
+// the supported encodings
+var (
+	// JSON encodes payloads as JSON objects. Numbers are decoded as float64.
+	JSON Encoding = jsonEncoding{}
+	// CBOR encodes payloads as CBOR maps. Integers are decoded as int64.
+	CBOR Encoding = cborEncoding{}
+)
+
+// names of the encodings for configuration
+var encodingNames = map[string]Encoding{
+	"json": JSON,
+	"cbor": CBOR,
+}
+
+// ParseEncoding returns the encoding with the name, i.e. "json" or "cbor".
+func ParseEncoding(str string) (Encoding, error) {
+	encoding, ok := encodingNames[str]
+	if !ok {
+		return nil, fmt.Errorf("invalid encoding %q", str)
+	}
+	return encoding, nil
+}
+
+// EncodingForContentType returns the encoding of the content type.
+// The empty content type is JSON, which stores used before content types were
+// recorded.
+func EncodingForContentType(contentType string) (Encoding, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	for _, encoding := range encodingNames {
+		if encoding.ContentType() == contentType {
+			return encoding, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown content type %q", contentType)
+}
+
+// SetEncoding configures the encoding of the payloads of new events.
+// The default is JSON. Stored events are decoded according to their content
+// type regardless of the encoding.
+func (r *CodecRegistry) SetEncoding(encoding Encoding) {
+	r.encoding = encoding
+}
+
+// Encoding returns the encoding of the payloads of new events.
+func (r *CodecRegistry) Encoding() Encoding {
+	return r.encoding
+}
+
+// Encode serializes the event like Serialize and encodes the payload.
+// This returns the encoded payload, its content type and its schema version,
+// which must be stored alongside the class.
+func (r *CodecRegistry) Encode(event Event) ([]byte, string, int, error) {
+	data, schemaVersion, err := r.Serialize(event)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	b, err := r.encoding.Marshal(data)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return b, r.encoding.ContentType(), schemaVersion, nil
+}
+
+// Decode decodes a payload of the content type and deserializes the event
+// like Deserialize. Any failure is returned as DecodeError.
+func (r *CodecRegistry) Decode(id EventID, class string, schemaVersion int, contentType string, b []byte) (Event, error) {
+	encoding, err := EncodingForContentType(contentType)
+	if err != nil {
+		return nil, newDecodeError(id, class, err)
+	}
+	data, err := encoding.Unmarshal(b)
+	if err != nil {
+		return nil, newDecodeError(id, class, err)
+	}
+	return r.Deserialize(id, class, schemaVersion, data)
+}
+
+// JSON encoding
+type jsonEncoding struct{}
+
+// ContentType implements the Encoding interface.
+func (jsonEncoding) ContentType() string {
+	return ContentTypeJSON
+}
+
+// Marshal implements the Encoding interface.
+func (jsonEncoding) Marshal(data Payload) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+// Unmarshal implements the Encoding interface.
+func (jsonEncoding) Unmarshal(b []byte) (Payload, error) {
+	data := Payload{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,174 @@
+package events
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// event with fields of all supported types
+type encodingTestEvent struct {
+	String string  `event:"string"`
+	Bool   bool    `event:"bool"`
+	Int    int64   `event:"int"`
+	Uint   uint32  `event:"uint"`
+	Float  float64 `event:"float"`
+}
+
+// Class implements the Event interface.
+func (e encodingTestEvent) Class() string {
+	return "encoding"
+}
+
+func TestParseEncoding(t *testing.T) {
+	for _, encoding := range []Encoding{JSON, CBOR} {
+		parsed, err := EncodingForContentType(encoding.ContentType())
+		if err != nil || parsed != encoding {
+			t.Errorf("unexpected encoding %v (error %v)", parsed, err)
+		}
+	}
+	for name, expected := range map[string]Encoding{"json": JSON, "cbor": CBOR} {
+		if encoding, err := ParseEncoding(name); err != nil || encoding != expected {
+			t.Errorf("unexpected encoding %v (error %v) for %s", encoding, err, name)
+		}
+	}
+	if _, err := ParseEncoding("protobuf"); err == nil {
+		t.Errorf("expected error missing")
+	}
+
+	// events stored before content types were recorded are JSON
+	if encoding, err := EncodingForContentType(""); err != nil || encoding != JSON {
+		t.Errorf("unexpected encoding %v (error %v)", encoding, err)
+	}
+	if _, err := EncodingForContentType("text/plain"); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	event := encodingTestEvent{String: "text", Bool: true, Int: -1 << 40, Uint: math.MaxUint32, Float: 2.5}
+	for name, encoding := range encodingNames {
+		t.Run(name, func(t *testing.T) {
+			registry := NewCodecRegistry()
+			if err := registry.RegisterEvent(encodingTestEvent{}); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			registry.SetEncoding(encoding)
+
+			data, contentType, schemaVersion, err := registry.Encode(event)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if contentType != encoding.ContentType() || schemaVersion != 1 {
+				t.Errorf("unexpected content type %q (version %d)", contentType, schemaVersion)
+			}
+			decoded, err := registry.Decode(1, event.Class(), schemaVersion, contentType, data)
+			if err != nil || decoded != event {
+				t.Errorf("unexpected event %#v (error %v)", decoded, err)
+			}
+
+			// nested values of raw events survive as well
+			raw := RawEvent{EventClass: "raw", SchemaVersion: 1, Payload: Payload{
+				"list":   []interface{}{"a", nil, false},
+				"object": map[string]interface{}{"key": "value"},
+			}}
+			data, contentType, _, err = registry.Encode(raw)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			decoded, err = registry.Decode(2, raw.Class(), 1, contentType, data)
+			if err != nil || !reflect.DeepEqual(decoded, raw) {
+				t.Errorf("unexpected event %#v (error %v)", decoded, err)
+			}
+
+			// garbage is reported as undecodable event
+			_, err = registry.Decode(3, event.Class(), 1, contentType, []byte{0xff, 0xff})
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) || decodeErr.EventID != 3 {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+
+	// unknown content types can't be decoded
+	registry := NewCodecRegistry()
+	_, err := registry.Decode(4, "simple", 1, "text/plain", []byte("text"))
+	if !errors.Is(err, UndecodableEvent) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// decode a hex string, ignoring spaces
+func unhex(t *testing.T, str string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(str, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid hex string %s", str)
+	}
+	return b
+}
+
+func TestCBOR(t *testing.T) {
+	// encoding is deterministic, examples taken from RFC 8949
+	for _, c := range []struct {
+		data    Payload
+		encoded string
+	}{
+		{Payload{}, "a0"},
+		{Payload{"a": int64(1), "b": []interface{}{int64(2), int64(-3)}}, "a26161016162820222"},
+		{Payload{"b": true, "a": nil, "aa": false}, "a36161f66162f5626161f4"},
+		{Payload{"x": 1.5}, "a16178f93e00"},
+		{Payload{"x": 1.1}, "a16178fb3ff199999999999a"},
+		{Payload{"n": int64(1000000), "m": int64(-1000)}, "a2616d3903e7616e1a000f4240"},
+		{Payload{"s": "ü"}, "a1617362c3bc"},
+	} {
+		b, err := CBOR.Marshal(c.data)
+		if err != nil || hex.EncodeToString(b) != c.encoded {
+			t.Errorf("unexpected encoding %x (error %v) of %v", b, err, c.data)
+		}
+		data, err := CBOR.Unmarshal(b)
+		if err != nil || !reflect.DeepEqual(data, c.data) {
+			t.Errorf("unexpected payload %#v (error %v)", data, err)
+		}
+	}
+
+	// other encoders may use other floating point precisions
+	for encoded, expected := range map[string]float64{
+		"a16178f93c00":     1,
+		"a16178f9c400":     -4,
+		"a16178f90001":     5.960464477539063e-8,
+		"a16178f97bff":     65504,
+		"a16178fa47c35000": 100000,
+	} {
+		data, err := CBOR.Unmarshal(unhex(t, encoded))
+		if err != nil || data["x"] != expected {
+			t.Errorf("unexpected payload %v (error %v) for %s", data, err, encoded)
+		}
+	}
+
+	// unsupported and invalid data is rejected
+	for _, encoded := range []string{
+		"",
+		"01",                       // no map
+		"f6",                       // null instead of a map
+		"a1",                       // truncated
+		"a16178",                   // missing value
+		"a10101",                   // key no string
+		"a16178c100",               // tag
+		"bf6178ff",                 // missing value in indefinite length map
+		"a1617862ff",               // truncated string
+		"a1617861ff",               // invalid UTF-8
+		"a161781bffffffffffffffff", // integer out of range
+		"a0a0",                     // trailing data
+		"a1617881" + "81818181818181818181818181818181818181818181818181818181818181818101", // too deep
+	} {
+		if _, err := CBOR.Unmarshal(unhex(t, encoded)); err == nil {
+			t.Errorf("expected error missing for %s", encoded)
+		}
+	}
+	if _, err := CBOR.Marshal(Payload{"x": make(chan int)}); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
@@ -2,7 +2,113 @@ package events
 
 // This file collects error types.
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // DuplicateEventUUID is used to signal that the UUID identifying an event is already in use
 var DuplicateEventUUID = errors.New("duplicate event identifier UUID")
+
+// MigrationsNotSupported is used to signal that an event store doesn't manage its schema
+var MigrationsNotSupported = errors.New("event store doesn't support schema migrations")
+
+// StreamsNotSupported is used to signal that an event store can't retrieve the events of a stream
+var StreamsNotSupported = errors.New("event store doesn't support loading streams")
+
+// CausationNotSupported is used to signal that an event store can't retrieve the events caused by an event
+var CausationNotSupported = errors.New("event store doesn't support loading caused events")
+
+// CheckpointsNotSupported is used to signal that an event store can't persist consumer checkpoints
+var CheckpointsNotSupported = errors.New("event store doesn't support checkpoints")
+
+// SnapshotsNotSupported is used to signal that an event store can't persist snapshots of consumer state
+var SnapshotsNotSupported = errors.New("event store doesn't support snapshots")
+
+// BatchesNotSupported is used to signal that an event store can't insert several events atomically
+var BatchesNotSupported = errors.New("event store doesn't support inserting several events atomically")
+
+// QuarantineNotSupported is used to signal that an event store can't quarantine undecodable events
+var QuarantineNotSupported = errors.New("event store doesn't support quarantine")
+
+// UndecodableEvent is used to signal that a stored event can't be decoded
+// The actual error returned is a DecodeError, which matches this using
+// `errors.Is()`.
+var UndecodableEvent = errors.New("undecodable event")
+
+// VersionConflict is used to signal that the version of a stream doesn't match the expected version
+// The actual error returned is a VersionConflictError, which matches this
+// using `errors.Is()`.
+var VersionConflict = errors.New("stream version conflict")
+
+// VersionConflictError provides details about a VersionConflict.
+type VersionConflictError struct {
+	// Stream is the name of the stream.
+	Stream string
+	// Expected is the version that was expected.
+	Expected int32
+	// Actual is the version the stream actually had.
+	Actual int32
+}
+
+// Error implements the error interface.
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("%v: stream %q has version %d, expected %d", VersionConflict, e.Stream, e.Actual, e.Expected)
+}
+
+// Is allows matching the error against VersionConflict.
+func (e *VersionConflictError) Is(target error) bool {
+	return target == VersionConflict
+}
+
+// CheckVersion returns a VersionConflictError if the expected version of a
+// stream doesn't match the actual one.
+func CheckVersion(stream string, expected int32, actual int32) error {
+	if expected == AnyVersion || expected == actual {
+		return nil
+	}
+	return &VersionConflictError{Stream: stream, Expected: expected, Actual: actual}
+}
+
+// DecodeError provides details about an UndecodableEvent.
+type DecodeError struct {
+	// EventID is the ID of the event, zero if it is not known.
+	EventID EventID
+	// Class is the class of the event, empty if it is not known.
+	Class string
+	// Field is the name of the field of the payload that caused the error,
+	// empty if the error doesn't concern a single field.
+	Field string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *DecodeError) Error() string {
+	msg := UndecodableEvent.Error()
+	if e.EventID != 0 {
+		msg += fmt.Sprintf(" %d", e.EventID)
+	}
+	if e.Class != "" {
+		msg += fmt.Sprintf(" of class %q", e.Class)
+	}
+	if e.Field != "" {
+		msg += fmt.Sprintf(": field %q", e.Field)
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+// Is allows matching the error against UndecodableEvent.
+func (e *DecodeError) Is(target error) bool {
+	return target == UndecodableEvent
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// fieldError returns a DecodeError for a field of a payload.
+func fieldError(field string, msg string) error {
+	return &DecodeError{Field: field, Err: errors.New(msg)}
+}
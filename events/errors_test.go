@@ -13,3 +13,64 @@ func TestDuplicateEventUUID(t *testing.T) {
 		t.Error("error type is not recognized")
 	}
 }
+
+func TestMigrationsNotSupported(t *testing.T) {
+	// make sure the type implements the `error` interface
+	var err error = MigrationsNotSupported
+
+	if !errors.Is(err, MigrationsNotSupported) {
+		t.Error("error type is not recognized")
+	}
+}
+
+func TestVersionConflict(t *testing.T) {
+	// make sure the type implements the `error` interface
+	var err error = VersionConflict
+
+	if !errors.Is(err, VersionConflict) {
+		t.Error("error type is not recognized")
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	if err := CheckVersion("stream", 2, 2); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := CheckVersion("stream", AnyVersion, 2); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	err := CheckVersion("stream", 1, 2)
+	if !errors.Is(err, VersionConflict) {
+		t.Errorf("error type is not recognized")
+	}
+	var conflict *VersionConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("error type is not recognized")
+	}
+	if conflict.Stream != "stream" || conflict.Expected != 1 || conflict.Actual != 2 {
+		t.Errorf("unexpected details %+v", conflict)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	cause := errors.New("not an integer")
+	var err error = &DecodeError{EventID: 42, Class: "configuration", Field: "retries", Err: cause}
+
+	if !errors.Is(err, UndecodableEvent) {
+		t.Error("error type is not recognized")
+	}
+	if !errors.Is(err, cause) {
+		t.Error("underlying error is not recognized")
+	}
+	expected := `undecodable event 42 of class "configuration": field "retries": not an integer`
+	if err.Error() != expected {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+
+	// unknown details are left out
+	err = &DecodeError{Err: cause}
+	if err.Error() != "undecodable event: not an integer" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
@@ -15,10 +15,25 @@ type Event interface {
 // SimpleEvent models a simple event with a message but without any
 // further meaning for the API broker.
 type SimpleEvent struct {
-	Message string
+	Message string `event:"message"`
 }
 
 // Class implements the Event interface.
 func (e SimpleEvent) Class() string {
 	return "simple"
 }
+
+// RawEvent is an event of a class without registered codec, e.g. one stored
+// by a newer version of the producer. It carries the payload as stored, so
+// tools can still inspect, export or copy the event. Storing a RawEvent
+// writes the payload unchanged.
+type RawEvent struct {
+	EventClass    string
+	SchemaVersion int
+	Payload       Payload
+}
+
+// Class implements the Event interface.
+func (e RawEvent) Class() string {
+	return e.EventClass
+}
@@ -0,0 +1,93 @@
+package events
+
+// This file implements the selection of events when loading them.
+
+import (
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Presence selects events by whether an optional value is present.
+type Presence int
+
+const (
+	// Any selects events regardless of the value.
+	Any Presence = iota
+	// Present selects only events where the value is present.
+	Present
+	// Absent selects only events where the value is absent.
+	Absent
+)
+
+// Filter selects the events emitted by EventStore.LoadEvents and
+// EventStore.FollowEvents.
+//
+// The zero value selects all events. Every field that is set restricts the
+// selection further, so an event must match all of them. The stores apply the
+// filter in the storage backend where possible, so that events that don't
+// match are not even transferred.
+type Filter struct {
+	// Classes contains the event classes to select. If it is empty, events
+	// of all classes are selected.
+	Classes []string
+	// CausationID selects only events caused by the given event, if it is
+	// not zero.
+	CausationID EventID
+	// ExternalUUID selects events by whether they have an external UUID.
+	ExternalUUID Presence
+	// CreatedSince selects only events created at or after that time, if it
+	// is not zero.
+	CreatedSince time.Time
+	// CreatedBefore selects only events created before that time, if it is
+	// not zero.
+	CreatedBefore time.Time
+}
+
+// Matches returns whether the envelope is selected by the filter.
+// This is used by stores that can't apply the filter otherwise.
+func (f *Filter) Matches(env Envelope) bool {
+	if len(f.Classes) > 0 && !slices.Contains(f.Classes, env.Event().Class()) {
+		return false
+	}
+	if f.CausationID != 0 && env.CausationID() != f.CausationID {
+		return false
+	}
+	switch f.ExternalUUID {
+	case Present:
+		if env.ExternalUUID() == uuid.Nil {
+			return false
+		}
+	case Absent:
+		if env.ExternalUUID() != uuid.Nil {
+			return false
+		}
+	}
+	if !f.CreatedSince.IsZero() && env.Created().Before(f.CreatedSince) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !env.Created().Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// Range selects the part of the event log read by EventStore.LoadEvents.
+//
+// The zero value selects all events in ascending order of their IDs.
+type Range struct {
+	// StartAfter is the ID preceding the first event to read. If it is zero,
+	// reading starts with the first event in the store.
+	StartAfter EventID
+	// StartBefore is the ID following the last event to read. If it is zero,
+	// reading ends with the last event in the store.
+	StartBefore EventID
+	// Limit is the maximum number of events to read. The limit is applied
+	// after the filter, so it counts only the events actually emitted. Zero
+	// means no limit.
+	Limit int
+	// Backward reads the events in descending order of their IDs, i.e. the
+	// newest event first. Together with Limit, this reads the latest events.
+	Backward bool
+}
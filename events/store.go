@@ -5,11 +5,6 @@ package events
 // Generally, this is defined to be agnostic of the storage infrastructure
 // behind it. Its main parts are functions to insert events and to follow
 // the stream of events.
-//
-// TODO:
-//  - The ID is also specific to the default integer representation in MongoDB.
-//    For proper function, only a strict ordering is required, it doesn't have
-//    to take the form of an integer sequence.
 
 import (
 	"context"
@@ -18,19 +13,38 @@ import (
 	"github.com/gofrs/uuid"
 )
 
+// EventID identifies an event within a store.
+// IDs are assigned in strictly increasing order, so they also serve as the
+// position of the event in the store. They are not necessarily contiguous.
+// Zero is never used as ID, it is used to express the absence of an event.
+type EventID int64
+
 // The Envelope is a container for the actual event, which it carries as
 // payload. In addition, it contains the time when the envelope was persisted
 // and an ID which is a sequence counter.
 type Envelope interface {
 	// ID returns the identifier for the event.
-	ID() int32
+	ID() EventID
 	// Created returns the time the event was persisted.
 	Created() time.Time
 	// ExternalUUID returns the (optional) UUID assigned to the event
 	ExternalUUID() uuid.UUID
 	// CausationID returns the ID of the event that caused this event.
 	// This can be zero if this event was not caused by another event.
-	CausationID() int32
+	CausationID() EventID
+	// CorrelationID returns the ID of the event that started the chain of
+	// events this event belongs to. This can be zero if this event doesn't
+	// belong to such a chain or if it starts one.
+	CorrelationID() EventID
+	// Metadata returns additional info attached to the event, like the actor
+	// or the trace context. This can be nil if there is no such info.
+	Metadata() map[string]string
+	// Stream returns the name of the stream the event belongs to.
+	// This is empty if the event doesn't belong to a stream.
+	Stream() string
+	// Version returns the position of the event within its stream, starting
+	// with one. This is zero if the event doesn't belong to a stream.
+	Version() int32
 	// Event returns the event contained in the envelope.
 	Event() Event
 }
@@ -42,13 +56,39 @@ type Envelope interface {
 // by the `Event` type instead.
 type Notification interface {
 	// ID returns the identifier for the event associated with the notification.
-	ID() int32
+	ID() EventID
+}
+
+// AnyVersion can be used as expected version in order to append to a stream
+// regardless of its current version.
+const AnyVersion int32 = -1
+
+// NewEvent describes an event to insert, together with the data that is
+// attached to it in the envelope. See EventStore.Insert for details.
+//
+// The event can optionally be appended to a named stream. Every stream has a
+// version, which is the number of events in it. The expected version must
+// match the version of the stream before appending the event, otherwise a
+// VersionConflictError is returned. Zero means that the stream must not
+// exist yet. Use AnyVersion to skip this check. Without a stream, the
+// expected version is ignored.
+//
+// The correlation ID and the metadata are stored as they are. It is up to the
+// code creating the event to propagate them from the event that caused it.
+type NewEvent struct {
+	ExternalUUID    uuid.UUID
+	Event           Event
+	CausationID     EventID
+	CorrelationID   EventID
+	Metadata        map[string]string
+	Stream          string
+	ExpectedVersion int32
 }
 
 // The EventStore interface defines a few basic functions that an event store has to provide.
 type EventStore interface {
 	// ParseEventID parses a string that represents an event identifier.
-	ParseEventID(str string) (int32, error)
+	ParseEventID(str string) (EventID, error)
 
 	// Retrieve error state of the event store.
 	// All functions below set this error state in order to signal failure.
@@ -65,21 +105,37 @@ type EventStore interface {
 	// If the UUID is already used, DuplicateEventUUID is returned as error.
 	// The causation ID is that of the preceding event that caused this new
 	// event. It can be zero when its cause is not a preceding event.
-	Insert(ctx context.Context, externalUUID uuid.UUID, event Event, causationID int32) (Envelope, error)
+	// The event isn't appended to any stream, so there is no expected
+	// version to check either. Use InsertMany() for appending to a stream,
+	// the expected version is then passed with every NewEvent.
+	Insert(ctx context.Context, externalUUID uuid.UUID, event Event, causationID EventID) (Envelope, error)
+
+	// Insert several events atomically.
+	// Either all events are stored or none of them. The envelopes are
+	// returned in the same order as the events, with ascending IDs. The
+	// external UUIDs must be unique within the batch, too. Since the IDs are
+	// only assigned on insert, the causation IDs can't refer to other events
+	// of the same batch. Several events of a batch can be appended to the
+	// same stream, the expected version of each one must then include the
+	// preceding events of the batch.
+	// If the store can't insert several events atomically, e.g. MongoDB
+	// without a replica set, a batch of more than one event fails with
+	// BatchesNotSupported. Single events can always be inserted.
+	InsertMany(ctx context.Context, events []NewEvent) ([]Envelope, error)
 
 	// Resolve an external UUID to the according internal ID
-	ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (int32, error)
+	ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (EventID, error)
 
 	// Retrieve just the event with the given ID.
-	RetrieveOne(ctx context.Context, id int32) (Envelope, error)
+	RetrieveOne(ctx context.Context, id EventID) (Envelope, error)
 
 	// Retrieve existing events.
 	//
-	// The events are provided via the returned channel. `startAfter` parameter
-	// specifies the ID preceding the first event to retrieve. If this is zero,
-	// the first event from the store is loaded first. The channel is closed
-	// when all events have been retrieved.
-	LoadEvents(ctx context.Context, startAfter int32) (<-chan Envelope, error)
+	// The events are provided via the returned channel. The range specifies
+	// the part of the event log to read and the order, see Range for details.
+	// Only events selected by the filter are emitted. The channel is closed
+	// when all events in the range have been retrieved.
+	LoadEvents(ctx context.Context, bounds Range, filter Filter) (<-chan Envelope, error)
 
 	// Follow the stream of notifications.
 	//
@@ -91,7 +147,124 @@ type EventStore interface {
 	//
 	// The events are provided via the returned channel. `startAfter` parameter
 	// specifies the ID preceding the first event to retrieve. If this is zero,
-	// the first event from the store is loaded first. When all events from the
-	// store are emitted, the channel blocks until new events are stored.
-	FollowEvents(ctx context.Context, startAfter int32) (<-chan Envelope, error)
+	// the first event from the store is loaded first. Only events selected by
+	// the filter are emitted. When all events from the store are emitted, the
+	// channel blocks until new events are stored.
+	FollowEvents(ctx context.Context, startAfter EventID, filter Filter) (<-chan Envelope, error)
+}
+
+// The StreamLoader interface is implemented by event stores that can retrieve
+// the events of a single stream.
+type StreamLoader interface {
+	// Retrieve the events of a stream.
+	//
+	// The events are provided via the returned channel, ordered by their
+	// version. `fromVersion` specifies the version of the first event to
+	// retrieve. The channel is closed when all events have been retrieved.
+	LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan Envelope, error)
+}
+
+// The CausationLoader interface is implemented by event stores that can
+// retrieve the tree of events caused by an event.
+type CausationLoader interface {
+	// Retrieve the events caused by the given event, directly or indirectly.
+	//
+	// The events are provided via the returned channel, ordered by their ID,
+	// so every event is emitted after the event that caused it. The given
+	// event itself is not included. The channel is closed when all events
+	// have been retrieved.
+	LoadCaused(ctx context.Context, id EventID) (<-chan Envelope, error)
+}
+
+// The Migrator interface is implemented by event stores that manage the
+// schema of their underlying storage themselves.
+type Migrator interface {
+	// Migrate creates the schema of the storage or updates it to the latest
+	// version. Already applied migrations are skipped, so this can be called
+	// repeatedly. It returns the schema version after the migration.
+	Migrate(ctx context.Context) (int, error)
+}
+
+// Checkpoint records the position of a consumer in the event log.
+type Checkpoint struct {
+	// Consumer is the name identifying the consumer.
+	Consumer string
+	// EventID is the ID of the last event handled by the consumer.
+	EventID EventID
+	// Updated is the time when the checkpoint was last saved.
+	Updated time.Time
+}
+
+// The CheckpointStore interface is implemented by event stores that can
+// persist the positions of consumers alongside the events.
+//
+// A consumer saves the ID of the last event it handled under its name, so that
+// it can resume after that event when it is restarted.
+type CheckpointStore interface {
+	// LoadCheckpoint returns the ID of the last event handled by the
+	// consumer, or zero if there is no checkpoint for it.
+	LoadCheckpoint(ctx context.Context, consumer string) (EventID, error)
+
+	// SaveCheckpoint records the ID of the last event handled by the
+	// consumer, replacing any previous checkpoint.
+	SaveCheckpoint(ctx context.Context, consumer string, id EventID) error
+
+	// ListCheckpoints returns all checkpoints, ordered by consumer name.
+	ListCheckpoints(ctx context.Context) ([]Checkpoint, error)
+
+	// ResetCheckpoint removes the checkpoint of the consumer, so that it
+	// starts from the beginning again. Removing a checkpoint that doesn't
+	// exist is not an error.
+	ResetCheckpoint(ctx context.Context, consumer string) error
+}
+
+// Snapshot is the serialized state of a consumer after handling an event.
+type Snapshot struct {
+	// Consumer is the name identifying the consumer.
+	Consumer string
+	// EventID is the ID of the last event reflected in the state.
+	EventID EventID
+	// Data is the serialized state, its format is up to the consumer.
+	Data []byte
+	// Created is the time when the snapshot was saved.
+	Created time.Time
+}
+
+// The SnapshotStore interface is implemented by event stores that can persist
+// snapshots of the state consumers build from the events.
+//
+// A consumer saves its state from time to time, so that it doesn't have to
+// replay the whole history when it is restarted, but only the events after
+// the snapshot. Only the latest snapshot of every consumer is kept.
+type SnapshotStore interface {
+	// LoadSnapshot returns the latest snapshot of the consumer, or nil if
+	// there is none.
+	LoadSnapshot(ctx context.Context, consumer string) (*Snapshot, error)
+
+	// SaveSnapshot stores the state of the consumer after the event with the
+	// given ID, replacing any previous snapshot.
+	SaveSnapshot(ctx context.Context, consumer string, id EventID, data []byte) error
+}
+
+// QuarantinedEvent records a stored event that could not be decoded.
+type QuarantinedEvent struct {
+	// EventID is the ID of the event.
+	EventID EventID
+	// Class is the class of the event.
+	Class string
+	// Reason describes why the event could not be decoded.
+	Reason string
+	// Quarantined is the time when the event was last quarantined.
+	Quarantined time.Time
+}
+
+// The Quarantine interface is implemented by event stores that can record
+// events that could not be decoded, see DecodeQuarantine.
+type Quarantine interface {
+	// QuarantineEvent records that the event could not be decoded. An event
+	// that is quarantined again keeps a single record with the latest reason.
+	QuarantineEvent(ctx context.Context, id EventID, class string, reason string) error
+
+	// ListQuarantined returns the quarantined events, ordered by their ID.
+	ListQuarantined(ctx context.Context) ([]QuarantinedEvent, error)
 }
@@ -0,0 +1,734 @@
+package storetest
+
+// This package contains a conformance test suite for event stores.
+//
+// The tests describe the behaviour that is common to all backends. Every
+// backend runs them from its own tests, passing a factory that creates an
+// empty store. Tests of behaviour specific to a backend stay with it.
+
+import (
+	"api-broker-prototype/broker"
+	"api-broker-prototype/events"
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Factory creates an empty event store for the given test.
+// It is responsible for cleaning up the store when the test ends, and it may
+// skip the test if the store is not available.
+type Factory func(t *testing.T) events.EventStore
+
+// Codecs creates a registry with the codecs for the events used in the tests.
+func Codecs(t *testing.T) *events.CodecRegistry {
+	codecs := events.NewCodecRegistry()
+	if err := broker.RegisterCodecs(codecs); err != nil {
+		t.Fatalf("failed to register codecs: %v", err)
+	}
+	return codecs
+}
+
+// Run runs the conformance tests as subtests of the given test.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store events.EventStore)
+	}{
+		{"InsertMany", testInsertMany},
+		{"Codecs", testCodecs},
+		{"RawEvents", testRawEvents},
+		{"Streams", testStreams},
+		{"Metadata", testMetadata},
+		{"Filter", testFilter},
+		{"Range", testRange},
+		{"LoadCaused", testLoadCaused},
+		{"Checkpoints", testCheckpoints},
+		{"Snapshots", testSnapshots},
+		{"Quarantine", testQuarantine},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, factory(t))
+		})
+	}
+}
+
+// events of all classes used in the tests
+var codecEvents = []events.Event{
+	events.SimpleEvent{Message: "simple"},
+	broker.ConfigurationEvent{Retries: 3, Timeout: 2.5},
+	broker.ConfigurationEvent{Retries: -1, Timeout: 0},
+	broker.RequestEvent{Request: "request"},
+	broker.APIRequestEvent{Attempt: 2},
+	broker.APIResponseEvent{Attempt: 1, Response: "response"},
+	broker.APIFailureEvent{Attempt: 0, Failure: "failure"},
+	broker.APITimeoutEvent{Attempt: 3},
+}
+
+func testCodecs(t *testing.T, store events.EventStore) {
+	// all events survive the round trip through the store unchanged
+	insertAll(t, store, codecEvents)
+	checkEvents(t, store, codecEvents)
+}
+
+// insert the events one by one
+func insertAll(t *testing.T, store events.EventStore, stored []events.Event) {
+	for _, event := range stored {
+		if _, err := store.Insert(context.Background(), uuid.Nil, event, 0); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+// check that the store contains exactly the events
+func checkEvents(t *testing.T, store events.EventStore, stored []events.Event) {
+	envelopes := loadAll(t, store)
+	if len(envelopes) != len(stored) {
+		t.Fatalf("unexpected number of events %d", len(envelopes))
+	}
+	for i, env := range envelopes {
+		if !reflect.DeepEqual(env.Event(), stored[i]) {
+			t.Errorf("unexpected event %#v, expected %#v", env.Event(), stored[i])
+		}
+	}
+}
+
+func testRawEvents(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+
+	// events of unknown classes, e.g. copied from a store of a newer producer,
+	// keep their class, schema version and payload
+	raw := events.RawEvent{EventClass: "future", SchemaVersion: 2, Payload: events.Payload{"text": "value"}}
+	first, err := store.Insert(ctx, uuid.Nil, raw, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	second, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{Message: "known"}, first.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env, err := store.RetrieveOne(ctx, first.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(env.Event(), raw) {
+		t.Errorf("unexpected event %#v", env.Event())
+	}
+
+	// they are loaded along with the others and can be filtered by class
+	if ids := loadIDs(t, store, events.Range{}, events.Filter{}); !slices.Equal(ids, []events.EventID{first.ID(), second.ID()}) {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+	if ids := loadIDs(t, store, events.Range{}, events.Filter{Classes: []string{"future"}}); !slices.Equal(ids, []events.EventID{first.ID()}) {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+}
+
+// load all events from the store
+func loadAll(t *testing.T, store events.EventStore) []events.Envelope {
+	ch, err := store.LoadEvents(context.Background(), events.Range{}, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var res []events.Envelope
+	for env := range ch {
+		res = append(res, env)
+	}
+	if err := store.Error(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return res
+}
+
+func testInsertMany(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+
+	externalUUID := uuid.FromStringOrNil("22428f46-a2d8-4d51-b6b5-bc8551bd0921")
+
+	first, err := store.Insert(ctx, externalUUID, events.SimpleEvent{Message: "first"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	envs, err := store.InsertMany(ctx, []events.NewEvent{
+		{Event: events.SimpleEvent{Message: "second"}, CausationID: first.ID()},
+		{Event: events.SimpleEvent{Message: "third"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(envs) != 2 || envs[0].ID() <= first.ID() || envs[1].ID() <= envs[0].ID() {
+		t.Fatalf("unexpected envelopes %v", envs)
+	}
+	if envs[0].CausationID() != first.ID() || envs[1].CausationID() != 0 {
+		t.Errorf("unexpected envelopes %v", envs)
+	}
+
+	// a duplicate UUID fails the whole batch, also within the batch
+	otherUUID := uuid.FromStringOrNil("c0d9f6a4-3ab5-4bd3-9c5b-5b1f3f3cf1a5")
+	batches := [][]events.NewEvent{
+		{{Event: events.SimpleEvent{}}, {ExternalUUID: externalUUID, Event: events.SimpleEvent{}}},
+		{{ExternalUUID: otherUUID, Event: events.SimpleEvent{}}, {ExternalUUID: otherUUID, Event: events.SimpleEvent{}}},
+	}
+	for _, batch := range batches {
+		if _, err := store.InsertMany(ctx, batch); !errors.Is(err, events.DuplicateEventUUID) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if _, err := store.ResolveUUID(ctx, otherUUID); err == nil {
+		t.Errorf("expected error missing")
+	}
+
+	// nothing of the failed batches was stored
+	if envelopes := loadAll(t, store); len(envelopes) != 3 {
+		t.Errorf("unexpected number of events %d", len(envelopes))
+	}
+}
+
+func testStreams(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+
+	envs, err := store.InsertMany(ctx, []events.NewEvent{
+		{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: 0},
+		{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: 1},
+		{Event: events.SimpleEvent{}, Stream: "b", ExpectedVersion: 0},
+		{Event: events.SimpleEvent{}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := []int32{1, 2, 1, 0}
+	for i, env := range envs {
+		if env.Version() != expected[i] {
+			t.Errorf("unexpected version %d of event %d", env.Version(), env.ID())
+		}
+	}
+
+	// a mismatching version fails with a conflict
+	_, err = store.InsertMany(ctx, []events.NewEvent{{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: 1}})
+	var conflict *events.VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Actual != 2 {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := store.Error(); err != nil {
+		t.Fatalf("unexpected error state %v", err)
+	}
+
+	// appending regardless of the version
+	third, err := store.InsertMany(ctx, []events.NewEvent{{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: events.AnyVersion}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env, err := store.RetrieveOne(ctx, third[0].ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env.Stream() != "a" || env.Version() != 3 {
+		t.Errorf("unexpected stream %q version %d", env.Stream(), env.Version())
+	}
+
+	loader, ok := store.(events.StreamLoader)
+	if !ok {
+		t.Skip("loading streams is not supported")
+	}
+	ch, err := loader.LoadStream(ctx, "a", 2)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var ids []events.EventID
+	for env := range ch {
+		ids = append(ids, env.ID())
+	}
+	if len(ids) != 2 || ids[0] != envs[1].ID() || ids[1] != third[0].ID() {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+}
+
+func testMetadata(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+
+	envs, err := store.InsertMany(ctx, []events.NewEvent{
+		{Event: events.SimpleEvent{}, CorrelationID: 7, Metadata: map[string]string{"actor": "alice"}},
+		{Event: events.SimpleEvent{}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	envelopes := loadAll(t, store)
+	if len(envelopes) != 2 {
+		t.Fatalf("unexpected number of events %d", len(envelopes))
+	}
+	if envelopes[0].CorrelationID() != 7 || envelopes[0].Metadata()["actor"] != "alice" {
+		t.Errorf("unexpected correlation ID %d and metadata %v", envelopes[0].CorrelationID(), envelopes[0].Metadata())
+	}
+	if envelopes[1].CorrelationID() != 0 || len(envelopes[1].Metadata()) != 0 {
+		t.Errorf("unexpected correlation ID %d and metadata %v", envelopes[1].CorrelationID(), envelopes[1].Metadata())
+	}
+
+	env, err := store.RetrieveOne(ctx, envs[0].ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env.CorrelationID() != 7 || env.Metadata()["actor"] != "alice" {
+		t.Errorf("unexpected correlation ID %d and metadata %v", env.CorrelationID(), env.Metadata())
+	}
+}
+
+// load the IDs of the events selected by the given range and filter
+func loadIDs(t *testing.T, store events.EventStore, bounds events.Range, filter events.Filter) []events.EventID {
+	ch, err := store.LoadEvents(context.Background(), bounds, filter)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var ids []events.EventID
+	for env := range ch {
+		ids = append(ids, env.ID())
+	}
+	if err := store.Error(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return ids
+}
+
+func testFilter(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+
+	externalUUID := uuid.FromStringOrNil("22428f46-a2d8-4d51-b6b5-bc8551bd0921")
+	first, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	second, err := store.Insert(ctx, externalUUID, broker.RequestEvent{Request: "test"}, first.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	third, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, second.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		filter   events.Filter
+		expected []events.EventID
+	}{
+		{"all", events.Filter{}, []events.EventID{first.ID(), second.ID(), third.ID()}},
+		{"classes", events.Filter{Classes: []string{"simple", "other"}}, []events.EventID{first.ID(), third.ID()}},
+		{"causation", events.Filter{CausationID: second.ID()}, []events.EventID{third.ID()}},
+		{"with UUID", events.Filter{ExternalUUID: events.Present}, []events.EventID{second.ID()}},
+		{"without UUID", events.Filter{ExternalUUID: events.Absent}, []events.EventID{first.ID(), third.ID()}},
+		{"created since", events.Filter{CreatedSince: time.Now().Add(time.Hour)}, nil},
+		{"created before", events.Filter{CreatedBefore: time.Now().Add(time.Hour)}, []events.EventID{first.ID(), second.ID(), third.ID()}},
+		{"combined", events.Filter{Classes: []string{"simple"}, CausationID: first.ID()}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if ids := loadIDs(t, store, events.Range{}, test.filter); !slices.Equal(ids, test.expected) {
+				t.Errorf("unexpected IDs %v", ids)
+			}
+		})
+	}
+}
+
+func testRange(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+
+	var ids []events.EventID
+	for i := 0; i < 5; i++ {
+		class := events.Event(events.SimpleEvent{})
+		if i%2 == 1 {
+			class = broker.RequestEvent{Request: "test"}
+		}
+		env, err := store.Insert(ctx, uuid.Nil, class, 0)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		ids = append(ids, env.ID())
+	}
+
+	tests := []struct {
+		name     string
+		bounds   events.Range
+		filter   events.Filter
+		expected []events.EventID
+	}{
+		{"all", events.Range{}, events.Filter{}, ids},
+		{"start before", events.Range{StartBefore: ids[2]}, events.Filter{}, ids[:2]},
+		{"bounded", events.Range{StartAfter: ids[0], StartBefore: ids[4]}, events.Filter{}, ids[1:4]},
+		{"limit", events.Range{Limit: 2}, events.Filter{}, ids[:2]},
+		{"backward", events.Range{Backward: true}, events.Filter{}, []events.EventID{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{"last", events.Range{Limit: 2, Backward: true}, events.Filter{}, []events.EventID{ids[4], ids[3]}},
+		{"page backward", events.Range{StartBefore: ids[3], Limit: 2, Backward: true}, events.Filter{}, []events.EventID{ids[2], ids[1]}},
+		{"limit after filter", events.Range{Limit: 2}, events.Filter{Classes: []string{"simple"}}, []events.EventID{ids[0], ids[2]}},
+		{"empty", events.Range{StartAfter: ids[2], StartBefore: ids[3]}, events.Filter{}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if loaded := loadIDs(t, store, test.bounds, test.filter); !slices.Equal(loaded, test.expected) {
+				t.Errorf("unexpected IDs %v", loaded)
+			}
+		})
+	}
+}
+
+func testLoadCaused(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+	loader, ok := store.(events.CausationLoader)
+	if !ok {
+		t.Skip("loading caused events is not supported")
+	}
+
+	insert := func(causationID events.EventID) events.EventID {
+		env, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, causationID)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return env.ID()
+	}
+	root := insert(0)
+	child := insert(root)
+	grandchild := insert(child)
+	unrelated := insert(0)
+	sibling := insert(root)
+
+	load := func(id events.EventID) []events.EventID {
+		ch, err := loader.LoadCaused(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		var ids []events.EventID
+		for env := range ch {
+			ids = append(ids, env.ID())
+		}
+		return ids
+	}
+	if ids := load(root); !slices.Equal(ids, []events.EventID{child, grandchild, sibling}) {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+	if ids := load(child); !slices.Equal(ids, []events.EventID{grandchild}) {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+	if ids := load(unrelated); len(ids) != 0 {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+	if _, err := loader.LoadCaused(ctx, 0); err == nil {
+		t.Errorf("expected error for null ID")
+	}
+}
+
+func testCheckpoints(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+	checkpoints, ok := store.(events.CheckpointStore)
+	if !ok {
+		t.Skip("checkpoints are not supported")
+	}
+
+	// a consumer without checkpoint starts at the beginning
+	if id, err := checkpoints.LoadCheckpoint(ctx, "a"); err != nil || id != 0 {
+		t.Errorf("unexpected ID %d (error %v)", id, err)
+	}
+
+	// saving replaces a previous checkpoint
+	for _, checkpoint := range []struct {
+		consumer string
+		id       events.EventID
+	}{{"b", 3}, {"a", 1}, {"a", 2}} {
+		if err := checkpoints.SaveCheckpoint(ctx, checkpoint.consumer, checkpoint.id); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if id, err := checkpoints.LoadCheckpoint(ctx, "a"); err != nil || id != 2 {
+		t.Errorf("unexpected ID %d (error %v)", id, err)
+	}
+
+	// the checkpoints are listed by consumer name
+	res, err := checkpoints.ListCheckpoints(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(res) != 2 || res[0].Consumer != "a" || res[0].EventID != 2 || res[1].Consumer != "b" || res[1].EventID != 3 {
+		t.Errorf("unexpected checkpoints %v", res)
+	}
+	if res[0].Updated.IsZero() || time.Since(res[0].Updated) > time.Hour {
+		t.Errorf("unexpected update time %v", res[0].Updated)
+	}
+
+	// resetting removes the checkpoint, also if there is none
+	for _, consumer := range []string{"a", "a", "c"} {
+		if err := checkpoints.ResetCheckpoint(ctx, consumer); err != nil {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if id, err := checkpoints.LoadCheckpoint(ctx, "a"); err != nil || id != 0 {
+		t.Errorf("unexpected ID %d (error %v)", id, err)
+	}
+	if res, err := checkpoints.ListCheckpoints(ctx); err != nil || len(res) != 1 {
+		t.Errorf("unexpected checkpoints %v (error %v)", res, err)
+	}
+}
+
+func testSnapshots(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+	snapshots, ok := store.(events.SnapshotStore)
+	if !ok {
+		t.Skip("snapshots are not supported")
+	}
+
+	// a consumer without snapshot gets none
+	if snapshot, err := snapshots.LoadSnapshot(ctx, "a/b"); err != nil || snapshot != nil {
+		t.Errorf("unexpected snapshot %v (error %v)", snapshot, err)
+	}
+
+	// saving replaces a previous snapshot
+	for _, snapshot := range []struct {
+		consumer string
+		id       events.EventID
+		data     string
+	}{{"c", 3, "third"}, {"a/b", 1, "first"}, {"a/b", 2, "second"}} {
+		if err := snapshots.SaveSnapshot(ctx, snapshot.consumer, snapshot.id, []byte(snapshot.data)); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	snapshot, err := snapshots.LoadSnapshot(ctx, "a/b")
+	if err != nil || snapshot == nil {
+		t.Fatalf("unexpected snapshot %v (error %v)", snapshot, err)
+	}
+	if snapshot.Consumer != "a/b" || snapshot.EventID != 2 || string(snapshot.Data) != "second" {
+		t.Errorf("unexpected snapshot %v", snapshot)
+	}
+	if snapshot.Created.IsZero() || time.Since(snapshot.Created) > time.Hour {
+		t.Errorf("unexpected creation time %v", snapshot.Created)
+	}
+	if snapshot, err := snapshots.LoadSnapshot(ctx, "c"); err != nil || snapshot == nil || string(snapshot.Data) != "third" {
+		t.Errorf("unexpected snapshot %v (error %v)", snapshot, err)
+	}
+}
+
+func testQuarantine(t *testing.T, store events.EventStore) {
+	ctx := context.Background()
+	quarantine, ok := store.(events.Quarantine)
+	if !ok {
+		t.Skip("quarantine is not supported")
+	}
+
+	// the quarantine is empty initially
+	if res, err := quarantine.ListQuarantined(ctx); err != nil || len(res) != 0 {
+		t.Errorf("unexpected quarantined events %v (error %v)", res, err)
+	}
+
+	// quarantining an event again replaces the reason
+	for _, event := range []struct {
+		id     events.EventID
+		reason string
+	}{{3, "third"}, {1, "first"}, {3, "again"}} {
+		if err := quarantine.QuarantineEvent(ctx, event.id, "simple", event.reason); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	res, err := quarantine.ListQuarantined(ctx)
+	if err != nil || len(res) != 2 {
+		t.Fatalf("unexpected quarantined events %v (error %v)", res, err)
+	}
+	if res[0].EventID != 1 || res[0].Reason != "first" || res[1].EventID != 3 || res[1].Reason != "again" || res[1].Class != "simple" {
+		t.Errorf("unexpected quarantined events %v", res)
+	}
+	if res[1].Quarantined.IsZero() || time.Since(res[1].Quarantined) > time.Hour {
+		t.Errorf("unexpected quarantine time %v", res[1].Quarantined)
+	}
+}
+
+// CodecFactory creates an empty event store using the given codecs.
+// Otherwise, it behaves like a Factory.
+type CodecFactory func(t *testing.T, codecs *events.CodecRegistry) events.EventStore
+
+// event that is stored but can't be decoded
+type undecodableEvent struct{}
+
+// Class implements the Event interface.
+func (e undecodableEvent) Class() string {
+	return "undecodable"
+}
+
+// codec for undecodableEvents, which panics when decoding
+type undecodableEventCodec struct{}
+
+// Class implements the Codec interface.
+func (codec *undecodableEventCodec) Class() string {
+	return "undecodable"
+}
+
+// Serialize implements the Codec interface.
+func (codec *undecodableEventCodec) Serialize(e events.Event) (events.Payload, error) {
+	return events.Payload{"value": "broken"}, nil
+}
+
+// Deserialize implements the Codec interface.
+func (codec *undecodableEventCodec) Deserialize(data events.Payload) (events.Event, error) {
+	panic("undecodable event")
+}
+
+// RunDecodePolicy runs the tests of the decode policies as subtests of the
+// given test. They are separate from the conformance tests, because they
+// require control over the codecs of the store.
+func RunDecodePolicy(t *testing.T, factory CodecFactory) {
+	for _, policy := range []events.DecodePolicy{events.DecodeFail, events.DecodeSkip, events.DecodeQuarantine} {
+		t.Run(policy.String(), func(t *testing.T) {
+			testDecodePolicy(t, factory, policy)
+		})
+	}
+}
+
+func testDecodePolicy(t *testing.T, factory CodecFactory, policy events.DecodePolicy) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	codecs := Codecs(t)
+	if err := codecs.Register(&undecodableEventCodec{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	codecs.SetDecodePolicy(policy, nil)
+	store := factory(t, codecs)
+
+	// store an undecodable event between two others
+	externalUUID := uuid.Must(uuid.NewV4())
+	var envelopes []events.Envelope
+	for _, event := range []events.NewEvent{
+		{Event: events.SimpleEvent{Message: "first"}},
+		{Event: undecodableEvent{}, ExternalUUID: externalUUID},
+		{Event: events.SimpleEvent{Message: "third"}},
+	} {
+		env, err := store.Insert(ctx, event.ExternalUUID, event.Event, 0)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		envelopes = append(envelopes, env)
+	}
+	undecodable := envelopes[1].ID()
+
+	// retrieving the event returns the details, regardless of the policy
+	_, err := store.RetrieveOne(ctx, undecodable)
+	var decodeErr *events.DecodeError
+	if !errors.Is(err, events.UndecodableEvent) || !errors.As(err, &decodeErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if decodeErr.EventID != undecodable || decodeErr.Class != "undecodable" {
+		t.Errorf("unexpected details %+v", decodeErr)
+	}
+	if id, err := store.ResolveUUID(ctx, externalUUID); err != nil || id != undecodable {
+		t.Errorf("unexpected ID %d (error %v)", id, err)
+	}
+	if err := store.Error(); err != nil {
+		t.Fatalf("unexpected error state %v", err)
+	}
+
+	// the undecodable event is a valid start for loading and following
+	ch, err := store.LoadEvents(ctx, events.Range{StartAfter: undecodable}, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env, ok := <-ch; !ok || env.ID() != envelopes[2].ID() {
+		t.Errorf("unexpected event %v (error %v)", env, store.Error())
+	}
+	for range ch {
+	}
+	followCtx, followCancel := context.WithCancel(ctx)
+	ch, err = store.FollowEvents(followCtx, undecodable, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env, ok := <-ch; !ok || env.ID() != envelopes[2].ID() {
+		t.Errorf("unexpected event %v (error %v)", env, store.Error())
+	}
+	followCancel()
+	for range ch {
+	}
+
+	// loading several events applies the policy
+	ch, err = store.LoadEvents(ctx, events.Range{}, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var ids []events.EventID
+	for env := range ch {
+		ids = append(ids, env.ID())
+	}
+	if policy == events.DecodeFail {
+		if !slices.Equal(ids, []events.EventID{envelopes[0].ID()}) {
+			t.Errorf("unexpected events %v", ids)
+		}
+		if err := store.Error(); !errors.As(err, &decodeErr) || decodeErr.EventID != undecodable {
+			t.Errorf("unexpected error state %v", err)
+		}
+		return
+	}
+	if !slices.Equal(ids, []events.EventID{envelopes[0].ID(), envelopes[2].ID()}) {
+		t.Errorf("unexpected events %v", ids)
+	}
+	if err := store.Error(); err != nil {
+		t.Fatalf("unexpected error state %v", err)
+	}
+
+	// followers skip the event as well
+	ch, err = store.FollowEvents(ctx, 0, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, expected := range []events.EventID{envelopes[0].ID(), envelopes[2].ID()} {
+		if env, ok := <-ch; !ok || env.ID() != expected {
+			t.Fatalf("unexpected event %v, expected %d (error %v)", env, expected, store.Error())
+		}
+	}
+
+	if policy == events.DecodeQuarantine {
+		quarantine, ok := store.(events.Quarantine)
+		if !ok {
+			t.Fatal("quarantine is not supported")
+		}
+		res, err := quarantine.ListQuarantined(ctx)
+		if err != nil || len(res) != 1 || res[0].EventID != undecodable || res[0].Class != "undecodable" {
+			t.Errorf("unexpected quarantined events %v (error %v)", res, err)
+		}
+	}
+}
+
+// RunEncodings runs the tests of the payload encodings as subtests of the
+// given test. They are separate from the conformance tests, because they
+// require control over the codecs of the store.
+func RunEncodings(t *testing.T, factory CodecFactory) {
+	for _, name := range []string{"json", "cbor"} {
+		t.Run(name, func(t *testing.T) {
+			encoding, err := events.ParseEncoding(name)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			testEncoding(t, factory, encoding)
+		})
+	}
+}
+
+func testEncoding(t *testing.T, factory CodecFactory, encoding events.Encoding) {
+	codecs := Codecs(t)
+	codecs.SetEncoding(encoding)
+	store := factory(t, codecs)
+
+	// all events survive the round trip through the store unchanged,
+	// including events of unknown classes
+	stored := append(slices.Clone(codecEvents), events.RawEvent{
+		EventClass:    "future",
+		SchemaVersion: 1,
+		Payload:       events.Payload{"text": "value"},
+	})
+	insertAll(t, store, stored)
+	checkEvents(t, store, stored)
+
+	// events stored with another encoding remain readable, this simulates a
+	// producer whose configuration changed
+	codecs.SetEncoding(events.JSON)
+	insertAll(t, store, codecEvents)
+	checkEvents(t, store, append(stored, codecEvents...))
+}
@@ -0,0 +1,181 @@
+package events
+
+// This file implements a codec for any event type that is a struct.
+//
+// The codec uses reflection to convert the fields of the struct to the
+// payload and back, so that new event types don't need a hand-written codec.
+// The name of a field in the payload is taken from the "event" tag of the
+// struct field, like in
+//
+//	type SomeEvent struct {
+//		Attempt uint   `event:"attempt"`
+//		Message string `event:"message"`
+//		Ignored bool   `event:"-"`
+//	}
+//
+// Fields without a tag use the name of the struct field, unexported fields
+// are ignored. Supported field types are strings, booleans, integers and
+// floating point numbers.
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// field of a struct converted by a structCodec
+type structField struct {
+	index int
+	name  string
+	kind  reflect.Kind
+}
+
+// codec converting a struct using reflection
+type structCodec struct {
+	class  string
+	typ    reflect.Type
+	fields []structField
+}
+
+// NewStructCodec creates a Codec for the event type of the prototype.
+// The event type must be a struct whose fields all have supported types,
+// otherwise this returns an error.
+func NewStructCodec(prototype Event) (Codec, error) {
+	if prototype == nil {
+		return nil, errors.New("nil prototype for codec")
+	}
+	typ := reflect.TypeOf(prototype)
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("event type %v is not a struct", typ)
+	}
+
+	codec := &structCodec{
+		class: prototype.Class(),
+		typ:   typ,
+	}
+	names := make(map[string]bool)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Tag.Get("event")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate field %q in event type %v", name, typ)
+		}
+		names[name] = true
+
+		switch kind := field.Type.Kind(); kind {
+		case reflect.String, reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			codec.fields = append(codec.fields, structField{index: i, name: name, kind: kind})
+		default:
+			return nil, fmt.Errorf("field %s of event type %v has unsupported type %v", field.Name, typ, field.Type)
+		}
+	}
+	return codec, nil
+}
+
+// Class implements the Codec interface.
+func (codec *structCodec) Class() string {
+	return codec.class
+}
+
+// Serialize implements the Codec interface.
+// Integers are stored as int64, except for the types of up to 32 bits, which
+// are stored as int32. Floating point numbers are stored as float64.
+func (codec *structCodec) Serialize(e Event) (Payload, error) {
+	val := reflect.ValueOf(e)
+	if val.Type() != codec.typ {
+		return nil, fmt.Errorf("event of type %v passed to codec for %v", val.Type(), codec.typ)
+	}
+
+	res := make(Payload, len(codec.fields))
+	for _, field := range codec.fields {
+		fieldVal := val.Field(field.index)
+		switch field.kind {
+		case reflect.String:
+			res[field.name] = fieldVal.String()
+		case reflect.Bool:
+			res[field.name] = fieldVal.Bool()
+		case reflect.Int8, reflect.Int16, reflect.Int32:
+			res[field.name] = int32(fieldVal.Int())
+		case reflect.Int, reflect.Int64:
+			res[field.name] = fieldVal.Int()
+		case reflect.Uint8, reflect.Uint16:
+			res[field.name] = int32(fieldVal.Uint())
+		case reflect.Uint, reflect.Uint32, reflect.Uint64:
+			if fieldVal.Uint() > math.MaxInt64 {
+				return nil, fmt.Errorf("field %q is out of range", field.name)
+			}
+			res[field.name] = int64(fieldVal.Uint())
+		case reflect.Float32, reflect.Float64:
+			res[field.name] = fieldVal.Float()
+		}
+	}
+	return res, nil
+}
+
+// Deserialize implements the Codec interface.
+// Every field must be present in the payload and have a value of the right
+// type that fits into the struct field.
+func (codec *structCodec) Deserialize(data Payload) (Event, error) {
+	res := reflect.New(codec.typ).Elem()
+	for _, field := range codec.fields {
+		if _, ok := data[field.name]; !ok {
+			return nil, fieldError(field.name, "missing")
+		}
+		fieldVal := res.Field(field.index)
+		switch field.kind {
+		case reflect.String:
+			val, err := data.StringField(field.name)
+			if err != nil {
+				return nil, err
+			}
+			fieldVal.SetString(val)
+		case reflect.Bool:
+			val, err := data.BoolField(field.name)
+			if err != nil {
+				return nil, err
+			}
+			fieldVal.SetBool(val)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			val, err := data.IntField(field.name)
+			if err != nil {
+				return nil, err
+			}
+			if fieldVal.OverflowInt(val) {
+				return nil, fieldError(field.name, "out of range")
+			}
+			fieldVal.SetInt(val)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			val, err := data.IntField(field.name)
+			if err != nil {
+				return nil, err
+			}
+			if val < 0 || fieldVal.OverflowUint(uint64(val)) {
+				return nil, fieldError(field.name, "out of range")
+			}
+			fieldVal.SetUint(uint64(val))
+		case reflect.Float32, reflect.Float64:
+			val, err := data.FloatField(field.name)
+			if err != nil {
+				return nil, err
+			}
+			if fieldVal.OverflowFloat(val) {
+				return nil, fieldError(field.name, "out of range")
+			}
+			fieldVal.SetFloat(val)
+		}
+	}
+	return res.Interface().(Event), nil
+}
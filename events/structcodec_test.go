@@ -0,0 +1,181 @@
+package events
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// event with fields of all supported types
+type structEvent struct {
+	String  string  `event:"string"`
+	Bool    bool    `event:"bool"`
+	Int     int     `event:"int"`
+	Int8    int8    `event:"int8"`
+	Int32   int32   `event:"int32"`
+	Uint    uint    `event:"uint"`
+	Uint16  uint16  `event:"uint16"`
+	Float32 float32 `event:"float32"`
+	Float64 float64
+	Ignored string `event:"-"`
+	private string
+}
+
+// Class implements the Event interface.
+func (e structEvent) Class() string {
+	return "struct"
+}
+
+func TestStructCodec(t *testing.T) {
+	codec, err := NewStructCodec(structEvent{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if codec.Class() != "struct" {
+		t.Errorf("unexpected class %s", codec.Class())
+	}
+
+	event := structEvent{
+		String:  "text",
+		Bool:    true,
+		Int:     -1,
+		Int8:    -2,
+		Int32:   3,
+		Uint:    4,
+		Uint16:  5,
+		Float32: 6.5,
+		Float64: 7.5,
+		Ignored: "ignored",
+		private: "private",
+	}
+	data, err := codec.Serialize(event)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expected := Payload{
+		"string":  "text",
+		"bool":    true,
+		"int":     int64(-1),
+		"int8":    int32(-2),
+		"int32":   int32(3),
+		"uint":    int64(4),
+		"uint16":  int32(5),
+		"float32": float64(6.5),
+		"Float64": float64(7.5),
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Log("expected data", expected)
+		t.Log("received data", data)
+		t.Errorf("data differ")
+	}
+
+	res, err := codec.Deserialize(data)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	event.Ignored = ""
+	event.private = ""
+	if res != event {
+		t.Log("expected event", event)
+		t.Log("received event", res)
+		t.Errorf("events differ")
+	}
+
+	// other events are rejected
+	if _, err := codec.Serialize(SimpleEvent{}); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+func TestStructCodecValidation(t *testing.T) {
+	codec, err := NewStructCodec(structEvent{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	valid := func() Payload {
+		return Payload{
+			"string":  "text",
+			"bool":    false,
+			"int":     int64(0),
+			"int8":    int32(0),
+			"int32":   int32(0),
+			"uint":    int64(0),
+			"uint16":  int32(0),
+			"float32": float64(0),
+			"Float64": float64(0),
+		}
+	}
+	if _, err := codec.Deserialize(valid()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	cases := map[string]struct {
+		field string
+		value interface{}
+	}{
+		"missing field":        {"string", nil},
+		"string as number":     {"string", int64(1)},
+		"bool as string":       {"bool", "true"},
+		"int as string":        {"int", "1"},
+		"fractional int":       {"int", float64(1.5)},
+		"int8 overflow":        {"int8", int64(128)},
+		"int32 overflow":       {"int32", int64(math.MaxInt32 + 1)},
+		"negative uint":        {"uint", int64(-1)},
+		"uint16 overflow":      {"uint16", int64(math.MaxUint16 + 1)},
+		"float32 overflow":     {"float32", float64(math.MaxFloat64)},
+		"float64 as string":    {"Float64", "1.5"},
+		"float64 as bool":      {"Float64", true},
+		"uint as float string": {"uint", "1.0"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			data := valid()
+			if c.value == nil {
+				delete(data, c.field)
+			} else {
+				data[c.field] = c.value
+			}
+			event, err := codec.Deserialize(data)
+			if err == nil {
+				t.Errorf("expected error missing, received event %v", event)
+			}
+		})
+	}
+}
+
+// event with a field of an unsupported type
+type invalidEvent struct {
+	Values []string
+}
+
+// Class implements the Event interface.
+func (e invalidEvent) Class() string {
+	return "invalid"
+}
+
+// event with two fields of the same name
+type duplicateEvent struct {
+	First  string `event:"name"`
+	Second string `event:"name"`
+}
+
+// Class implements the Event interface.
+func (e duplicateEvent) Class() string {
+	return "duplicate"
+}
+
+// event that is no struct
+type stringEvent string
+
+// Class implements the Event interface.
+func (e stringEvent) Class() string {
+	return "string"
+}
+
+func TestStructCodecInvalid(t *testing.T) {
+	for _, prototype := range []Event{nil, invalidEvent{}, duplicateEvent{}, stringEvent("")} {
+		if _, err := NewStructCodec(prototype); err == nil {
+			t.Errorf("expected error missing for %T", prototype)
+		}
+	}
+}
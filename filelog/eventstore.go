@@ -0,0 +1,1329 @@
+package filelog
+
+// Eventstore built on top of append-only log files
+// The events are stored in a directory as a sequence of segment files. Each
+// segment contains one JSON-encoded envelope per line (NDJSON) and is named
+// after the ID of the first event it contains, so the files are ordered by
+// name. Once a segment exceeds a certain size, a new one is started. Every
+// write is synced to disk before it is considered complete.
+//
+// An index of all events is kept in memory and extended whenever new records
+// are found in the segments, which also picks up records written by other
+// processes. A lock file serializes writers from different processes.
+//
+// If the process crashes while writing, the last record may be incomplete.
+// Such a torn record is ignored by readers and truncated by the next writer.
+// Batches of records are written at once and each record carries the number
+// of records following it in the same batch. A batch is only indexed when it
+// is complete, so an incomplete batch is treated like a torn record.
+//
+// Consumer checkpoints are kept in a separate JSON file in the directory,
+// which is replaced as a whole whenever a checkpoint changes. Snapshots of
+// consumer state are kept in a subdirectory, one JSON file per consumer.
+
+import (
+	"api-broker-prototype/events"
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// SegmentSize is the size after which a new segment file is started.
+	SegmentSize = 64 * 1024 * 1024
+	// PollInterval is the time between two checks for events written by
+	// other processes.
+	PollInterval = 1 * time.Second
+	// name of the lock file in the log directory
+	lockFileName = "LOCK"
+	// file extension of the segment files
+	segmentExtension = ".ndjson"
+	// name of the file with the consumer checkpoints in the log directory
+	checkpointFileName = "checkpoints.json"
+	// name of the directory with the consumer snapshots in the log directory
+	snapshotDirName = "snapshots"
+	// name of the file with the quarantined events in the log directory
+	quarantineFileName = "quarantine.json"
+)
+
+// fileLogRecord is the type representing the envelope in the log files
+// JSON payloads are embedded as they are, others are stored as base64 in
+// `data`. Records without content type are JSON.
+type fileLogRecord struct {
+	ID            events.EventID    `json:"id"`
+	ExternalUUID  *uuid.UUID        `json:"external_uuid,omitempty"`
+	Created       time.Time         `json:"created"`
+	CausationID   events.EventID    `json:"causation_id"`
+	CorrelationID events.EventID    `json:"correlation_id,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Class         string            `json:"class"`
+	SchemaVersion int               `json:"schema_version"`
+	ContentType   string            `json:"content_type,omitempty"`
+	Payload       json.RawMessage   `json:"payload,omitempty"`
+	Data          []byte            `json:"data,omitempty"`
+	Stream        string            `json:"stream,omitempty"`
+	Version       int32             `json:"version,omitempty"`
+	// number of records following in the same batch
+	Remaining int `json:"remaining,omitempty"`
+}
+
+// fileLogCheckpoint is the type representing a checkpoint in the checkpoint
+// file, which maps the consumer names to these
+type fileLogCheckpoint struct {
+	EventID events.EventID `json:"event_id"`
+	Updated time.Time      `json:"updated"`
+}
+
+// fileLogSnapshot is the type representing a snapshot in its file
+type fileLogSnapshot struct {
+	EventID events.EventID `json:"event_id"`
+	Data    []byte         `json:"data"`
+	Created time.Time      `json:"created"`
+}
+
+// fileLogQuarantined is the type representing a quarantined event in the
+// quarantine file, which maps the event IDs to these
+type fileLogQuarantined struct {
+	Class       string    `json:"class"`
+	Reason      string    `json:"reason"`
+	Quarantined time.Time `json:"quarantined"`
+}
+
+// fileLogEnvelope implements the Envelope interface.
+type fileLogEnvelope struct {
+	IDVal            events.EventID
+	ExternalUUIDVal  uuid.UUID
+	CreatedVal       time.Time
+	CausationIDVal   events.EventID
+	CorrelationIDVal events.EventID
+	MetadataVal      map[string]string
+	StreamVal        string
+	VersionVal       int32
+	EventVal         events.Event
+}
+
+// ID implements the Envelope interface.
+func (env *fileLogEnvelope) ID() events.EventID {
+	return env.IDVal
+}
+
+// Created implements the Envelope interface.
+func (env *fileLogEnvelope) Created() time.Time {
+	return env.CreatedVal
+}
+
+// ExternalUUID implements the Envelope interface.
+func (env *fileLogEnvelope) ExternalUUID() uuid.UUID {
+	return env.ExternalUUIDVal
+}
+
+// CausationID implements the Envelope interface.
+func (env *fileLogEnvelope) CausationID() events.EventID {
+	return env.CausationIDVal
+}
+
+// CorrelationID implements the Envelope interface.
+func (env *fileLogEnvelope) CorrelationID() events.EventID {
+	return env.CorrelationIDVal
+}
+
+// Metadata implements the Envelope interface.
+func (env *fileLogEnvelope) Metadata() map[string]string {
+	return env.MetadataVal
+}
+
+// Stream implements the Envelope interface.
+func (env *fileLogEnvelope) Stream() string {
+	return env.StreamVal
+}
+
+// Version implements the Envelope interface.
+func (env *fileLogEnvelope) Version() int32 {
+	return env.VersionVal
+}
+
+// Event implements the Envelope interface.
+func (env *fileLogEnvelope) Event() events.Event {
+	return env.EventVal
+}
+
+// fileLogNotification implements the Notification interface.
+type fileLogNotification struct {
+	IDVal events.EventID
+}
+
+// ID implements the Notification interface.
+func (note *fileLogNotification) ID() events.EventID {
+	return note.IDVal
+}
+
+// location of a record within the segment files
+type location struct {
+	segment int   // index of the segment
+	offset  int64 // offset of the record in the segment
+}
+
+// FileLogEventStore implements the EventStore interface using log files
+type FileLogEventStore struct {
+	dir         string
+	segmentSize int64
+	codecs      *events.CodecRegistry
+	// mutex guarding the fields below
+	mutex sync.Mutex
+	err   error
+	// names of the segment files, in order
+	segments []string
+	// files opened for reading, indexed like the segments
+	files []*os.File
+	// locations of the records, the ID is the index plus one
+	locations []location
+	// external UUIDs and the ID of the event they belong to
+	uuids map[uuid.UUID]events.EventID
+	// streams and the IDs of their events, ordered by version
+	streams map[string][]events.EventID
+	// IDs of events and of the events directly caused by them, ordered
+	caused map[events.EventID][]events.EventID
+	// offset in the last segment up to which records were indexed
+	tail int64
+	// channel that is closed in order to wake up waiting followers
+	signal chan struct{}
+}
+
+// NewEventStore creates a FileLogEventStore instance.
+// The codecs are used to convert the events for storage. The directory is
+// created if it doesn't exist yet. Any torn record at the end of the log is
+// removed.
+func NewEventStore(dir string, codecs *events.CodecRegistry) (*FileLogEventStore, error) {
+	if dir == "" {
+		return nil, errors.New("empty log directory path")
+	}
+	if codecs == nil {
+		return nil, errors.New("codec registry is nil")
+	}
+
+	s := FileLogEventStore{
+		dir:         dir,
+		segmentSize: SegmentSize,
+		codecs:      codecs,
+		uuids:       make(map[uuid.UUID]events.EventID),
+		streams:     make(map[string][]events.EventID),
+		caused:      make(map[events.EventID][]events.EventID),
+		signal:      make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// build the index and recover from a crash during a previous write
+	err := s.withWriteLock(func() error {
+		return nil
+	})
+	if err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// decode the event of a record
+// Failures are returned as DecodeError.
+func (s *FileLogEventStore) decodeEvent(record *fileLogRecord) (events.Event, error) {
+	payload := []byte(record.Payload)
+	if record.ContentType != "" && record.ContentType != events.ContentTypeJSON {
+		payload = record.Data
+	}
+	return s.codecs.Decode(record.ID, record.Class, record.SchemaVersion, record.ContentType, payload)
+}
+
+// apply the decode policy to an error from loading one of several events
+// This returns true if the event is to be skipped. Otherwise, it sets the
+// error state of the store.
+func (s *FileLogEventStore) skipUndecodable(ctx context.Context, err error) bool {
+	if err := s.codecs.HandleDecodeError(ctx, err, s); err != nil {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if s.err == nil {
+			s.err = err
+		}
+		return false
+	}
+	return true
+}
+
+// run the given function with the lock file held
+func (s *FileLogEventStore) withLockFile(fn func() error) error {
+	lock, err := os.OpenFile(filepath.Join(s.dir, lockFileName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	return fn()
+}
+
+// run the given function with the lock for writing held
+// This acquires the lock file, brings the index up to date and truncates a
+// torn record at the end of the log. This must be called with the mutex
+// locked.
+func (s *FileLogEventStore) withWriteLock(fn func() error) error {
+	return s.withLockFile(func() error {
+		if err := s.refresh(); err != nil {
+			return err
+		}
+
+		// Since no other writer is active, anything after the indexed
+		// records is a torn record left behind by a crash.
+		if len(s.segments) > 0 {
+			last := s.files[len(s.files)-1]
+			info, err := last.Stat()
+			if err != nil {
+				return err
+			}
+			if info.Size() > s.tail {
+				if err := os.Truncate(last.Name(), s.tail); err != nil {
+					return err
+				}
+			}
+		}
+
+		return fn()
+	})
+}
+
+// list the segment files in the log directory, in order
+func (s *FileLogEventStore) listSegments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), segmentExtension) {
+			continue
+		}
+		res = append(res, entry.Name())
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// extend the index with records written since the last call
+// This must be called with the mutex locked.
+func (s *FileLogEventStore) refresh() error {
+	names, err := s.listSegments()
+	if err != nil {
+		return err
+	}
+	if len(names) < len(s.segments) {
+		return errors.New("segment files were removed from the log")
+	}
+
+	// continue with the last known segment, then scan any new ones
+	start := len(s.segments) - 1
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(names); i++ {
+		if i == len(s.segments) {
+			file, err := os.Open(filepath.Join(s.dir, names[i]))
+			if err != nil {
+				return err
+			}
+			s.segments = append(s.segments, names[i])
+			s.files = append(s.files, file)
+			s.tail = 0
+		}
+
+		if err := s.scanSegment(i); err != nil {
+			return err
+		}
+
+		// A torn record can only occur at the end of the last segment.
+		if i != len(names)-1 {
+			info, err := s.files[i].Stat()
+			if err != nil {
+				return err
+			}
+			if info.Size() != s.tail {
+				return fmt.Errorf("segment %s has trailing garbage", names[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// index the complete records in a segment, starting at the tail
+// Records of a batch are only indexed once the whole batch is complete. This
+// must be called with the mutex locked.
+func (s *FileLogEventStore) scanSegment(segment int) error {
+	reader := bufio.NewReader(io.NewSectionReader(s.files[segment], s.tail, 1<<62))
+	offset := s.tail
+	var batch []fileLogRecord
+	var offsets []int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything without a terminating newline is incomplete, and so
+			// is an incomplete batch.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var record fileLogRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("failed to decode record at offset %d in segment %s: %w", offset, s.segments[segment], err)
+		}
+		if int(record.ID) != len(s.locations)+len(batch)+1 {
+			return fmt.Errorf("unexpected ID %d at offset %d in segment %s", record.ID, offset, s.segments[segment])
+		}
+		if len(batch) > 0 && record.Remaining != batch[len(batch)-1].Remaining-1 {
+			return fmt.Errorf("inconsistent batch at offset %d in segment %s", offset, s.segments[segment])
+		}
+
+		batch = append(batch, record)
+		offsets = append(offsets, offset)
+		offset += int64(len(line))
+		if record.Remaining > 0 {
+			continue
+		}
+
+		// the batch is complete, index its records
+		for i, record := range batch {
+			s.locations = append(s.locations, location{segment: segment, offset: offsets[i]})
+			if record.ExternalUUID != nil {
+				s.uuids[*record.ExternalUUID] = record.ID
+			}
+			if record.Stream != "" {
+				if int(record.Version) != len(s.streams[record.Stream])+1 {
+					return fmt.Errorf("unexpected version %d of stream %q in segment %s", record.Version, record.Stream, s.segments[segment])
+				}
+				s.streams[record.Stream] = append(s.streams[record.Stream], record.ID)
+			}
+			if record.CausationID != 0 {
+				s.caused[record.CausationID] = append(s.caused[record.CausationID], record.ID)
+			}
+		}
+		batch = batch[:0]
+		offsets = offsets[:0]
+		s.tail = offset
+	}
+}
+
+// read the record with the given ID
+// This must be called with the mutex locked and a valid ID.
+func (s *FileLogEventStore) readRecord(id events.EventID) (*fileLogRecord, error) {
+	loc := s.locations[id-1]
+	reader := bufio.NewReader(io.NewSectionReader(s.files[loc.segment], loc.offset, 1<<62))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var record fileLogRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// retrieve the envelope with the given ID
+// This returns nil if there is no such event. If the event can't be decoded,
+// this returns a DecodeError without setting the error state.
+func (s *FileLogEventStore) retrieve(id events.EventID) (*fileLogEnvelope, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	if id <= 0 {
+		return nil, nil
+	}
+
+	// look for records written by other processes only when necessary
+	if int(id) > len(s.locations) {
+		if err := s.refresh(); err != nil {
+			s.err = err
+			return nil, err
+		}
+		if int(id) > len(s.locations) {
+			return nil, nil
+		}
+	}
+
+	record, err := s.readRecord(id)
+	if err != nil {
+		s.err = err
+		return nil, err
+	}
+
+	event, err := s.decodeEvent(record)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &fileLogEnvelope{
+		IDVal:            record.ID,
+		CreatedVal:       record.Created,
+		CausationIDVal:   record.CausationID,
+		CorrelationIDVal: record.CorrelationID,
+		MetadataVal:      record.Metadata,
+		StreamVal:        record.Stream,
+		VersionVal:       record.Version,
+		EventVal:         event,
+	}
+	if record.ExternalUUID != nil {
+		res.ExternalUUIDVal = *record.ExternalUUID
+	}
+	return res, nil
+}
+
+// retrieve the channel that is closed on the next insert
+func (s *FileLogEventStore) waitChannel() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.signal
+}
+
+// wait until new events may be available
+// This returns false when the context is cancelled or the store was closed.
+func (s *FileLogEventStore) wait(ctx context.Context, signal <-chan struct{}) bool {
+	timer := time.NewTimer(PollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-signal:
+	case <-timer.C:
+	}
+	return s.Error() == nil
+}
+
+// close all files opened for reading
+// This must be called with the mutex locked.
+func (s *FileLogEventStore) closeFiles() {
+	for _, file := range s.files {
+		file.Close()
+	}
+	s.files = nil
+	s.segments = nil
+}
+
+// ParseEventID implements the EventStore interface.
+func (s *FileLogEventStore) ParseEventID(str string) (events.EventID, error) {
+	lp, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return events.EventID(lp), nil
+}
+
+// Error implements the EventStore interface.
+func (s *FileLogEventStore) Error() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err
+}
+
+// Close implements the EventStore and io.Closer interfaces.
+// Note that this must correctly release any resources even with the error state set!
+func (s *FileLogEventStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closeFiles()
+	s.locations = nil
+	s.uuids = nil
+	s.streams = nil
+	s.caused = nil
+
+	// set this error to block any further calls
+	s.err = errors.New("eventstore is closed")
+
+	// wake up any followers so they can terminate
+	close(s.signal)
+	s.signal = make(chan struct{})
+
+	return nil
+}
+
+// Insert implements the EventStore interface.
+func (s *FileLogEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID events.EventID) (events.Envelope, error) {
+	res, err := s.InsertMany(ctx, []events.NewEvent{{ExternalUUID: externalUUID, Event: event, CausationID: causationID}})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// InsertMany implements the EventStore interface.
+func (s *FileLogEventStore) InsertMany(ctx context.Context, newEvents []events.NewEvent) ([]events.Envelope, error) {
+	// encode events for storage
+	classes := make([]string, len(newEvents))
+	schemaVersions := make([]int, len(newEvents))
+	contentTypes := make([]string, len(newEvents))
+	payloads := make([][]byte, len(newEvents))
+	for i, newEvent := range newEvents {
+		classes[i] = newEvent.Event.Class()
+		payload, contentType, schemaVersion, err := s.codecs.Encode(newEvent.Event)
+		if err != nil {
+			return nil, err
+		}
+		schemaVersions[i] = schemaVersion
+		contentTypes[i] = contentType
+		payloads[i] = payload
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+	if len(newEvents) == 0 {
+		return nil, nil
+	}
+
+	created := time.Now()
+	res := make([]events.Envelope, 0, len(newEvents))
+
+	err := s.withWriteLock(func() error {
+		// check that the external UUIDs are unique and the stream versions
+		// match, before writing anything
+		batch := make(map[uuid.UUID]bool)
+		streams := make(map[string]int32)
+		versions := make([]int32, len(newEvents))
+		for i, newEvent := range newEvents {
+			if newEvent.Stream != "" {
+				version, ok := streams[newEvent.Stream]
+				if !ok {
+					version = int32(len(s.streams[newEvent.Stream]))
+				}
+				if err := events.CheckVersion(newEvent.Stream, newEvent.ExpectedVersion, version); err != nil {
+					return err
+				}
+				versions[i] = version + 1
+				streams[newEvent.Stream] = versions[i]
+			}
+
+			if newEvent.ExternalUUID == uuid.Nil {
+				continue
+			}
+			if _, ok := s.uuids[newEvent.ExternalUUID]; ok || batch[newEvent.ExternalUUID] {
+				return events.DuplicateEventUUID
+			}
+			batch[newEvent.ExternalUUID] = true
+		}
+
+		// encode all records, so they can be written at once
+		firstID := events.EventID(len(s.locations) + 1)
+		var lines []byte
+		for i, newEvent := range newEvents {
+			env := &fileLogEnvelope{
+				IDVal:            firstID + events.EventID(i),
+				ExternalUUIDVal:  newEvent.ExternalUUID,
+				CreatedVal:       created,
+				CausationIDVal:   newEvent.CausationID,
+				CorrelationIDVal: newEvent.CorrelationID,
+				MetadataVal:      maps.Clone(newEvent.Metadata),
+				StreamVal:        newEvent.Stream,
+				VersionVal:       versions[i],
+				EventVal:         newEvent.Event,
+			}
+			record := fileLogRecord{
+				ID:            env.IDVal,
+				Created:       created,
+				CausationID:   newEvent.CausationID,
+				CorrelationID: newEvent.CorrelationID,
+				Metadata:      newEvent.Metadata,
+				Class:         classes[i],
+				SchemaVersion: schemaVersions[i],
+				ContentType:   contentTypes[i],
+				Stream:        newEvent.Stream,
+				Version:       versions[i],
+				Remaining:     len(newEvents) - i - 1,
+			}
+			if contentTypes[i] == events.ContentTypeJSON {
+				record.Payload = payloads[i]
+			} else {
+				record.Data = payloads[i]
+			}
+			if newEvent.ExternalUUID != uuid.Nil {
+				externalUUID := newEvent.ExternalUUID
+				record.ExternalUUID = &externalUUID
+			}
+
+			line, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line...)
+			lines = append(lines, '\n')
+			res = append(res, env)
+		}
+
+		return s.appendRecords(firstID, lines)
+	})
+	if err != nil {
+		if !errors.Is(err, events.DuplicateEventUUID) && !errors.Is(err, events.VersionConflict) {
+			s.err = err
+		}
+		return nil, err
+	}
+
+	// wake up followers in this process
+	close(s.signal)
+	s.signal = make(chan struct{})
+
+	return res, nil
+}
+
+// append encoded records to the log
+// This starts a new segment if necessary, but all records are written to the
+// same segment. The records are synced to disk and added to the index. This
+// must be called with the mutex and the write lock held.
+func (s *FileLogEventStore) appendRecords(firstID events.EventID, lines []byte) error {
+	// start a new segment if the current one is full
+	if len(s.segments) == 0 || s.tail >= s.segmentSize {
+		name := fmt.Sprintf("%020d%s", firstID, segmentExtension)
+		file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_EXCL|os.O_RDONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		if err := syncDir(s.dir); err != nil {
+			file.Close()
+			return err
+		}
+		s.segments = append(s.segments, name)
+		s.files = append(s.files, file)
+		s.tail = 0
+	}
+
+	segment := len(s.segments) - 1
+	file, err := os.OpenFile(filepath.Join(s.dir, s.segments[segment]), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(lines); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	// index the new records
+	return s.scanSegment(segment)
+}
+
+// sync a directory in order to persist the creation of files in it
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// ResolveUUID implements the EventStore interface.
+func (s *FileLogEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (events.EventID, error) {
+	if externalUUID == uuid.Nil {
+		return 0, errors.New("provided external UUID is null")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return 0, s.err
+	}
+	if err := s.refresh(); err != nil {
+		s.err = err
+		return 0, err
+	}
+
+	id, ok := s.uuids[externalUUID]
+	if !ok {
+		return 0, errors.New("document not found")
+	}
+	return id, nil
+}
+
+// RetrieveOne implements the EventStore interface.
+func (s *FileLogEventStore) RetrieveOne(ctx context.Context, id events.EventID) (events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	res, err := s.retrieve(id)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, errors.New("document not found")
+	}
+	return res, nil
+}
+
+// LoadCaused implements the CausationLoader interface.
+func (s *FileLogEventStore) LoadCaused(ctx context.Context, id events.EventID) (<-chan events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	// pick up records written by other processes
+	if err := s.refresh(); err != nil {
+		s.err = err
+		return nil, err
+	}
+
+	// collect the IDs of the tree level by level, so they can be used
+	// without holding the mutex, then restore their order
+	var ids []events.EventID
+	for level := s.caused[id]; len(level) > 0; {
+		ids = append(ids, level...)
+		var next []events.EventID
+		for _, id := range level {
+			next = append(next, s.caused[id]...)
+		}
+		level = next
+	}
+	slices.Sort(ids)
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for _, id := range ids {
+			envelope, err := s.retrieve(id)
+			if err != nil {
+				if s.skipUndecodable(ctx, err) {
+					continue
+				}
+				return
+			}
+			if envelope == nil {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadStream implements the StreamLoader interface.
+func (s *FileLogEventStore) LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan events.Envelope, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	// pick up records written by other processes
+	if err := s.refresh(); err != nil {
+		s.err = err
+		return nil, err
+	}
+
+	// copy the IDs, so they can be used without holding the mutex
+	var ids []events.EventID
+	if fromVersion < 1 {
+		fromVersion = 1
+	}
+	if all := s.streams[stream]; int(fromVersion) <= len(all) {
+		ids = append(ids, all[fromVersion-1:]...)
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for _, id := range ids {
+			envelope, err := s.retrieve(id)
+			if err != nil {
+				if s.skipUndecodable(ctx, err) {
+					continue
+				}
+				return
+			}
+			if envelope == nil {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadEvents implements the EventStore interface.
+func (s *FileLogEventStore) LoadEvents(ctx context.Context, bounds events.Range, filter events.Filter) (<-chan events.Envelope, error) {
+	// determine the current end of the log, which is where reading backward
+	// starts and forward reading ends
+	s.mutex.Lock()
+	if s.err != nil {
+		s.mutex.Unlock()
+		return nil, s.err
+	}
+	if err := s.refresh(); err != nil {
+		s.err = err
+		s.mutex.Unlock()
+		return nil, err
+	}
+	first := max(bounds.StartAfter, 0) + 1
+	last := events.EventID(len(s.locations))
+	s.mutex.Unlock()
+	if bounds.StartBefore != 0 && bounds.StartBefore-1 < last {
+		last = bounds.StartBefore - 1
+	}
+
+	// step through the IDs in the requested order
+	id, step := first, events.EventID(1)
+	if bounds.Backward {
+		id, step = last, -1
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		// pump events
+		count := 0
+		for ; id >= first && id <= last; id += step {
+			if bounds.Limit > 0 && count >= bounds.Limit {
+				return
+			}
+
+			// retrieve envelope
+			envelope, err := s.retrieve(id)
+			if err != nil {
+				if s.skipUndecodable(ctx, err) {
+					continue
+				}
+				return
+			}
+			if envelope == nil {
+				return
+			}
+
+			// skip events not selected by the filter
+			if !filter.Matches(envelope) {
+				continue
+			}
+
+			// emit envelope
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+				count++
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// FollowNotifications implements the EventStore interface.
+func (s *FileLogEventStore) FollowNotifications(ctx context.Context) (<-chan events.Notification, error) {
+	// determine the current end of the stream, notifications are only
+	// emitted for events inserted afterwards
+	s.mutex.Lock()
+	if s.err != nil {
+		s.mutex.Unlock()
+		return nil, s.err
+	}
+	if err := s.refresh(); err != nil {
+		s.err = err
+		s.mutex.Unlock()
+		return nil, err
+	}
+	id := events.EventID(len(s.locations))
+	s.mutex.Unlock()
+
+	out := make(chan events.Notification)
+
+	// run code to pump notifications in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for {
+			// get the signal channel before reading, so that no insert is
+			// missed in between
+			signal := s.waitChannel()
+
+			s.mutex.Lock()
+			if s.err == nil {
+				if err := s.refresh(); err != nil {
+					s.err = err
+				}
+			}
+			err := s.err
+			last := events.EventID(len(s.locations))
+			s.mutex.Unlock()
+			if err != nil {
+				return
+			}
+
+			// emit notifications for new events
+			for id < last {
+				id++
+				select {
+				case <-ctx.Done():
+					return
+				case out <- &fileLogNotification{IDVal: id}:
+				}
+			}
+
+			// wait for new events
+			if !s.wait(ctx, signal) {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// FollowEvents implements the EventStore interface.
+func (s *FileLogEventStore) FollowEvents(ctx context.Context, startAfter events.EventID, filter events.Filter) (<-chan events.Envelope, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.Error(); err != nil {
+		return nil, err
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		// pump events
+		id := startAfter
+		for {
+			// get the signal channel before reading, so that no insert is
+			// missed in between
+			signal := s.waitChannel()
+
+			// retrieve next envelope
+			envelope, err := s.retrieve(id + 1)
+			if err != nil {
+				if s.skipUndecodable(ctx, err) {
+					// move past the skipped event
+					id++
+					continue
+				}
+				return
+			}
+			if envelope == nil {
+				// no more events after "id"
+				// When this happens, we just wait for new events to be
+				// written, either by this or by another process.
+				if !s.wait(ctx, signal) {
+					return
+				}
+				continue
+			}
+
+			// move to next element
+			id = envelope.IDVal
+
+			// skip events not selected by the filter
+			if !filter.Matches(envelope) {
+				continue
+			}
+
+			// emit envelope
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// read the JSON file with the given name in the log directory into the map
+// A missing file leaves the map empty.
+func (s *FileLogEventStore) readMapFile(name string, res any) error {
+	content, err := os.ReadFile(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, res)
+}
+
+// modify the JSON file with the given name in the log directory
+// The file is read into the map, which the given function then modifies.
+// The file is replaced atomically. The lock file serializes this with other
+// processes, so that their modifications aren't lost.
+func (s *FileLogEventStore) updateMapFile(name string, res any, fn func()) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return s.err
+	}
+
+	return s.withLockFile(func() error {
+		if err := s.readMapFile(name, res); err != nil {
+			return err
+		}
+		fn()
+		content, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+
+		return replaceFile(filepath.Join(s.dir, name), content)
+	})
+}
+
+// read all checkpoints from the checkpoint file
+// A missing file means that there are no checkpoints yet.
+func (s *FileLogEventStore) readCheckpoints() (map[string]fileLogCheckpoint, error) {
+	res := make(map[string]fileLogCheckpoint)
+	if err := s.readMapFile(checkpointFileName, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// modify the checkpoints using the given function
+func (s *FileLogEventStore) updateCheckpoints(fn func(checkpoints map[string]fileLogCheckpoint)) error {
+	checkpoints := make(map[string]fileLogCheckpoint)
+	return s.updateMapFile(checkpointFileName, &checkpoints, func() {
+		fn(checkpoints)
+	})
+}
+
+// replace the content of a file atomically
+// This writes a temporary file and moves it into place, so that readers
+// never see a partially written file.
+func replaceFile(name string, content []byte) error {
+	dir := filepath.Dir(name)
+	file, err := os.CreateTemp(dir, filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// LoadCheckpoint implements the CheckpointStore interface.
+func (s *FileLogEventStore) LoadCheckpoint(ctx context.Context, consumer string) (events.EventID, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.Error(); err != nil {
+		return 0, err
+	}
+
+	checkpoints, err := s.readCheckpoints()
+	if err != nil {
+		return 0, err
+	}
+	return checkpoints[consumer].EventID, nil
+}
+
+// SaveCheckpoint implements the CheckpointStore interface.
+func (s *FileLogEventStore) SaveCheckpoint(ctx context.Context, consumer string, id events.EventID) error {
+	return s.updateCheckpoints(func(checkpoints map[string]fileLogCheckpoint) {
+		checkpoints[consumer] = fileLogCheckpoint{EventID: id, Updated: time.Now()}
+	})
+}
+
+// ListCheckpoints implements the CheckpointStore interface.
+func (s *FileLogEventStore) ListCheckpoints(ctx context.Context) ([]events.Checkpoint, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.Error(); err != nil {
+		return nil, err
+	}
+
+	checkpoints, err := s.readCheckpoints()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]events.Checkpoint, 0, len(checkpoints))
+	for consumer, checkpoint := range checkpoints {
+		res = append(res, events.Checkpoint{Consumer: consumer, EventID: checkpoint.EventID, Updated: checkpoint.Updated})
+	}
+	slices.SortFunc(res, func(a, b events.Checkpoint) int {
+		return strings.Compare(a.Consumer, b.Consumer)
+	})
+	return res, nil
+}
+
+// ResetCheckpoint implements the CheckpointStore interface.
+func (s *FileLogEventStore) ResetCheckpoint(ctx context.Context, consumer string) error {
+	return s.updateCheckpoints(func(checkpoints map[string]fileLogCheckpoint) {
+		delete(checkpoints, consumer)
+	})
+}
+
+// name of the file with the snapshot of the consumer
+// The consumer name is escaped, so that it can't refer to other files.
+func (s *FileLogEventStore) snapshotFile(consumer string) string {
+	return filepath.Join(s.dir, snapshotDirName, url.PathEscape(consumer)+".json")
+}
+
+// LoadSnapshot implements the SnapshotStore interface.
+func (s *FileLogEventStore) LoadSnapshot(ctx context.Context, consumer string) (*events.Snapshot, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.Error(); err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(s.snapshotFile(consumer))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot fileLogSnapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, err
+	}
+	return &events.Snapshot{
+		Consumer: consumer,
+		EventID:  snapshot.EventID,
+		Data:     snapshot.Data,
+		Created:  snapshot.Created,
+	}, nil
+}
+
+// SaveSnapshot implements the SnapshotStore interface.
+func (s *FileLogEventStore) SaveSnapshot(ctx context.Context, consumer string, id events.EventID, data []byte) error {
+	// don't do anything if the error state of the store is set already
+	if err := s.Error(); err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(fileLogSnapshot{EventID: id, Data: data, Created: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, snapshotDirName), 0o755); err != nil {
+		return err
+	}
+	return replaceFile(s.snapshotFile(consumer), content)
+}
+
+// QuarantineEvent implements the Quarantine interface.
+func (s *FileLogEventStore) QuarantineEvent(ctx context.Context, id events.EventID, class string, reason string) error {
+	quarantine := make(map[events.EventID]fileLogQuarantined)
+	return s.updateMapFile(quarantineFileName, &quarantine, func() {
+		quarantine[id] = fileLogQuarantined{Class: class, Reason: reason, Quarantined: time.Now()}
+	})
+}
+
+// ListQuarantined implements the Quarantine interface.
+func (s *FileLogEventStore) ListQuarantined(ctx context.Context) ([]events.QuarantinedEvent, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.Error(); err != nil {
+		return nil, err
+	}
+
+	quarantine := make(map[events.EventID]fileLogQuarantined)
+	if err := s.readMapFile(quarantineFileName, &quarantine); err != nil {
+		return nil, err
+	}
+	res := make([]events.QuarantinedEvent, 0, len(quarantine))
+	for id, event := range quarantine {
+		res = append(res, events.QuarantinedEvent{EventID: id, Class: event.Class, Reason: event.Reason, Quarantined: event.Quarantined})
+	}
+	slices.SortFunc(res, func(a, b events.QuarantinedEvent) int {
+		return cmp.Compare(a.EventID, b.EventID)
+	})
+	return res, nil
+}
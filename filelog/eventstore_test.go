@@ -0,0 +1,394 @@
+package filelog
+
+import (
+	"api-broker-prototype/broker"
+	"api-broker-prototype/events"
+	"api-broker-prototype/events/storetest"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestEnvelope(t *testing.T) {
+	var _ events.Envelope = &fileLogEnvelope{}
+}
+
+func TestNotification(t *testing.T) {
+	var _ events.Notification = &fileLogNotification{}
+}
+
+func TestEventstore(t *testing.T) {
+	var _ events.EventStore = &FileLogEventStore{}
+}
+
+func TestStreamLoader(t *testing.T) {
+	var _ events.StreamLoader = &FileLogEventStore{}
+}
+
+func TestCausationLoader(t *testing.T) {
+	var _ events.CausationLoader = &FileLogEventStore{}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	var _ events.CheckpointStore = &FileLogEventStore{}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	var _ events.SnapshotStore = &FileLogEventStore{}
+}
+
+func TestQuarantine(t *testing.T) {
+	var _ events.Quarantine = &FileLogEventStore{}
+}
+
+// create a store in the given directory
+func createStore(t *testing.T, dir string) *FileLogEventStore {
+	return createStoreWithCodecs(t, dir, storetest.Codecs(t))
+}
+
+// create a store in the given directory using the given codecs
+func createStoreWithCodecs(t *testing.T, dir string, codecs *events.CodecRegistry) *FileLogEventStore {
+	store, err := NewEventStore(dir, codecs)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) events.EventStore {
+		return createStore(t, t.TempDir())
+	})
+}
+
+func TestDecodePolicy(t *testing.T) {
+	storetest.RunDecodePolicy(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, t.TempDir(), codecs)
+	})
+}
+
+func TestEncodings(t *testing.T) {
+	storetest.RunEncodings(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, t.TempDir(), codecs)
+	})
+}
+
+// load all events from the store
+func loadAll(t *testing.T, store events.EventStore) []events.Envelope {
+	ch, err := store.LoadEvents(context.Background(), events.Range{}, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var res []events.Envelope
+	for env := range ch {
+		res = append(res, env)
+	}
+	if err := store.Error(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return res
+}
+
+func TestInsert(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := createStore(t, dir)
+
+	externalUUID := uuid.FromStringOrNil("22428f46-a2d8-4d51-b6b5-bc8551bd0921")
+
+	first, err := store.Insert(ctx, externalUUID, broker.RequestEvent{Request: "first"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if first.ID() != 1 {
+		t.Errorf("unexpected ID %d", first.ID())
+	}
+
+	second, err := store.Insert(ctx, uuid.Nil, broker.APIRequestEvent{Attempt: 1}, first.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if second.ID() != 2 {
+		t.Errorf("unexpected ID %d", second.ID())
+	}
+
+	// a second insert with the same UUID fails
+	if _, err := store.Insert(ctx, externalUUID, events.SimpleEvent{}, 0); !errors.Is(err, events.DuplicateEventUUID) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// the data is available from a second instance
+	other := createStore(t, dir)
+
+	id, err := other.ResolveUUID(ctx, externalUUID)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if id != first.ID() {
+		t.Errorf("unexpected ID %d", id)
+	}
+
+	env, err := other.RetrieveOne(ctx, second.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env.CausationID() != first.ID() {
+		t.Errorf("unexpected causation ID %d", env.CausationID())
+	}
+	if env.Event() != (broker.APIRequestEvent{Attempt: 1}) {
+		t.Errorf("unexpected event %v", env.Event())
+	}
+
+	if _, err := other.RetrieveOne(ctx, 42); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := createStore(t, dir)
+	store.segmentSize = 1
+
+	for i := 0; i != 3; i++ {
+		if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	segments, err := store.listSegments()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(segments) != 3 {
+		t.Errorf("unexpected segments %v", segments)
+	}
+
+	envelopes := loadAll(t, createStore(t, dir))
+	if len(envelopes) != 3 {
+		t.Errorf("unexpected number of events %d", len(envelopes))
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := createStore(t, dir)
+
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{Message: "complete"}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store.Close()
+
+	// simulate a crash while writing the second record
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	file.WriteString(`{"id":2,"created":"2024-`)
+	file.Close()
+
+	// the torn record is dropped and its ID reused
+	store = createStore(t, dir)
+	if envelopes := loadAll(t, store); len(envelopes) != 1 {
+		t.Errorf("unexpected number of events %d", len(envelopes))
+	}
+	env, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{Message: "next"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env.ID() != 2 {
+		t.Errorf("unexpected ID %d", env.ID())
+	}
+	if envelopes := loadAll(t, createStore(t, dir)); len(envelopes) != 2 {
+		t.Errorf("unexpected number of events %d", len(envelopes))
+	}
+}
+
+func TestIncompleteBatch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := createStore(t, dir)
+
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{Message: "complete"}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store.Close()
+
+	// simulate a crash after writing the first of two records of a batch
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	file.WriteString(`{"id":2,"created":"2024-01-01T00:00:00Z","causation_id":0,"class":"simple","payload":{"message":"first"},"remaining":1}` + "\n")
+	file.Close()
+
+	// the incomplete batch is dropped and its IDs reused
+	store = createStore(t, dir)
+	if envelopes := loadAll(t, store); len(envelopes) != 1 {
+		t.Errorf("unexpected number of events %d", len(envelopes))
+	}
+	envs, err := store.InsertMany(ctx, []events.NewEvent{{Event: events.SimpleEvent{}}, {Event: events.SimpleEvent{}}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if envs[0].ID() != 2 || envs[1].ID() != 3 {
+		t.Errorf("unexpected IDs %d, %d", envs[0].ID(), envs[1].ID())
+	}
+	if envelopes := loadAll(t, createStore(t, dir)); len(envelopes) != 3 {
+		t.Errorf("unexpected number of events %d", len(envelopes))
+	}
+}
+
+func TestFollowEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	store := createStore(t, dir)
+
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ch, err := store.FollowEvents(ctx, 0, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	notifications, err := store.FollowNotifications(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// existing events are emitted first
+	env := <-ch
+	if env == nil || env.ID() != 1 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+
+	// new events wake up the followers
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env = <-ch
+	if env == nil || env.ID() != 2 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+	note := <-notifications
+	if note == nil || note.ID() != 2 {
+		t.Fatalf("unexpected notification %v", note)
+	}
+
+	// inserts from a second instance are picked up by polling
+	other := createStore(t, dir)
+	if _, err := other.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env = <-ch
+	if env == nil || env.ID() != 3 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+}
+
+func TestMetadataReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := createStore(t, dir)
+
+	_, err := store.InsertMany(ctx, []events.NewEvent{
+		{Event: events.SimpleEvent{}, CorrelationID: 7, Metadata: map[string]string{"actor": "alice"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the metadata is read back from the file by a second instance
+	envelopes := loadAll(t, createStore(t, dir))
+	if len(envelopes) != 1 {
+		t.Fatalf("unexpected number of events %d", len(envelopes))
+	}
+	if envelopes[0].CorrelationID() != 7 || envelopes[0].Metadata()["actor"] != "alice" {
+		t.Errorf("unexpected correlation ID %d and metadata %v", envelopes[0].CorrelationID(), envelopes[0].Metadata())
+	}
+}
+
+// upcaster renaming the field of simple events from "text" to "message"
+type simpleEventUpcaster struct{}
+
+func (upcaster *simpleEventUpcaster) Class() string {
+	return "simple"
+}
+
+func (upcaster *simpleEventUpcaster) Version() int {
+	return 1
+}
+
+func (upcaster *simpleEventUpcaster) Upcast(data events.Payload) (events.Payload, error) {
+	return events.Payload{"message": data["text"]}, nil
+}
+
+func TestUpcast(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	codecs := storetest.Codecs(t)
+	if err := codecs.RegisterUpcaster(&simpleEventUpcaster{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store, err := NewEventStore(dir, codecs)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	// new events are stored with the current schema version
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{Message: "current"}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store.Close()
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExtension))
+	content, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !strings.Contains(string(content), `"schema_version":2`) {
+		t.Errorf("unexpected record %s", content)
+	}
+
+	// add a record from before schema versions were recorded and one of an
+	// unknown schema version
+	file, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	file.WriteString(`{"id":2,"created":"2024-01-01T00:00:00Z","causation_id":0,"class":"simple","payload":{"text":"old"}}` + "\n")
+	file.WriteString(`{"id":3,"created":"2024-01-01T00:00:00Z","causation_id":0,"class":"simple","schema_version":3,"payload":{}}` + "\n")
+	file.Close()
+
+	// events of an older schema version are converted on loading
+	store, err = NewEventStore(dir, codecs)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+	for id, message := range map[events.EventID]string{1: "current", 2: "old"} {
+		env, err := store.RetrieveOne(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if event := env.Event().(events.SimpleEvent); event.Message != message {
+			t.Errorf("unexpected event %v", event)
+		}
+	}
+
+	// events of an unknown schema version can't be loaded
+	if _, err := store.RetrieveOne(ctx, 3); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix && !windows
+
+package filelog
+
+// This file is a fallback for systems without support for file locks.
+// Without a lock, two processes could append to the same segment and corrupt
+// the log, so the log can't be opened on these systems at all.
+
+import (
+	"errors"
+	"os"
+)
+
+// lock the given file exclusively
+func lockFile(f *os.File) error {
+	return errors.New("file locks are not supported on this system")
+}
+
+// release the lock acquired with lockFile()
+func unlockFile(f *os.File) error {
+	return nil
+}
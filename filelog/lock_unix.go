@@ -0,0 +1,20 @@
+//go:build unix
+
+package filelog
+
+// This file implements locking of the log for Unix-like systems.
+
+import (
+	"os"
+	"syscall"
+)
+
+// lock the given file exclusively, blocking until the lock is available
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// release the lock acquired with lockFile()
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package filelog
+
+// This file implements locking of the log for Windows.
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lock the given file exclusively, blocking until the lock is available
+func lockFile(f *os.File) error {
+	var overlapped windows.Overlapped
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &overlapped)
+}
+
+// release the lock acquired with lockFile()
+func unlockFile(f *os.File) error {
+	var overlapped windows.Overlapped
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &overlapped)
+}
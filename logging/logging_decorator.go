@@ -34,7 +34,7 @@ func NewLoggingDecorator(eventstore events.EventStore, logger log15.Logger) (*Lo
 	return res, nil
 }
 
-func (s *LoggingDecoratorEventStore) ParseEventID(str string) (int32, error) {
+func (s *LoggingDecoratorEventStore) ParseEventID(str string) (events.EventID, error) {
 	return s.eventstore.ParseEventID(str)
 }
 
@@ -46,7 +46,7 @@ func (s *LoggingDecoratorEventStore) Close() error {
 	return s.eventstore.Close()
 }
 
-func (s *LoggingDecoratorEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID int32) (events.Envelope, error) {
+func (s *LoggingDecoratorEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID events.EventID) (events.Envelope, error) {
 	s.logger.Debug("Inserting event.", "external_id", externalUUID, "class", event.Class(), "causation_id", causationID)
 	env, err := s.eventstore.Insert(ctx, externalUUID, event, causationID)
 	if err == nil {
@@ -57,11 +57,24 @@ func (s *LoggingDecoratorEventStore) Insert(ctx context.Context, externalUUID uu
 	return env, err
 }
 
-func (s *LoggingDecoratorEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (int32, error) {
+func (s *LoggingDecoratorEventStore) InsertMany(ctx context.Context, newEvents []events.NewEvent) ([]events.Envelope, error) {
+	s.logger.Debug("Inserting events.", "count", len(newEvents))
+	envs, err := s.eventstore.InsertMany(ctx, newEvents)
+	if err == nil {
+		for _, env := range envs {
+			s.logger.Debug("Inserted event.", "id", env.ID(), "external_id", env.ExternalUUID(), "class", env.Event().Class(), "causation_id", env.CausationID(), "correlation_id", env.CorrelationID(), "stream", env.Stream(), "version", env.Version())
+		}
+	} else {
+		s.logger.Debug("Failed to insert events.", "error", err)
+	}
+	return envs, err
+}
+
+func (s *LoggingDecoratorEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (events.EventID, error) {
 	return s.eventstore.ResolveUUID(ctx, externalUUID)
 }
 
-func (s *LoggingDecoratorEventStore) RetrieveOne(ctx context.Context, id int32) (events.Envelope, error) {
+func (s *LoggingDecoratorEventStore) RetrieveOne(ctx context.Context, id events.EventID) (events.Envelope, error) {
 	s.logger.Debug("Loading event.", "id", id)
 	env, err := s.eventstore.RetrieveOne(ctx, id)
 	if err == nil {
@@ -72,9 +85,9 @@ func (s *LoggingDecoratorEventStore) RetrieveOne(ctx context.Context, id int32)
 	return env, err
 }
 
-func (s *LoggingDecoratorEventStore) LoadEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
-	s.logger.Debug("Loading events.", "startAfter", startAfter)
-	stream, err := s.eventstore.LoadEvents(ctx, startAfter)
+func (s *LoggingDecoratorEventStore) LoadEvents(ctx context.Context, bounds events.Range, filter events.Filter) (<-chan events.Envelope, error) {
+	s.logger.Debug("Loading events.", "range", bounds, "filter", filter)
+	stream, err := s.eventstore.LoadEvents(ctx, bounds, filter)
 	if err != nil {
 		s.logger.Debug("Failed to load events.", "error", err)
 		return stream, err
@@ -102,6 +115,79 @@ func (s *LoggingDecoratorEventStore) LoadEvents(ctx context.Context, startAfter
 	return res, nil
 }
 
+// LoadCaused implements the CausationLoader interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) LoadCaused(ctx context.Context, id events.EventID) (<-chan events.Envelope, error) {
+	loader, ok := s.eventstore.(events.CausationLoader)
+	if !ok {
+		return nil, events.CausationNotSupported
+	}
+
+	s.logger.Debug("Loading caused events.", "id", id)
+	envs, err := loader.LoadCaused(ctx, id)
+	if err != nil {
+		s.logger.Debug("Failed to load caused events.", "error", err)
+		return envs, err
+	}
+	s.logger.Debug("Loaded caused events.")
+
+	// create intermediate stream to intercept and log the events loaded
+	res := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(res)
+
+		for env := range envs {
+			s.logger.Debug(
+				"Loaded event.",
+				"id", env.ID(),
+				"class", env.Event().Class(),
+				"causation_id", env.CausationID(),
+				"created", env.Created(),
+			)
+			res <- env
+		}
+	}()
+
+	return res, nil
+}
+
+// LoadStream implements the StreamLoader interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan events.Envelope, error) {
+	loader, ok := s.eventstore.(events.StreamLoader)
+	if !ok {
+		return nil, events.StreamsNotSupported
+	}
+
+	s.logger.Debug("Loading stream.", "stream", stream, "fromVersion", fromVersion)
+	envs, err := loader.LoadStream(ctx, stream, fromVersion)
+	if err != nil {
+		s.logger.Debug("Failed to load stream.", "error", err)
+		return envs, err
+	}
+	s.logger.Debug("Loaded stream.")
+
+	// create intermediate stream to intercept and log the events loaded
+	res := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(res)
+
+		for env := range envs {
+			s.logger.Debug(
+				"Loaded event.",
+				"id", env.ID(),
+				"version", env.Version(),
+				"class", env.Event().Class(),
+				"causation_id", env.CausationID(),
+				"created", env.Created(),
+			)
+			res <- env
+		}
+	}()
+
+	return res, nil
+}
+
 func (s *LoggingDecoratorEventStore) FollowNotifications(ctx context.Context) (<-chan events.Notification, error) {
 	s.logger.Debug("Loading notification stream.")
 	stream, err := s.eventstore.FollowNotifications(ctx)
@@ -129,9 +215,9 @@ func (s *LoggingDecoratorEventStore) FollowNotifications(ctx context.Context) (<
 	return res, nil
 }
 
-func (s *LoggingDecoratorEventStore) FollowEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
-	s.logger.Debug("Loading event stream.", "startAfter", startAfter)
-	stream, err := s.eventstore.FollowEvents(ctx, startAfter)
+func (s *LoggingDecoratorEventStore) FollowEvents(ctx context.Context, startAfter events.EventID, filter events.Filter) (<-chan events.Envelope, error) {
+	s.logger.Debug("Loading event stream.", "startAfter", startAfter, "filter", filter)
+	stream, err := s.eventstore.FollowEvents(ctx, startAfter, filter)
 	if err != nil {
 		s.logger.Debug("Failed to load event stream.", "error", err)
 		return stream, err
@@ -159,3 +245,158 @@ func (s *LoggingDecoratorEventStore) FollowEvents(ctx context.Context, startAfte
 
 	return res, nil
 }
+
+// Migrate implements the Migrator interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) Migrate(ctx context.Context) (int, error) {
+	migrator, ok := s.eventstore.(events.Migrator)
+	if !ok {
+		return 0, events.MigrationsNotSupported
+	}
+
+	s.logger.Debug("Migrating schema.")
+	version, err := migrator.Migrate(ctx)
+	if err == nil {
+		s.logger.Debug("Migrated schema.", "version", version)
+	} else {
+		s.logger.Debug("Failed to migrate schema.", "version", version, "error", err)
+	}
+	return version, err
+}
+
+// LoadCheckpoint implements the CheckpointStore interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) LoadCheckpoint(ctx context.Context, consumer string) (events.EventID, error) {
+	checkpoints, ok := s.eventstore.(events.CheckpointStore)
+	if !ok {
+		return 0, events.CheckpointsNotSupported
+	}
+
+	s.logger.Debug("Loading checkpoint.", "consumer", consumer)
+	id, err := checkpoints.LoadCheckpoint(ctx, consumer)
+	if err == nil {
+		s.logger.Debug("Loaded checkpoint.", "consumer", consumer, "id", id)
+	} else {
+		s.logger.Debug("Failed to load checkpoint.", "consumer", consumer, "error", err)
+	}
+	return id, err
+}
+
+// SaveCheckpoint implements the CheckpointStore interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) SaveCheckpoint(ctx context.Context, consumer string, id events.EventID) error {
+	checkpoints, ok := s.eventstore.(events.CheckpointStore)
+	if !ok {
+		return events.CheckpointsNotSupported
+	}
+
+	s.logger.Debug("Saving checkpoint.", "consumer", consumer, "id", id)
+	err := checkpoints.SaveCheckpoint(ctx, consumer, id)
+	if err == nil {
+		s.logger.Debug("Saved checkpoint.", "consumer", consumer, "id", id)
+	} else {
+		s.logger.Debug("Failed to save checkpoint.", "consumer", consumer, "id", id, "error", err)
+	}
+	return err
+}
+
+// ListCheckpoints implements the CheckpointStore interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) ListCheckpoints(ctx context.Context) ([]events.Checkpoint, error) {
+	checkpoints, ok := s.eventstore.(events.CheckpointStore)
+	if !ok {
+		return nil, events.CheckpointsNotSupported
+	}
+
+	s.logger.Debug("Listing checkpoints.")
+	res, err := checkpoints.ListCheckpoints(ctx)
+	if err == nil {
+		s.logger.Debug("Listed checkpoints.", "count", len(res))
+	} else {
+		s.logger.Debug("Failed to list checkpoints.", "error", err)
+	}
+	return res, err
+}
+
+// ResetCheckpoint implements the CheckpointStore interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) ResetCheckpoint(ctx context.Context, consumer string) error {
+	checkpoints, ok := s.eventstore.(events.CheckpointStore)
+	if !ok {
+		return events.CheckpointsNotSupported
+	}
+
+	s.logger.Debug("Resetting checkpoint.", "consumer", consumer)
+	err := checkpoints.ResetCheckpoint(ctx, consumer)
+	if err == nil {
+		s.logger.Debug("Reset checkpoint.", "consumer", consumer)
+	} else {
+		s.logger.Debug("Failed to reset checkpoint.", "consumer", consumer, "error", err)
+	}
+	return err
+}
+
+// LoadSnapshot implements the SnapshotStore interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) LoadSnapshot(ctx context.Context, consumer string) (*events.Snapshot, error) {
+	snapshots, ok := s.eventstore.(events.SnapshotStore)
+	if !ok {
+		return nil, events.SnapshotsNotSupported
+	}
+
+	s.logger.Debug("Loading snapshot.", "consumer", consumer)
+	snapshot, err := snapshots.LoadSnapshot(ctx, consumer)
+	if err != nil {
+		s.logger.Debug("Failed to load snapshot.", "consumer", consumer, "error", err)
+	} else if snapshot == nil {
+		s.logger.Debug("No snapshot found.", "consumer", consumer)
+	} else {
+		s.logger.Debug("Loaded snapshot.", "consumer", consumer, "id", snapshot.EventID, "size", len(snapshot.Data))
+	}
+	return snapshot, err
+}
+
+// SaveSnapshot implements the SnapshotStore interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) SaveSnapshot(ctx context.Context, consumer string, id events.EventID, data []byte) error {
+	snapshots, ok := s.eventstore.(events.SnapshotStore)
+	if !ok {
+		return events.SnapshotsNotSupported
+	}
+
+	s.logger.Debug("Saving snapshot.", "consumer", consumer, "id", id, "size", len(data))
+	err := snapshots.SaveSnapshot(ctx, consumer, id, data)
+	if err == nil {
+		s.logger.Debug("Saved snapshot.", "consumer", consumer, "id", id)
+	} else {
+		s.logger.Debug("Failed to save snapshot.", "consumer", consumer, "id", id, "error", err)
+	}
+	return err
+}
+
+// QuarantineEvent implements the Quarantine interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) QuarantineEvent(ctx context.Context, id events.EventID, class string, reason string) error {
+	quarantine, ok := s.eventstore.(events.Quarantine)
+	if !ok {
+		return events.QuarantineNotSupported
+	}
+
+	s.logger.Debug("Quarantining event.", "id", id, "class", class, "reason", reason)
+	err := quarantine.QuarantineEvent(ctx, id, class, reason)
+	if err == nil {
+		s.logger.Debug("Quarantined event.", "id", id)
+	} else {
+		s.logger.Debug("Failed to quarantine event.", "id", id, "error", err)
+	}
+	return err
+}
+
+// ListQuarantined implements the Quarantine interface if the decorated event store does.
+func (s *LoggingDecoratorEventStore) ListQuarantined(ctx context.Context) ([]events.QuarantinedEvent, error) {
+	quarantine, ok := s.eventstore.(events.Quarantine)
+	if !ok {
+		return nil, events.QuarantineNotSupported
+	}
+
+	s.logger.Debug("Listing quarantined events.")
+	res, err := quarantine.ListQuarantined(ctx)
+	if err == nil {
+		s.logger.Debug("Listed quarantined events.", "count", len(res))
+	} else {
+		s.logger.Debug("Failed to list quarantined events.", "error", err)
+	}
+	return res, err
+}
@@ -15,7 +15,7 @@ var notImplemented error = errors.New("not implemented")
 // mock for the events.EventStore interface
 type eventstoreMock struct{}
 
-func (store *eventstoreMock) ParseEventID(str string) (int32, error) {
+func (store *eventstoreMock) ParseEventID(str string) (events.EventID, error) {
 	return 0, notImplemented
 }
 
@@ -27,19 +27,31 @@ func (store *eventstoreMock) Close() error {
 	return notImplemented
 }
 
-func (store *eventstoreMock) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID int32) (events.Envelope, error) {
+func (store *eventstoreMock) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID events.EventID) (events.Envelope, error) {
 	return nil, notImplemented
 }
 
-func (store *eventstoreMock) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (int32, error) {
+func (store *eventstoreMock) InsertMany(ctx context.Context, newEvents []events.NewEvent) ([]events.Envelope, error) {
+	return nil, notImplemented
+}
+
+func (store *eventstoreMock) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (events.EventID, error) {
 	return 0, notImplemented
 }
 
-func (store *eventstoreMock) RetrieveOne(ctx context.Context, id int32) (events.Envelope, error) {
+func (store *eventstoreMock) RetrieveOne(ctx context.Context, id events.EventID) (events.Envelope, error) {
+	return nil, notImplemented
+}
+
+func (store *eventstoreMock) LoadEvents(ctx context.Context, bounds events.Range, filter events.Filter) (<-chan events.Envelope, error) {
+	return nil, notImplemented
+}
+
+func (store *eventstoreMock) LoadCaused(ctx context.Context, id events.EventID) (<-chan events.Envelope, error) {
 	return nil, notImplemented
 }
 
-func (store *eventstoreMock) LoadEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
+func (store *eventstoreMock) LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan events.Envelope, error) {
 	return nil, notImplemented
 }
 
@@ -47,7 +59,7 @@ func (store *eventstoreMock) FollowNotifications(ctx context.Context) (<-chan ev
 	return nil, notImplemented
 }
 
-func (store *eventstoreMock) FollowEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
+func (store *eventstoreMock) FollowEvents(ctx context.Context, startAfter events.EventID, filter events.Filter) (<-chan events.Envelope, error) {
 	return nil, notImplemented
 }
 
@@ -106,6 +118,11 @@ func createMock() *LoggingDecoratorEventStore {
 // make sure the decorator implements the event store interface
 func TestInterface(t *testing.T) {
 	var _ events.EventStore = &LoggingDecoratorEventStore{}
+	var _ events.Migrator = &LoggingDecoratorEventStore{}
+	var _ events.StreamLoader = &LoggingDecoratorEventStore{}
+	var _ events.CausationLoader = &LoggingDecoratorEventStore{}
+	var _ events.CheckpointStore = &LoggingDecoratorEventStore{}
+	var _ events.SnapshotStore = &LoggingDecoratorEventStore{}
 }
 
 func TestParseEventID(t *testing.T) {
@@ -163,6 +180,23 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestInsertMany(t *testing.T) {
+	decorator := createMock()
+
+	ctx := context.Background()
+
+	newEvents := []events.NewEvent{{Event: &eventMock{}}, {Event: &eventMock{}}}
+
+	res, err := decorator.InsertMany(ctx, newEvents)
+
+	if res != nil {
+		t.Errorf("expected nil as result")
+	}
+	if err != notImplemented {
+		t.Errorf("unexpected error")
+	}
+}
+
 func TestResolveUUID(t *testing.T) {
 	decorator := createMock()
 
@@ -202,7 +236,37 @@ func TestLoadEvents(t *testing.T) {
 
 	ctx := context.Background()
 
-	res, err := decorator.LoadEvents(ctx, 42)
+	res, err := decorator.LoadEvents(ctx, events.Range{StartAfter: 42}, events.Filter{})
+
+	if res != nil {
+		t.Errorf("expected nil as result")
+	}
+	if err != notImplemented {
+		t.Errorf("unexpected error")
+	}
+}
+
+func TestLoadCaused(t *testing.T) {
+	decorator := createMock()
+
+	ctx := context.Background()
+
+	res, err := decorator.LoadCaused(ctx, 42)
+
+	if res != nil {
+		t.Errorf("expected nil as result")
+	}
+	if err != notImplemented {
+		t.Errorf("unexpected error")
+	}
+}
+
+func TestLoadStream(t *testing.T) {
+	decorator := createMock()
+
+	ctx := context.Background()
+
+	res, err := decorator.LoadStream(ctx, "stream", 1)
 
 	if res != nil {
 		t.Errorf("expected nil as result")
@@ -217,7 +281,7 @@ func TestFollowEvents(t *testing.T) {
 
 	ctx := context.Background()
 
-	res, err := decorator.FollowEvents(ctx, 0)
+	res, err := decorator.FollowEvents(ctx, 0, events.Filter{})
 
 	if res != nil {
 		t.Errorf("expected nil as result")
@@ -241,3 +305,84 @@ func TestFollowNotifications(t *testing.T) {
 		t.Errorf("unexpected error")
 	}
 }
+
+func TestMigrate(t *testing.T) {
+	decorator := createMock()
+
+	ctx := context.Background()
+
+	res, err := decorator.Migrate(ctx)
+
+	if res != 0 {
+		t.Errorf("expected zero as result")
+	}
+	if err != events.MigrationsNotSupported {
+		t.Errorf("unexpected error")
+	}
+}
+
+func TestCheckpoints(t *testing.T) {
+	decorator := createMock()
+
+	ctx := context.Background()
+
+	id, err := decorator.LoadCheckpoint(ctx, "consumer")
+	if id != 0 {
+		t.Errorf("expected zero as result")
+	}
+	if err != events.CheckpointsNotSupported {
+		t.Errorf("unexpected error")
+	}
+
+	if err := decorator.SaveCheckpoint(ctx, "consumer", 42); err != events.CheckpointsNotSupported {
+		t.Errorf("unexpected error")
+	}
+
+	res, err := decorator.ListCheckpoints(ctx)
+	if res != nil {
+		t.Errorf("expected nil as result")
+	}
+	if err != events.CheckpointsNotSupported {
+		t.Errorf("unexpected error")
+	}
+
+	if err := decorator.ResetCheckpoint(ctx, "consumer"); err != events.CheckpointsNotSupported {
+		t.Errorf("unexpected error")
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	decorator := createMock()
+
+	ctx := context.Background()
+
+	res, err := decorator.LoadSnapshot(ctx, "consumer")
+	if res != nil {
+		t.Errorf("expected nil as result")
+	}
+	if err != events.SnapshotsNotSupported {
+		t.Errorf("unexpected error")
+	}
+
+	if err := decorator.SaveSnapshot(ctx, "consumer", 42, []byte("data")); err != events.SnapshotsNotSupported {
+		t.Errorf("unexpected error")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	decorator := createMock()
+
+	ctx := context.Background()
+
+	if err := decorator.QuarantineEvent(ctx, 42, "class", "reason"); err != events.QuarantineNotSupported {
+		t.Errorf("unexpected error")
+	}
+
+	res, err := decorator.ListQuarantined(ctx)
+	if res != nil {
+		t.Errorf("expected nil as result")
+	}
+	if err != events.QuarantineNotSupported {
+		t.Errorf("unexpected error")
+	}
+}
@@ -0,0 +1,652 @@
+package memory
+
+// Eventstore keeping all data in process memory
+// This is mostly useful for tests and for local experiments, because nothing
+// is persisted and the data is not shared between processes. Waiting for new
+// events is implemented with a channel that is closed and replaced on every
+// insert, which wakes up all goroutines waiting on it.
+
+import (
+	"api-broker-prototype/events"
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// memoryEnvelope implements the Envelope interface.
+type memoryEnvelope struct {
+	IDVal            events.EventID
+	ExternalUUIDVal  uuid.UUID
+	CreatedVal       time.Time
+	CausationIDVal   events.EventID
+	CorrelationIDVal events.EventID
+	MetadataVal      map[string]string
+	StreamVal        string
+	VersionVal       int32
+	EventVal         events.Event
+}
+
+// ID implements the Envelope interface.
+func (env *memoryEnvelope) ID() events.EventID {
+	return env.IDVal
+}
+
+// Created implements the Envelope interface.
+func (env *memoryEnvelope) Created() time.Time {
+	return env.CreatedVal
+}
+
+// ExternalUUID implements the Envelope interface.
+func (env *memoryEnvelope) ExternalUUID() uuid.UUID {
+	return env.ExternalUUIDVal
+}
+
+// CausationID implements the Envelope interface.
+func (env *memoryEnvelope) CausationID() events.EventID {
+	return env.CausationIDVal
+}
+
+// CorrelationID implements the Envelope interface.
+func (env *memoryEnvelope) CorrelationID() events.EventID {
+	return env.CorrelationIDVal
+}
+
+// Metadata implements the Envelope interface.
+func (env *memoryEnvelope) Metadata() map[string]string {
+	return env.MetadataVal
+}
+
+// Stream implements the Envelope interface.
+func (env *memoryEnvelope) Stream() string {
+	return env.StreamVal
+}
+
+// Version implements the Envelope interface.
+func (env *memoryEnvelope) Version() int32 {
+	return env.VersionVal
+}
+
+// Event implements the Envelope interface.
+func (env *memoryEnvelope) Event() events.Event {
+	return env.EventVal
+}
+
+// memoryNotification implements the Notification interface.
+type memoryNotification struct {
+	IDVal events.EventID
+}
+
+// ID implements the Notification interface.
+func (note *memoryNotification) ID() events.EventID {
+	return note.IDVal
+}
+
+// MemoryEventStore implements the EventStore interface using process memory.
+type MemoryEventStore struct {
+	mutex sync.Mutex
+	// The envelopes are stored in order, the ID is the index plus one.
+	envelopes []*memoryEnvelope
+	// external UUIDs and the ID of the event they belong to
+	uuids map[uuid.UUID]events.EventID
+	// streams and their events, ordered by version
+	streams map[string][]*memoryEnvelope
+	// events and the events directly caused by them, ordered by ID
+	caused map[events.EventID][]*memoryEnvelope
+	// consumers and their checkpoints
+	checkpoints map[string]events.Checkpoint
+	// consumers and their latest snapshots
+	snapshots map[string]events.Snapshot
+	// channel that is closed in order to wake up waiting goroutines
+	signal chan struct{}
+	err    error
+}
+
+// NewEventStore creates a MemoryEventStore instance.
+func NewEventStore() (*MemoryEventStore, error) {
+	s := MemoryEventStore{
+		uuids:       make(map[uuid.UUID]events.EventID),
+		streams:     make(map[string][]*memoryEnvelope),
+		caused:      make(map[events.EventID][]*memoryEnvelope),
+		checkpoints: make(map[string]events.Checkpoint),
+		snapshots:   make(map[string]events.Snapshot),
+		signal:      make(chan struct{}),
+	}
+	return &s, nil
+}
+
+// wake up all goroutines waiting for changes
+// This must be called with the mutex locked.
+func (s *MemoryEventStore) notify() {
+	close(s.signal)
+	s.signal = make(chan struct{})
+}
+
+// retrieve the envelope following the one with the given ID
+// This returns the envelope or nil if there is no next event. In addition,
+// it returns a channel that is closed when the store changes, which can be
+// used to wait for new events.
+func (s *MemoryEventStore) retrieveNext(id events.EventID) (*memoryEnvelope, <-chan struct{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+
+	if id < 0 || int(id) >= len(s.envelopes) {
+		return nil, s.signal, nil
+	}
+	return s.envelopes[id], s.signal, nil
+}
+
+// ParseEventID implements the EventStore interface.
+func (s *MemoryEventStore) ParseEventID(str string) (events.EventID, error) {
+	lp, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return events.EventID(lp), nil
+}
+
+// Error implements the EventStore interface.
+func (s *MemoryEventStore) Error() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err
+}
+
+// Close implements the EventStore and io.Closer interfaces.
+func (s *MemoryEventStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil
+	}
+
+	// reset fields so the GC can reclaim them
+	s.envelopes = nil
+	s.uuids = nil
+	s.streams = nil
+	s.caused = nil
+	s.checkpoints = nil
+	s.snapshots = nil
+
+	// set this error to block any further calls
+	s.err = errors.New("eventstore is closed")
+
+	// wake up any goroutines following the store so they can terminate
+	s.notify()
+
+	return nil
+}
+
+// Insert implements the EventStore interface.
+func (s *MemoryEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID events.EventID) (events.Envelope, error) {
+	res, err := s.InsertMany(ctx, []events.NewEvent{{ExternalUUID: externalUUID, Event: event, CausationID: causationID}})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// InsertMany implements the EventStore interface.
+func (s *MemoryEventStore) InsertMany(ctx context.Context, newEvents []events.NewEvent) ([]events.Envelope, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	// check that the external UUIDs are unique and the stream versions
+	// match, before storing anything
+	batch := make(map[uuid.UUID]bool)
+	versions := make(map[string]int32)
+	for _, newEvent := range newEvents {
+		if newEvent.Stream != "" {
+			version, ok := versions[newEvent.Stream]
+			if !ok {
+				version = int32(len(s.streams[newEvent.Stream]))
+			}
+			if err := events.CheckVersion(newEvent.Stream, newEvent.ExpectedVersion, version); err != nil {
+				return nil, err
+			}
+			versions[newEvent.Stream] = version + 1
+		}
+
+		if newEvent.ExternalUUID == uuid.Nil {
+			continue
+		}
+		if _, ok := s.uuids[newEvent.ExternalUUID]; ok || batch[newEvent.ExternalUUID] {
+			return nil, events.DuplicateEventUUID
+		}
+		batch[newEvent.ExternalUUID] = true
+	}
+
+	created := time.Now()
+	res := make([]events.Envelope, 0, len(newEvents))
+	for _, newEvent := range newEvents {
+		env := &memoryEnvelope{
+			IDVal:            events.EventID(len(s.envelopes) + 1),
+			ExternalUUIDVal:  newEvent.ExternalUUID,
+			CreatedVal:       created,
+			CausationIDVal:   newEvent.CausationID,
+			CorrelationIDVal: newEvent.CorrelationID,
+			MetadataVal:      maps.Clone(newEvent.Metadata),
+			StreamVal:        newEvent.Stream,
+			EventVal:         newEvent.Event,
+		}
+		if newEvent.Stream != "" {
+			env.VersionVal = int32(len(s.streams[newEvent.Stream]) + 1)
+			s.streams[newEvent.Stream] = append(s.streams[newEvent.Stream], env)
+		}
+		s.envelopes = append(s.envelopes, env)
+		if newEvent.CausationID != 0 {
+			s.caused[newEvent.CausationID] = append(s.caused[newEvent.CausationID], env)
+		}
+		if newEvent.ExternalUUID != uuid.Nil {
+			s.uuids[newEvent.ExternalUUID] = env.IDVal
+		}
+		res = append(res, env)
+	}
+
+	s.notify()
+
+	return res, nil
+}
+
+// ResolveUUID implements the EventStore interface.
+func (s *MemoryEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (events.EventID, error) {
+	if externalUUID == uuid.Nil {
+		return 0, errors.New("provided external UUID is null")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	id, ok := s.uuids[externalUUID]
+	if !ok {
+		return 0, errors.New("document not found")
+	}
+	return id, nil
+}
+
+// RetrieveOne implements the EventStore interface.
+func (s *MemoryEventStore) RetrieveOne(ctx context.Context, id events.EventID) (events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if id < 0 || int(id) > len(s.envelopes) {
+		return nil, errors.New("document not found")
+	}
+	return s.envelopes[id-1], nil
+}
+
+// LoadEvents implements the EventStore interface.
+func (s *MemoryEventStore) LoadEvents(ctx context.Context, bounds events.Range, filter events.Filter) (<-chan events.Envelope, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	// Envelopes are never modified, so a copy of the list is enough to
+	// retrieve them without holding the mutex. The ID of an envelope is its
+	// index plus one, so the range translates to indices directly.
+	first := max(bounds.StartAfter, 0)
+	last := events.EventID(len(s.envelopes))
+	if bounds.StartBefore != 0 && bounds.StartBefore-1 < last {
+		last = bounds.StartBefore - 1
+	}
+	var envelopes []*memoryEnvelope
+	if first < last {
+		envelopes = append(envelopes, s.envelopes[first:last]...)
+	}
+	if bounds.Backward {
+		slices.Reverse(envelopes)
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		// pump events
+		count := 0
+		for _, envelope := range envelopes {
+			if bounds.Limit > 0 && count >= bounds.Limit {
+				return
+			}
+
+			// skip events not selected by the filter
+			if !filter.Matches(envelope) {
+				continue
+			}
+
+			// emit envelope
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+				count++
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadCaused implements the CausationLoader interface.
+func (s *MemoryEventStore) LoadCaused(ctx context.Context, id events.EventID) (<-chan events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	// collect the tree level by level, then restore the order of the IDs
+	var envelopes []*memoryEnvelope
+	for level := s.caused[id]; len(level) > 0; {
+		envelopes = append(envelopes, level...)
+		var next []*memoryEnvelope
+		for _, env := range level {
+			next = append(next, s.caused[env.IDVal]...)
+		}
+		level = next
+	}
+	slices.SortFunc(envelopes, func(a, b *memoryEnvelope) int {
+		return cmp.Compare(a.IDVal, b.IDVal)
+	})
+
+	out := make(chan events.Envelope)
+
+	// run code to emit events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for _, envelope := range envelopes {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadStream implements the StreamLoader interface.
+func (s *MemoryEventStore) LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan events.Envelope, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	// Envelopes are never modified, so a copy of the list is enough to
+	// retrieve them without holding the mutex.
+	var envelopes []*memoryEnvelope
+	if fromVersion < 1 {
+		fromVersion = 1
+	}
+	if all := s.streams[stream]; int(fromVersion) <= len(all) {
+		envelopes = append(envelopes, all[fromVersion-1:]...)
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to emit events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for _, envelope := range envelopes {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// FollowNotifications implements the EventStore interface.
+func (s *MemoryEventStore) FollowNotifications(ctx context.Context) (<-chan events.Notification, error) {
+	// determine the current end of the stream, notifications are only
+	// emitted for events inserted afterwards
+	s.mutex.Lock()
+	if s.err != nil {
+		s.mutex.Unlock()
+		return nil, s.err
+	}
+	id := events.EventID(len(s.envelopes))
+	s.mutex.Unlock()
+
+	out := make(chan events.Notification)
+
+	// run code to pump notifications in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for {
+			envelope, signal, err := s.retrieveNext(id)
+			if err != nil {
+				return
+			}
+			if envelope == nil {
+				// wait for new events to be inserted
+				select {
+				case <-ctx.Done():
+					return
+				case <-signal:
+					continue
+				}
+			}
+
+			// emit notification
+			select {
+			case <-ctx.Done():
+				return
+			case out <- &memoryNotification{IDVal: envelope.IDVal}:
+			}
+
+			// move to next element
+			id = envelope.IDVal
+		}
+	}()
+
+	return out, nil
+}
+
+// FollowEvents implements the EventStore interface.
+func (s *MemoryEventStore) FollowEvents(ctx context.Context, startAfter events.EventID, filter events.Filter) (<-chan events.Envelope, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.Error(); err != nil {
+		return nil, err
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		// pump events
+		id := startAfter
+		for {
+			// retrieve next envelope
+			envelope, signal, err := s.retrieveNext(id)
+			if err != nil {
+				return
+			}
+			if envelope == nil {
+				// no more events after "id"
+				// When this happens, we just wait for the signal, which is
+				// emitted when new events are inserted.
+				select {
+				case <-ctx.Done():
+					// cancelled by context
+					return
+				case <-signal:
+					continue
+				}
+			}
+
+			// move to next element
+			id = envelope.IDVal
+
+			// skip events not selected by the filter
+			if !filter.Matches(envelope) {
+				continue
+			}
+
+			// emit envelope
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadCheckpoint implements the CheckpointStore interface.
+func (s *MemoryEventStore) LoadCheckpoint(ctx context.Context, consumer string) (events.EventID, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	return s.checkpoints[consumer].EventID, nil
+}
+
+// SaveCheckpoint implements the CheckpointStore interface.
+func (s *MemoryEventStore) SaveCheckpoint(ctx context.Context, consumer string, id events.EventID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return s.err
+	}
+
+	s.checkpoints[consumer] = events.Checkpoint{Consumer: consumer, EventID: id, Updated: time.Now()}
+	return nil
+}
+
+// ListCheckpoints implements the CheckpointStore interface.
+func (s *MemoryEventStore) ListCheckpoints(ctx context.Context) ([]events.Checkpoint, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	res := make([]events.Checkpoint, 0, len(s.checkpoints))
+	for _, checkpoint := range s.checkpoints {
+		res = append(res, checkpoint)
+	}
+	slices.SortFunc(res, func(a, b events.Checkpoint) int {
+		return strings.Compare(a.Consumer, b.Consumer)
+	})
+	return res, nil
+}
+
+// ResetCheckpoint implements the CheckpointStore interface.
+func (s *MemoryEventStore) ResetCheckpoint(ctx context.Context, consumer string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return s.err
+	}
+
+	delete(s.checkpoints, consumer)
+	return nil
+}
+
+// LoadSnapshot implements the SnapshotStore interface.
+func (s *MemoryEventStore) LoadSnapshot(ctx context.Context, consumer string) (*events.Snapshot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	snapshot, ok := s.snapshots[consumer]
+	if !ok {
+		return nil, nil
+	}
+	snapshot.Data = slices.Clone(snapshot.Data)
+	return &snapshot, nil
+}
+
+// SaveSnapshot implements the SnapshotStore interface.
+func (s *MemoryEventStore) SaveSnapshot(ctx context.Context, consumer string, id events.EventID, data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// don't do anything if the error state of the store is set already
+	if s.err != nil {
+		return s.err
+	}
+
+	s.snapshots[consumer] = events.Snapshot{Consumer: consumer, EventID: id, Data: slices.Clone(data), Created: time.Now()}
+	return nil
+}
@@ -0,0 +1,208 @@
+package memory
+
+import (
+	"api-broker-prototype/events"
+	"api-broker-prototype/events/storetest"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestEnvelope(t *testing.T) {
+	var _ events.Envelope = &memoryEnvelope{}
+}
+
+func TestNotification(t *testing.T) {
+	var _ events.Notification = &memoryNotification{}
+}
+
+func TestEventstore(t *testing.T) {
+	var _ events.EventStore = &MemoryEventStore{}
+}
+
+func TestStreamLoader(t *testing.T) {
+	var _ events.StreamLoader = &MemoryEventStore{}
+}
+
+func TestCausationLoader(t *testing.T) {
+	var _ events.CausationLoader = &MemoryEventStore{}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	var _ events.CheckpointStore = &MemoryEventStore{}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	var _ events.SnapshotStore = &MemoryEventStore{}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) events.EventStore {
+		store, _ := NewEventStore()
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestInsert(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewEventStore()
+
+	externalUUID := uuid.FromStringOrNil("22428f46-a2d8-4d51-b6b5-bc8551bd0921")
+
+	first, err := store.Insert(ctx, externalUUID, events.SimpleEvent{Message: "first"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if first.ID() != 1 {
+		t.Errorf("unexpected ID %d", first.ID())
+	}
+
+	second, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{Message: "second"}, first.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if second.ID() != 2 {
+		t.Errorf("unexpected ID %d", second.ID())
+	}
+	if second.CausationID() != first.ID() {
+		t.Errorf("unexpected causation ID %d", second.CausationID())
+	}
+
+	// a nil UUID is never considered a duplicate
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// a second insert with the same UUID fails
+	if _, err := store.Insert(ctx, externalUUID, events.SimpleEvent{}, 0); !errors.Is(err, events.DuplicateEventUUID) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	id, err := store.ResolveUUID(ctx, externalUUID)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if id != first.ID() {
+		t.Errorf("unexpected ID %d", id)
+	}
+
+	env, err := store.RetrieveOne(ctx, second.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env.Event() != (events.SimpleEvent{Message: "second"}) {
+		t.Errorf("unexpected event %v", env.Event())
+	}
+
+	if _, err := store.RetrieveOne(ctx, 42); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+func TestLoadEvents(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewEventStore()
+
+	for i := 0; i != 3; i++ {
+		if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	ch, err := store.LoadEvents(ctx, events.Range{StartAfter: 1}, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var ids []events.EventID
+	for env := range ch {
+		ids = append(ids, env.ID())
+	}
+	if len(ids) != 2 || ids[0] != 2 || ids[1] != 3 {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+}
+
+func TestFollowEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store, _ := NewEventStore()
+
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ch, err := store.FollowEvents(ctx, 0, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	notifications, err := store.FollowNotifications(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// existing events are emitted first
+	env := <-ch
+	if env == nil || env.ID() != 1 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+
+	// new events wake up the followers
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env = <-ch
+	if env == nil || env.ID() != 2 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+	note := <-notifications
+	if note == nil || note.ID() != 2 {
+		t.Fatalf("unexpected notification %v", note)
+	}
+
+	// closing the store terminates the followers
+	store.Close()
+	if env, ok := <-ch; ok {
+		t.Errorf("unexpected envelope %v", env)
+	}
+	if note, ok := <-notifications; ok {
+		t.Errorf("unexpected notification %v", note)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewEventStore()
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	env, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, env.ID()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.SaveCheckpoint(ctx, "test", env.ID()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.SaveSnapshot(ctx, "test", env.ID(), []byte("data")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// all data is released, further calls fail
+	store.Close()
+	if store.envelopes != nil || store.uuids != nil || store.streams != nil || store.caused != nil || store.checkpoints != nil || store.snapshots != nil {
+		t.Errorf("data not released")
+	}
+	if err := store.SaveCheckpoint(ctx, "test", env.ID()); err == nil {
+		t.Errorf("expected error missing")
+	}
+	if _, err := store.LoadSnapshot(ctx, "test"); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
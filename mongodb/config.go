@@ -0,0 +1,126 @@
+package mongodb
+
+// This file implements the configuration of the connection to the DB.
+
+import (
+	"errors"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// DefaultConnectTimeout is used when no connect timeout is configured.
+const DefaultConnectTimeout = 1 * time.Second
+
+// Modes for following new events.
+const (
+	// FollowCapped uses a tailable cursor on the capped notifications
+	// collection. Every insert writes a notification document.
+	FollowCapped = "capped"
+	// FollowChangeStream uses a change stream on the events collection. This
+	// requires a replica set, but no separate notification documents.
+	FollowChangeStream = "changestream"
+)
+
+// Config contains the settings for a MongoDBEventStore.
+// Empty or zero values select the defaults.
+type Config struct {
+	// URI is a complete connection string ("mongodb://..." or
+	// "mongodb+srv://..."), which can include credentials, replica set and
+	// TLS options. If it is empty, it is built from Host.
+	URI string
+	// Host is the hostname of the DB server, optionally with a port.
+	Host string
+	// Database is the name of the DB. If it is empty, the default DB from the
+	// URI is used, falling back to DBName.
+	Database string
+	// EventCollection is the name of the collection with the events.
+	EventCollection string
+	// NotificationCollection is the name of the capped collection with the
+	// notifications.
+	NotificationCollection string
+	// CounterCollection is the name of the collection with the counters used
+	// to allocate event IDs.
+	CounterCollection string
+	// CheckpointCollection is the name of the collection with the consumer
+	// checkpoints.
+	CheckpointCollection string
+	// SnapshotCollection is the name of the collection with the consumer
+	// snapshots.
+	SnapshotCollection string
+	// QuarantineCollection is the name of the collection with the
+	// quarantined events.
+	QuarantineCollection string
+	// SchemaVersionCollection is the name of the collection with the applied
+	// schema versions.
+	SchemaVersionCollection string
+	// FollowMode selects how followers are woken up, one of FollowCapped (the
+	// default) and FollowChangeStream.
+	FollowMode string
+	// ConnectTimeout limits the time to establish a connection.
+	ConnectTimeout time.Duration
+	// AutoMigrate causes the DB schema to be created or updated on the first
+	// connection.
+	AutoMigrate bool
+	// Logger receives warnings about failures that don't make an operation
+	// fail, like a lost notification. If it is nil, they are discarded.
+	Logger log15.Logger
+}
+
+// fill in defaults for all settings that are not given
+func (c Config) withDefaults() (Config, error) {
+	if c.URI == "" {
+		host := c.Host
+		if host == "" {
+			host = "localhost"
+		}
+		c.URI = "mongodb://" + host
+	} else if c.Host != "" {
+		return c, errors.New("MongoDB URI and host can't be combined")
+	}
+
+	if c.Database == "" {
+		cs, err := connstring.ParseAndValidate(c.URI)
+		if err != nil {
+			return c, err
+		}
+		c.Database = cs.Database
+	}
+	if c.Database == "" {
+		c.Database = DBName
+	}
+
+	if c.EventCollection == "" {
+		c.EventCollection = EventCollectionName
+	}
+	if c.NotificationCollection == "" {
+		c.NotificationCollection = NotificationCollectionName
+	}
+	if c.CounterCollection == "" {
+		c.CounterCollection = CounterCollectionName
+	}
+	if c.CheckpointCollection == "" {
+		c.CheckpointCollection = CheckpointCollectionName
+	}
+	if c.SnapshotCollection == "" {
+		c.SnapshotCollection = SnapshotCollectionName
+	}
+	if c.QuarantineCollection == "" {
+		c.QuarantineCollection = QuarantineCollectionName
+	}
+	if c.SchemaVersionCollection == "" {
+		c.SchemaVersionCollection = SchemaVersionCollectionName
+	}
+	switch c.FollowMode {
+	case "":
+		c.FollowMode = FollowCapped
+	case FollowCapped, FollowChangeStream:
+	default:
+		return c, errors.New("invalid follow mode " + c.FollowMode)
+	}
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = DefaultConnectTimeout
+	}
+	return c, nil
+}
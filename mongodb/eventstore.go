@@ -7,17 +7,26 @@ package mongodb
 // process waiting for new events. For that, the collection is capped, i.e. has
 // a maximum size. This is necessary in order to allow creation of a tailable
 // cursor, which is fundamental for the required blocking behaviour.
+//
+// Alternatively, followers can use a change stream on the "events" collection,
+// which makes the notifications collection unnecessary. Change streams are
+// only available with a replica set though. See Config.FollowMode.
 
 import (
 	"api-broker-prototype/events"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/inconshreveable/log15"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/bson/bsonrw"
@@ -30,45 +39,82 @@ import (
 // Common DB settings.
 const (
 	AppName                    = "api-broker-prototype" // Name registered at MongoDB for better log readability.
-	DBName                     = "test"                 // Name of the DB.
-	EventCollectionName        = "events"               // Name of the collection with actual events and payload.
-	NotificationCollectionName = "notifications"        // Name of the capped collection with notifications.
+	DBName                     = "test"                 // Default name of the DB.
+	EventCollectionName        = "events"               // Default name of the collection with actual events and payload.
+	NotificationCollectionName = "notifications"        // Default name of the capped collection with notifications.
+	CounterCollectionName      = "counters"             // Default name of the collection with the ID counters.
+	CheckpointCollectionName   = "checkpoints"          // Default name of the collection with the consumer checkpoints.
+	SnapshotCollectionName     = "snapshots"            // Default name of the collection with the consumer snapshots.
+	QuarantineCollectionName   = "quarantine"           // Default name of the collection with the quarantined events.
 )
 
-// The MongoDBEventCodec interface defines methods common to event codecs.
-// The codecs convert between the internal representation (Event) and the
-// general-purpose representation for MongoDB (bson.M).
-// See also the Event interface, which it is closely related to.
-type MongoDBEventCodec interface {
-	// Class returns a string that identifies the event type this codec handles.
-	Class() string
-	// Serialize the event in a way that allows writing it to a MongoDB.
-	Serialize(event events.Event) (bson.M, error)
-	// Deserialize an event from data from a MongoDB.
-	Deserialize(data bson.M) (events.Event, error)
-}
+// PollInterval is the time between two checks for new events, in case a
+// notification was lost.
+const PollInterval = 1 * time.Second
 
 // mongoDBRawEnvelope is the type representing the envelope in MongoDB
+// JSON payloads are stored as document in `data`, others as binary in
+// `encoded_data` along with their content type.
 type mongoDBRawEnvelope struct {
-	ID              int32              `bson:"_id"`
+	ID              events.EventID     `bson:"_id"`
 	ExternalUUIDVal *uuid.UUID         `bson:"external_uuid"`
 	Created         primitive.DateTime `bson:"created"`
-	CausationID     int32              `bson:"causation_id"`
+	CausationID     events.EventID     `bson:"causation_id"`
+	CorrelationID   events.EventID     `bson:"correlation_id,omitempty"`
+	Metadata        map[string]string  `bson:"metadata,omitempty"`
+	Stream          string             `bson:"stream,omitempty"`
+	Version         int32              `bson:"version,omitempty"`
 	Class           string             `bson:"class"`
+	SchemaVersion   int                `bson:"schema_version"`
+	ContentType     string             `bson:"content_type,omitempty"`
 	Data            bson.M             `bson:"data"`
+	EncodedData     []byte             `bson:"encoded_data,omitempty"`
+}
+
+// document stored in the checkpoints collection
+type mongoDBCheckpoint struct {
+	Consumer string             `bson:"_id"`
+	EventID  events.EventID     `bson:"event_id"`
+	Updated  primitive.DateTime `bson:"updated"`
+}
+
+// document stored in the snapshots collection
+type mongoDBSnapshot struct {
+	Consumer string             `bson:"_id"`
+	EventID  events.EventID     `bson:"event_id"`
+	Data     []byte             `bson:"data"`
+	Created  primitive.DateTime `bson:"created"`
+}
+
+// ID of the envelope in MongoDB
+// This is used when the envelope can't be decoded completely.
+type mongoDBRawID struct {
+	ID events.EventID `bson:"_id"`
+}
+
+// document stored in the quarantine collection
+type mongoDBQuarantined struct {
+	EventID     events.EventID     `bson:"_id"`
+	Class       string             `bson:"class"`
+	Reason      string             `bson:"reason"`
+	Quarantined primitive.DateTime `bson:"quarantined"`
 }
 
 // mongoDBEnvelope implements the Envelope interface.
 type mongoDBEnvelope struct {
-	IDVal           int32
-	ExternalUUIDVal uuid.UUID
-	CreatedVal      primitive.DateTime
-	CausationIDVal  int32
-	EventVal        events.Event
+	IDVal            events.EventID
+	ExternalUUIDVal  uuid.UUID
+	CreatedVal       primitive.DateTime
+	CausationIDVal   events.EventID
+	CorrelationIDVal events.EventID
+	MetadataVal      map[string]string
+	StreamVal        string
+	VersionVal       int32
+	EventVal         events.Event
 }
 
 // ID implements the Envelope interface.
-func (env *mongoDBEnvelope) ID() int32 {
+func (env *mongoDBEnvelope) ID() events.EventID {
 	return env.IDVal
 }
 
@@ -83,10 +129,30 @@ func (env *mongoDBEnvelope) ExternalUUID() uuid.UUID {
 }
 
 // CausationID implements the Envelope interface.
-func (env *mongoDBEnvelope) CausationID() int32 {
+func (env *mongoDBEnvelope) CausationID() events.EventID {
 	return env.CausationIDVal
 }
 
+// CorrelationID implements the Envelope interface.
+func (env *mongoDBEnvelope) CorrelationID() events.EventID {
+	return env.CorrelationIDVal
+}
+
+// Metadata implements the Envelope interface.
+func (env *mongoDBEnvelope) Metadata() map[string]string {
+	return env.MetadataVal
+}
+
+// Stream implements the Envelope interface.
+func (env *mongoDBEnvelope) Stream() string {
+	return env.StreamVal
+}
+
+// Version implements the Envelope interface.
+func (env *mongoDBEnvelope) Version() int32 {
+	return env.VersionVal
+}
+
 // Event implements the Envelope interface.
 func (env *mongoDBEnvelope) Event() events.Event {
 	return env.EventVal
@@ -94,21 +160,30 @@ func (env *mongoDBEnvelope) Event() events.Event {
 
 // mongoDBNotification implements the Notification interface.
 type mongoDBNotification struct {
-	IDVal int32 `bson:"_id"`
+	IDVal events.EventID `bson:"_id"`
 }
 
 // ID implements the Notification interface.
-func (note *mongoDBNotification) ID() int32 {
+func (note *mongoDBNotification) ID() events.EventID {
 	return note.IDVal
 }
 
 // MongoDBEventStore implements the EventStore interface using a MongoDB.
 type MongoDBEventStore struct {
-	host          string
+	config        Config
+	client        *mongo.Client
 	events        *mongo.Collection
 	notifications *mongo.Collection
-	err           error
-	codecs        map[string]MongoDBEventCodec
+	counters      *mongo.Collection
+	checkpoints   *mongo.Collection
+	snapshots     *mongo.Collection
+	quarantine    *mongo.Collection
+	transactions  bool
+	codecs        *events.CodecRegistry
+	logger        log15.Logger
+	// mutex guarding the connection and the error state
+	mutex sync.Mutex
+	err   error
 }
 
 func buildTypeRegistry() *bsoncodec.Registry {
@@ -165,195 +240,331 @@ func buildTypeRegistry() *bsoncodec.Registry {
 }
 
 // connect establishes an on-demand connection to the DB
-func (s *MongoDBEventStore) connect(ctx context.Context) {
-	// do nothing if state is already establish
+// This is safe for concurrent use, only the first call connects. It returns
+// the error state of the store, which is set on failure.
+func (s *MongoDBEventStore) connect(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// do nothing if state is already established
 	if s.events != nil || s.err != nil {
-		return
+		return s.err
 	}
 
 	opts := options.
 		Client().
 		SetRegistry(buildTypeRegistry()).
-		ApplyURI("mongodb://" + s.host).
-		SetAppName(AppName).SetConnectTimeout(1 * time.Second)
+		ApplyURI(s.config.URI).
+		SetAppName(AppName).SetConnectTimeout(s.config.ConnectTimeout)
 	if err := opts.Validate(); err != nil {
 		s.err = err
-		return
+		return err
 	}
 
 	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		s.err = err
-		return
+		return err
 	}
 
 	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(ctx)
 		s.err = err
-		return
+		return err
 	}
 
-	db := client.Database(DBName)
-	s.events = db.Collection(EventCollectionName)
-	s.notifications = db.Collection(NotificationCollectionName)
-}
-
-// NewEventStore creates and connects a MongoDBEventStore instance.
-func NewEventStore(host string) (*MongoDBEventStore, error) {
-	s := MongoDBEventStore{
-		host:   host,
-		codecs: make(map[string]MongoDBEventCodec),
+	// Transactions require a replica set or a sharded cluster, which is
+	// signalled by a replica set name or the "isdbgrid" message.
+	var status struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.M{"isMaster": 1}).Decode(&status); err != nil {
+		client.Disconnect(ctx)
+		s.err = err
+		return err
 	}
 
-	// register codecs
-	s.registerCodec(&configurationEventCodec{})
-	s.registerCodec(&simpleEventCodec{})
-	s.registerCodec(&requestEventCodec{})
-	s.registerCodec(&apiRequestEventCodec{})
-	s.registerCodec(&apiResponseEventCodec{})
-	s.registerCodec(&apiFailureEventCodec{})
-	s.registerCodec(&apiTimeoutEventCodec{})
+	db := client.Database(s.config.Database)
 
-	return &s, nil
+	// apply pending migrations
+	if s.config.AutoMigrate {
+		if _, err := migrate(ctx, db, &s.config); err != nil {
+			client.Disconnect(ctx)
+			s.err = err
+			return err
+		}
+	}
+
+	s.client = client
+	s.transactions = status.SetName != "" || status.Msg == "isdbgrid"
+	s.events = db.Collection(s.config.EventCollection)
+	s.notifications = db.Collection(s.config.NotificationCollection)
+	s.counters = db.Collection(s.config.CounterCollection)
+	s.checkpoints = db.Collection(s.config.CheckpointCollection)
+	s.snapshots = db.Collection(s.config.SnapshotCollection)
+	s.quarantine = db.Collection(s.config.QuarantineCollection)
+	return nil
 }
 
-// registerCodec registers a codec that allows conversion of Events.
-func (s *MongoDBEventStore) registerCodec(codec MongoDBEventCodec) {
-	if codec == nil {
-		s.err = errors.New("nil codec registered")
-		return
+// NewEventStore creates a MongoDBEventStore instance.
+// The codecs are used to convert the events for storage. The connection to the
+// DB is established on first use.
+func NewEventStore(config Config, codecs *events.CodecRegistry) (*MongoDBEventStore, error) {
+	if codecs == nil {
+		return nil, errors.New("codec registry is nil")
+	}
+	config, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	s := MongoDBEventStore{
+		config: config,
+		codecs: codecs,
+		logger: config.Logger,
+	}
+	if s.logger == nil {
+		s.logger = log15.New()
+		s.logger.SetHandler(log15.DiscardHandler())
 	}
-	s.codecs[codec.Class()] = codec
+
+	return &s, nil
 }
 
 // ParseEventID implements the EventStore interface.
-func (s *MongoDBEventStore) ParseEventID(str string) (int32, error) {
-	lp, err := strconv.ParseInt(str, 10, 32)
+func (s *MongoDBEventStore) ParseEventID(str string) (events.EventID, error) {
+	lp, err := strconv.ParseInt(str, 10, 64)
 	if err != nil {
 		return 0, err
 	}
-	return int32(lp), nil
+	return events.EventID(lp), nil
+}
+
+// set the error state, unless it is set already
+func (s *MongoDBEventStore) setError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
 }
 
 // Error implements the EventStore interface.
 func (s *MongoDBEventStore) Error() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	return s.err
 }
 
 // Close implements the EventStore and io.Closer interfaces.
 func (s *MongoDBEventStore) Close() error {
-	// don't do anything if the error state of the store is set already
-	if s.err != nil {
-		return nil
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Disconnect the client, if it was connected at all. This is done even if
+	// the error state is set, so the client doesn't leak its connections.
+	var err error
+	if s.client != nil {
+		err = s.client.Disconnect(context.Background())
 	}
 
 	// reset fields so the GC can reclaim them
+	s.client = nil
 	s.events = nil
 	s.notifications = nil
+	s.counters = nil
+	s.checkpoints = nil
+	s.snapshots = nil
+	s.quarantine = nil
 
 	// set this error to block any further calls
-	s.err = errors.New("eventstore is closed")
+	if s.err == nil {
+		s.err = errors.New("eventstore is closed")
+	}
 
-	return nil
+	return err
 }
 
 // Insert implements the EventStore interface.
-func (s *MongoDBEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID int32) (events.Envelope, error) {
-	// don't do anything if the error state of the store is set already
-	s.connect(ctx)
-	if s.err != nil {
-		return nil, s.err
-	}
-
-	//  locate codec for the event class
-	class := event.Class()
-	codec := s.codecs[class]
-	if codec == nil {
-		return nil, errors.New("failed to locate codec for event")
-	}
-
-	// encode event for MongoDB storage
-	payload, err := codec.Serialize(event)
+func (s *MongoDBEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID events.EventID) (events.Envelope, error) {
+	res, err := s.InsertMany(ctx, []events.NewEvent{{ExternalUUID: externalUUID, Event: event, CausationID: causationID}})
 	if err != nil {
 		return nil, err
 	}
+	return res[0], nil
+}
 
-	env := mongoDBRawEnvelope{
-		ExternalUUIDVal: uuidAsDBValue(externalUUID),
-		CausationID:     causationID,
-		Class:           class,
-		Data:            payload,
+// InsertMany implements the EventStore interface.
+// Inserting more than one event requires transactions, i.e. a replica set or
+// a sharded cluster. Without, BatchesNotSupported is returned.
+func (s *MongoDBEventStore) InsertMany(ctx context.Context, newEvents []events.NewEvent) ([]events.Envelope, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+	if len(newEvents) == 0 {
+		return nil, nil
+	}
+	if len(newEvents) > 1 && !s.transactions {
+		return nil, events.BatchesNotSupported
 	}
 
-	// generate an ID
-	env.ID = s.findNextID(ctx)
-	if env.ID == 0 {
-		return nil, s.err
+	envs := make([]mongoDBRawEnvelope, len(newEvents))
+	for i, newEvent := range newEvents {
+		envs[i] = mongoDBRawEnvelope{
+			ExternalUUIDVal: uuidAsDBValue(newEvent.ExternalUUID),
+			CausationID:     newEvent.CausationID,
+			CorrelationID:   newEvent.CorrelationID,
+			Metadata:        newEvent.Metadata,
+			Stream:          newEvent.Stream,
+			Class:           newEvent.Event.Class(),
+		}
+
+		// encode event for MongoDB storage, JSON payloads are stored as
+		// document, so they remain searchable
+		if s.codecs.Encoding() == events.JSON {
+			payload, schemaVersion, err := s.codecs.Serialize(newEvent.Event)
+			if err != nil {
+				return nil, err
+			}
+			envs[i].SchemaVersion = schemaVersion
+			envs[i].Data = bson.M(payload)
+			continue
+		}
+		payload, contentType, schemaVersion, err := s.codecs.Encode(newEvent.Event)
+		if err != nil {
+			return nil, err
+		}
+		envs[i].SchemaVersion = schemaVersion
+		envs[i].ContentType = contentType
+		envs[i].EncodedData = payload
 	}
 
 	for {
-		// set creation date
-		env.Created = primitive.NewDateTimeFromTime(time.Now())
-
-		// insert new document
-		res, err := s.events.InsertOne(ctx, env)
+		// allocate IDs and insert new documents
+		err := s.insertEnvelopes(ctx, envs, newEvents)
 		if err != nil {
-			var write_exc mongo.WriteException
-			if !errors.As(err, &write_exc) {
+			var server_err mongo.ServerError
+			if !errors.As(err, &server_err) {
 				return nil, err
 			}
 
 			// E11000 seems to be MongoDB's "duplicate key error", a unique
 			// constraint violation. We now only need to distinguish by the
 			// index that prevented the insert, the ID or the external UUID.
-			if write_exc.HasErrorCodeWithMessage(11000, "index: unique_external_uuid_constraint") {
+			if server_err.HasErrorCodeWithMessage(11000, "index: unique_external_uuid_constraint") {
 				return nil, events.DuplicateEventUUID
 			}
-			if write_exc.HasErrorCodeWithMessage(11000, "index: _id_") {
-				// The ID is already used, just generate a new one.
-				id := s.findNextID(ctx)
-				if id == 0 {
-					return nil, s.err
+			if server_err.HasErrorCodeWithMessage(11000, "index: unique_stream_version_constraint") {
+				// A concurrent writer appended to one of the streams. If
+				// that contradicts an expected version, report it, else
+				// retry with the new versions.
+				if err := s.findVersionConflict(ctx, newEvents); err != nil {
+					return nil, err
 				}
-				if id != env.ID {
-					// retrying with the new ID
-					env.ID = id
+				continue
+			}
+			if server_err.HasErrorCodeWithMessage(11000, "index: _id_") {
+				// The ID is already used. Without transactions, a concurrent
+				// writer took it, so just retry with the next one. Otherwise,
+				// the counter is behind the stored events. This happens with
+				// events that were written before the counter existed. Bring
+				// the counter up to date and retry with new IDs.
+				if !s.transactions {
 					continue
 				}
+				if err := syncCounter(ctx, s.events, s.counters); err != nil {
+					s.setError(err)
+					return nil, s.Error()
+				}
+				continue
 			}
 
 			return nil, err
 		}
+		break
+	}
 
-		// decode the assigned object ID
-		id, ok := res.InsertedID.(int32)
-		if !ok {
-			s.err = errors.New("no ID returned from insert")
-			return nil, s.err
+	// insert notifications with the created documents' IDs
+	// With change streams, the insert itself is the notification. Otherwise,
+	// the notification can't be written in the same transaction, because
+	// MongoDB doesn't allow writes to capped collections in transactions.
+	// A failure is not fatal though, because the events are stored already
+	// and followers also poll for new events periodically. Failing here would
+	// make the caller retry and insert the events a second time. The failure
+	// only delays followers, so it is logged as a warning.
+	if s.config.FollowMode != FollowChangeStream {
+		notes := make([]interface{}, len(envs))
+		for i, env := range envs {
+			notes[i] = mongoDBNotification{IDVal: env.ID}
 		}
-		if id != env.ID {
-			s.err = errors.New("returned ID differs from written ID")
-			return nil, s.err
+		if _, err := s.notifications.InsertMany(ctx, notes); err != nil {
+			s.logger.Warn("failed to insert notifications, followers will pick up the events by polling", "first_id", envs[0].ID, "count", len(envs), "error", err)
 		}
-		break
 	}
 
-	// insert a notification with the created document's ID
-	var note mongoDBNotification
-	note.IDVal = env.ID
-	_, err = s.notifications.InsertOne(ctx, note)
-	if err != nil {
-		s.err = err
-		return nil, s.err
+	res := make([]events.Envelope, len(envs))
+	for i, env := range envs {
+		res[i] = &mongoDBEnvelope{
+			IDVal:            env.ID,
+			ExternalUUIDVal:  dbValueAsUUID(env.ExternalUUIDVal),
+			CreatedVal:       env.Created,
+			CausationIDVal:   env.CausationID,
+			CorrelationIDVal: env.CorrelationID,
+			MetadataVal:      maps.Clone(env.Metadata),
+			StreamVal:        env.Stream,
+			VersionVal:       env.Version,
+			EventVal:         newEvents[i].Event,
+		}
 	}
+	return res, nil
+}
 
-	res := &mongoDBEnvelope{
-		IDVal:           env.ID,
-		ExternalUUIDVal: dbValueAsUUID(env.ExternalUUIDVal),
-		CreatedVal:      env.Created,
-		CausationIDVal:  env.CausationID,
-		EventVal:        event,
+// determine the current version of a stream
+func (s *MongoDBEventStore) streamVersion(ctx context.Context, stream string) (int32, error) {
+	opts := options.FindOne().
+		SetProjection(bson.M{"version": 1}).
+		SetSort(bson.M{"version": -1})
+	res := s.events.FindOne(ctx, bson.M{"stream": stream}, opts)
+	if res.Err() == mongo.ErrNoDocuments {
+		// not an error, the stream is only empty
+		return 0, nil
 	}
-	return res, nil
+	if res.Err() != nil {
+		return 0, res.Err()
+	}
+
+	var envelope mongoDBRawEnvelope
+	if err := res.Decode(&envelope); err != nil {
+		return 0, err
+	}
+	return envelope.Version, nil
+}
+
+// find the stream whose version doesn't match the expected one
+// This is used after a concurrent append to a stream made an insert fail. It
+// returns the according VersionConflictError or nil if the events don't
+// expect particular versions, so that the insert can be retried.
+func (s *MongoDBEventStore) findVersionConflict(ctx context.Context, newEvents []events.NewEvent) error {
+	checked := make(map[string]bool)
+	for _, newEvent := range newEvents {
+		if newEvent.Stream == "" || checked[newEvent.Stream] {
+			continue
+		}
+		checked[newEvent.Stream] = true
+
+		version, err := s.streamVersion(ctx, newEvent.Stream)
+		if err != nil {
+			return err
+		}
+		if err := events.CheckVersion(newEvent.Stream, newEvent.ExpectedVersion, version); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // convert UUID to a parameter for the DB
@@ -380,70 +591,193 @@ func dbValueAsUUID(val *uuid.UUID) uuid.UUID {
 }
 
 // ResolveUUID implements the EventStore interface.
-func (s *MongoDBEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (int32, error) {
+func (s *MongoDBEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (events.EventID, error) {
 	if externalUUID == uuid.Nil {
 		return 0, errors.New("provided external UUID is null")
 	}
 
 	// don't do anything if the error state of the store is set already
-	s.connect(ctx)
-	if s.err != nil {
-		return 0, s.err
+	if err := s.connect(ctx); err != nil {
+		return 0, err
 	}
 
-	// retrieve the document from the DB
+	// retrieve the ID of the document from the DB
+	// Only the ID is decoded, so that this works for undecodable events, too.
 	filter := bson.M{"external_uuid": bson.M{"$eq": externalUUID}}
-	res := s.events.FindOne(ctx, filter)
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	res := s.events.FindOne(ctx, filter, opts)
 	if res.Err() == mongo.ErrNoDocuments {
 		return 0, errors.New("document not found")
 	}
 	if res.Err() != nil {
-		s.err = res.Err()
-		return 0, s.err
+		s.setError(res.Err())
+		return 0, s.Error()
+	}
+
+	var doc mongoDBRawID
+	if err := res.Decode(&doc); err != nil {
+		s.setError(err)
+		return 0, err
+	}
+	return doc.ID, nil
+}
+
+// allocate IDs for the envelopes and insert them
+// This also determines the versions of events appended to streams and checks
+// them against the expected versions. If the DB supports transactions, all
+// this happens in one transaction. Then, IDs are not lost when the insert
+// fails, and concurrent inserts become visible in the order of their IDs,
+// because they conflict on the counter document. Concurrent appends to the
+// same stream are caught by the unique index on the stream versions.
+// Without transactions, an ID allocated from the counter could become visible
+// after a higher one from a concurrent insert, and followers that moved past
+// the higher ID would never deliver that event. So, the ID is determined from
+// the highest stored ID instead. A concurrent insert of the same ID fails on
+// the unique index and is retried by the caller with the next ID. That way,
+// IDs become visible in increasing order even with several writer processes.
+// Only single events can be inserted without transactions.
+func (s *MongoDBEventStore) insertEnvelopes(ctx context.Context, envs []mongoDBRawEnvelope, newEvents []events.NewEvent) error {
+	insert := func(ctx context.Context) error {
+		streams := make(map[string]int32)
+		for i, newEvent := range newEvents {
+			if newEvent.Stream == "" {
+				continue
+			}
+			version, ok := streams[newEvent.Stream]
+			if !ok {
+				var err error
+				version, err = s.streamVersion(ctx, newEvent.Stream)
+				if err != nil {
+					return err
+				}
+			}
+			if err := events.CheckVersion(newEvent.Stream, newEvent.ExpectedVersion, version); err != nil {
+				return err
+			}
+			envs[i].Version = version + 1
+			streams[newEvent.Stream] = envs[i].Version
+		}
+
+		var lastID events.EventID
+		var err error
+		if s.transactions {
+			lastID, err = s.allocateIDs(ctx, int64(len(envs)))
+		} else {
+			lastID, err = highestID(ctx, s.events)
+			lastID++
+		}
+		if err != nil {
+			return err
+		}
+		created := primitive.NewDateTimeFromTime(time.Now())
+		docs := make([]interface{}, len(envs))
+		for i := range envs {
+			envs[i].ID = lastID - events.EventID(len(envs)-1-i)
+			envs[i].Created = created
+			docs[i] = envs[i]
+		}
+		_, err = s.events.InsertMany(ctx, docs)
+		return err
 	}
 
-	// decode envelope from the document
-	envelope := s.decodeEnvelope(res)
-	if envelope == nil {
-		return 0, s.err
+	if !s.transactions {
+		return insert(ctx)
 	}
 
-	return envelope.ID(), nil
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	// This retries the whole transaction on transient errors, in particular
+	// on write conflicts with concurrent inserts.
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, insert(sc)
+	})
+	return err
 }
 
-// find next free ID to use for an insert
-// This returns zero and sets the error state if an error occurs.
-func (s *MongoDBEventStore) findNextID(ctx context.Context) int32 {
-	// find the event with the highest ID
+// allocate a number of consecutive IDs to use for an insert
+// This returns the last of the allocated IDs. The IDs are allocated atomically
+// from a counter document, so concurrent writers never get the same ID and
+// IDs are handed out in increasing order. It is only used with transactions,
+// see insertEnvelopes(). The counter document is keyed by the name of the
+// events collection, so that several event collections can share one counters
+// collection.
+func (s *MongoDBEventStore) allocateIDs(ctx context.Context, count int64) (events.EventID, error) {
+	filter := bson.M{"_id": s.events.Name()}
+	update := bson.M{"$inc": bson.M{"seq": count}}
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+	res := s.counters.FindOneAndUpdate(ctx, filter, update, opts)
+	if mongo.IsDuplicateKeyError(res.Err()) {
+		// A concurrent upsert created the counter document, retry once.
+		res = s.counters.FindOneAndUpdate(ctx, filter, update, opts)
+	}
+	if res.Err() != nil {
+		return 0, res.Err()
+	}
+
+	var counter mongoDBCounter
+	if err := res.Decode(&counter); err != nil {
+		return 0, err
+	}
+	return counter.Seq, nil
+}
+
+// mongoDBCounter is the type representing an ID counter in MongoDB
+type mongoDBCounter struct {
+	Collection string         `bson:"_id"`
+	Seq        events.EventID `bson:"seq"`
+}
+
+// determine the highest ID in the events collection, zero if it is empty
+func highestID(ctx context.Context, eventColl *mongo.Collection) (events.EventID, error) {
 	opts := options.FindOne().
 		SetProjection(bson.M{"_id": 1}).
 		SetSort(bson.M{"_id": -1})
-	res := s.events.FindOne(ctx, bson.M{}, opts)
-	if res.Err() == mongo.ErrNoDocuments {
+	res := eventColl.FindOne(ctx, bson.M{}, opts)
+	var doc mongoDBRawID
+	switch res.Err() {
+	case nil:
+		if err := res.Decode(&doc); err != nil {
+			return 0, err
+		}
+	case mongo.ErrNoDocuments:
 		// not an error, the collection is only empty
-		return 1
-	}
-	if res.Err() != nil {
-		s.err = res.Err()
-		return 0
+	default:
+		return 0, res.Err()
 	}
+	return doc.ID, nil
+}
 
-	// decode and return the envelope's ID increased by one
-	var envelope mongoDBRawEnvelope
-	if err := res.Decode(&envelope); err != nil {
-		s.err = err
-		return 0
+// raise the counter for the events collection to the highest stored ID
+// The counter is never lowered, so this is safe to call concurrently with
+// inserts.
+func syncCounter(ctx context.Context, eventColl *mongo.Collection, counters *mongo.Collection) error {
+	id, err := highestID(ctx, eventColl)
+	if err != nil {
+		return err
 	}
 
-	return envelope.ID + 1
+	filter := bson.M{"_id": eventColl.Name()}
+	update := bson.M{"$max": bson.M{"seq": id}}
+	updateOpts := options.Update().SetUpsert(true)
+	_, err = counters.UpdateOne(ctx, filter, update, updateOpts)
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent upsert created the counter document, retry once.
+		_, err = counters.UpdateOne(ctx, filter, update, updateOpts)
+	}
+	return err
 }
 
 // RetrieveOne implements the EventStore interface.
-func (s *MongoDBEventStore) RetrieveOne(ctx context.Context, id int32) (events.Envelope, error) {
+func (s *MongoDBEventStore) RetrieveOne(ctx context.Context, id events.EventID) (events.Envelope, error) {
 	// don't do anything if the error state of the store is set already
-	s.connect(ctx)
-	if s.err != nil {
-		return nil, s.err
+	if err := s.connect(ctx); err != nil {
+		return nil, err
 	}
 
 	// The ID must be valid.
@@ -458,81 +792,184 @@ func (s *MongoDBEventStore) RetrieveOne(ctx context.Context, id int32) (events.E
 		return nil, errors.New("document not found")
 	}
 	if res.Err() != nil {
-		s.err = res.Err()
-		return nil, s.err
+		s.setError(res.Err())
+		return nil, s.Error()
 	}
 
-	return s.decodeEnvelope(res), s.err
+	envelope, err := s.decodeEnvelope(res)
+	if err != nil {
+		var decodeErr *events.DecodeError
+		if !errors.As(err, &decodeErr) {
+			s.setError(err)
+		}
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// query whether an event with the ID exists
+// Only the ID is retrieved, so that this works for undecodable events, too.
+// In case of failure, it sets the error state.
+func (s *MongoDBEventStore) eventExists(ctx context.Context, id events.EventID) (bool, error) {
+	filter := bson.M{"_id": bson.M{"$eq": id}}
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	res := s.events.FindOne(ctx, filter, opts)
+	if res.Err() == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if res.Err() != nil {
+		s.setError(res.Err())
+		return false, s.Error()
+	}
+	return true, nil
 }
 
-// retrieveNext retrieves the event following the one with the given ID.
+// retrieveNext retrieves the event following the one at the given position in
+// the range, which is selected by the filter. If the range is read backward,
+// this is the event preceding the position instead. A zero position refers to
+// the start of the range.
 // This will return the decoded envelope or nil if there is no next event. In
-// case of failure, it sets the error state.
-func (s *MongoDBEventStore) retrieveNext(ctx context.Context, id int32) *mongoDBEnvelope {
+// case of failure, it sets the error state, apart from DecodeErrors, which are
+// only returned.
+func (s *MongoDBEventStore) retrieveNext(ctx context.Context, position events.EventID, bounds events.Range, filter events.Filter) (*mongoDBEnvelope, error) {
 	// don't do anything if the error state of the store is set already
-	if s.err != nil {
-		return nil
+	if err := s.Error(); err != nil {
+		return nil, err
 	}
 
-	var filter interface{}
-	if id == 0 {
-		filter = bson.M{}
-	} else {
-		filter = bson.M{"_id": bson.M{"$gt": id}}
+	query := filterQuery(filter)
+	if ids := idQuery(bounds, position); len(ids) > 0 {
+		query["_id"] = ids
 	}
 
 	// retrieve the actual document from the DB
-	res := s.events.FindOne(ctx, filter)
+	direction := 1
+	if bounds.Backward {
+		direction = -1
+	}
+	opts := options.FindOne().SetSort(bson.M{"_id": direction})
+	res := s.events.FindOne(ctx, query, opts)
 	if res.Err() == mongo.ErrNoDocuments {
 		// not an error, there are no more documents left
-		return nil
+		return nil, nil
 	}
 	if res.Err() != nil {
-		s.err = res.Err()
-		return nil
+		s.setError(res.Err())
+		return nil, res.Err()
+	}
+
+	envelope, err := s.decodeEnvelope(res)
+	var decodeErr *events.DecodeError
+	if err != nil && !errors.As(err, &decodeErr) {
+		s.setError(err)
+	}
+	return envelope, err
+}
+
+// build the query selecting the IDs following the position in the range
+func idQuery(bounds events.Range, position events.EventID) bson.M {
+	after, before := bounds.StartAfter, bounds.StartBefore
+	if position != 0 {
+		if bounds.Backward {
+			before = position
+		} else {
+			after = position
+		}
 	}
 
-	return s.decodeEnvelope(res)
+	query := bson.M{}
+	if after != 0 {
+		query["$gt"] = after
+	}
+	if before != 0 {
+		query["$lt"] = before
+	}
+	return query
+}
+
+// build the query selecting the events matching the filter
+func filterQuery(filter events.Filter) bson.M {
+	query := bson.M{}
+	if len(filter.Classes) > 0 {
+		query["class"] = bson.M{"$in": filter.Classes}
+	}
+	if filter.CausationID != 0 {
+		query["causation_id"] = filter.CausationID
+	}
+	switch filter.ExternalUUID {
+	case events.Present:
+		query["external_uuid"] = bson.M{"$type": "binData"}
+	case events.Absent:
+		// this also matches documents without the field
+		query["external_uuid"] = nil
+	}
+	created := bson.M{}
+	if !filter.CreatedSince.IsZero() {
+		created["$gte"] = primitive.NewDateTimeFromTime(filter.CreatedSince)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		created["$lt"] = primitive.NewDateTimeFromTime(filter.CreatedBefore)
+	}
+	if len(created) > 0 {
+		query["created"] = created
+	}
+	return query
 }
 
-// decode the envelope from a MongoDB lookup
-// This will return the decoded envelope. In case of failure, it sets the error
-// state. Errors here are non-recoverable, because they are caused by a mismatch
-// between the actual and expected DB structure.
-func (s *MongoDBEventStore) decodeEnvelope(raw *mongo.SingleResult) *mongoDBEnvelope {
+// decode the envelope from a MongoDB lookup or the current document of a cursor
+// This will return the decoded envelope. A document that can't be decoded
+// results in a DecodeError, provided that at least its ID can be decoded.
+// Otherwise, the error is returned as it is. This doesn't set the error state.
+func (s *MongoDBEventStore) decodeEnvelope(raw interface{ Decode(any) error }) (*mongoDBEnvelope, error) {
 	var envelope mongoDBRawEnvelope
 	if err := raw.Decode(&envelope); err != nil {
-		s.err = err
-		return nil
+		var doc mongoDBRawID
+		if raw.Decode(&doc) != nil || doc.ID == 0 {
+			return nil, err
+		}
+		return nil, &events.DecodeError{EventID: doc.ID, Err: err}
 	}
 
-	codec := s.codecs[envelope.Class]
-	if codec == nil {
-		s.err = errors.New("no codec found for class " + envelope.Class)
-		return nil
+	var event events.Event
+	var err error
+	if envelope.ContentType == "" {
+		event, err = s.codecs.Deserialize(envelope.ID, envelope.Class, envelope.SchemaVersion, events.Payload(envelope.Data))
+	} else {
+		event, err = s.codecs.Decode(envelope.ID, envelope.Class, envelope.SchemaVersion, envelope.ContentType, envelope.EncodedData)
 	}
-
-	event, err := codec.Deserialize(envelope.Data)
 	if err != nil {
-		s.err = err
-		return nil
+		return nil, err
 	}
 
 	return &mongoDBEnvelope{
-		IDVal:           envelope.ID,
-		ExternalUUIDVal: dbValueAsUUID(envelope.ExternalUUIDVal),
-		CreatedVal:      envelope.Created,
-		CausationIDVal:  envelope.CausationID,
-		EventVal:        event,
+		IDVal:            envelope.ID,
+		ExternalUUIDVal:  dbValueAsUUID(envelope.ExternalUUIDVal),
+		CreatedVal:       envelope.Created,
+		CausationIDVal:   envelope.CausationID,
+		CorrelationIDVal: envelope.CorrelationID,
+		MetadataVal:      envelope.Metadata,
+		StreamVal:        envelope.Stream,
+		VersionVal:       envelope.Version,
+		EventVal:         event,
+	}, nil
+}
+
+// apply the decode policy to an error from loading one of several events
+// This returns true if the event is to be skipped. Otherwise, it sets the
+// error state of the store.
+func (s *MongoDBEventStore) skipUndecodable(ctx context.Context, err error) bool {
+	if err := s.codecs.HandleDecodeError(ctx, err, s); err != nil {
+		s.setError(err)
+		return false
 	}
+	return true
 }
 
 // LoadEvents implements the EventStore interface.
-func (s *MongoDBEventStore) LoadEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
+func (s *MongoDBEventStore) LoadEvents(ctx context.Context, bounds events.Range, filter events.Filter) (<-chan events.Envelope, error) {
 	// don't do anything if the error state of the store is set already
-	s.connect(ctx)
-	if s.err != nil {
-		return nil, s.err
+	if err := s.connect(ctx); err != nil {
+		return nil, err
 	}
 
 	out := make(chan events.Envelope)
@@ -547,20 +984,24 @@ func (s *MongoDBEventStore) LoadEvents(ctx context.Context, startAfter int32) (<
 			return
 		}
 
-		// load the referenced start object to verify the ID is valid
-		if startAfter != 0 {
-			// TODO: actually handle the error here
-			ref, _ := s.RetrieveOne(ctx, startAfter)
-			if ref == nil {
+		// verify that the referenced start event exists
+		if bounds.StartAfter != 0 {
+			if exists, err := s.eventExists(ctx, bounds.StartAfter); err != nil || !exists {
 				return
 			}
 		}
 
 		// pump events
-		id := startAfter
-		for {
+		var position events.EventID
+		for count := 0; bounds.Limit <= 0 || count < bounds.Limit; {
 			// retrieve next envelope
-			envelope := s.retrieveNext(ctx, id)
+			envelope, err := s.retrieveNext(ctx, position, bounds, filter)
+			var decodeErr *events.DecodeError
+			if errors.As(err, &decodeErr) && s.skipUndecodable(ctx, err) {
+				// move past the skipped event
+				position = decodeErr.EventID
+				continue
+			}
 			if s.Error() != nil {
 				return
 			}
@@ -571,9 +1012,136 @@ func (s *MongoDBEventStore) LoadEvents(ctx context.Context, startAfter int32) (<
 
 			// emit envelope
 			out <- envelope
+			count++
 
 			// move to next element
-			id = envelope.IDVal
+			position = envelope.IDVal
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadCaused implements the CausationLoader interface.
+func (s *MongoDBEventStore) LoadCaused(ctx context.Context, id events.EventID) (<-chan events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		// collect the tree level by level, each level takes one query using
+		// the index on `causation_id`
+		var envelopes []*mongoDBEnvelope
+		for level := []events.EventID{id}; len(level) > 0; {
+			// don't do anything if the error state of the store is set already
+			if s.Error() != nil {
+				return
+			}
+
+			cursor, err := s.events.Find(ctx, bson.M{"causation_id": bson.M{"$in": level}})
+			if err != nil {
+				s.setError(err)
+				return
+			}
+			level = nil
+			for cursor.Next(ctx) {
+				envelope, err := s.decodeEnvelope(cursor)
+				if err != nil {
+					// The tree isn't followed below a skipped event.
+					if s.skipUndecodable(ctx, err) {
+						continue
+					}
+					cursor.Close(ctx)
+					return
+				}
+				envelopes = append(envelopes, envelope)
+				level = append(level, envelope.IDVal)
+			}
+			err = cursor.Err()
+			cursor.Close(ctx)
+			if err != nil {
+				s.setError(err)
+				return
+			}
+		}
+		slices.SortFunc(envelopes, func(a, b *mongoDBEnvelope) int {
+			return cmp.Compare(a.IDVal, b.IDVal)
+		})
+
+		// emit envelopes
+		for _, envelope := range envelopes {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadStream implements the StreamLoader interface.
+func (s *MongoDBEventStore) LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan events.Envelope, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	if fromVersion < 1 {
+		fromVersion = 1
+	}
+
+	out := make(chan events.Envelope)
+
+	// run code to retrieve events in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		// Versions within a stream are contiguous, so the events are looked
+		// up one version after the other.
+		for version := fromVersion; ; version++ {
+			// don't do anything if the error state of the store is set already
+			if s.Error() != nil {
+				return
+			}
+
+			res := s.events.FindOne(ctx, bson.M{"stream": stream, "version": version})
+			if res.Err() == mongo.ErrNoDocuments {
+				// Not an error: This is the end of the stream.
+				return
+			}
+			if res.Err() != nil {
+				s.setError(res.Err())
+				return
+			}
+			envelope, err := s.decodeEnvelope(res)
+			if err != nil {
+				if s.skipUndecodable(ctx, err) {
+					continue
+				}
+				return
+			}
+
+			// emit envelope
+			select {
+			case <-ctx.Done():
+				return
+			case out <- envelope:
+			}
 		}
 	}()
 
@@ -583,9 +1151,12 @@ func (s *MongoDBEventStore) LoadEvents(ctx context.Context, startAfter int32) (<
 // FollowNotifications implements the EventStore interface.
 func (s *MongoDBEventStore) FollowNotifications(ctx context.Context) (<-chan events.Notification, error) {
 	// don't do anything if the error state of the store is set already
-	s.connect(ctx)
-	if s.err != nil {
-		return nil, s.err
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	if s.config.FollowMode == FollowChangeStream {
+		return s.followChangeStream(ctx)
 	}
 
 	out := make(chan events.Notification)
@@ -606,7 +1177,7 @@ func (s *MongoDBEventStore) FollowNotifications(ctx context.Context) (<-chan eve
 		opts.SetCursorType(options.TailableAwait)
 		cursor, err := s.notifications.Find(ctx, filter, &opts)
 		if err != nil {
-			s.err = err
+			s.setError(err)
 			return
 		}
 		defer cursor.Close(ctx)
@@ -615,7 +1186,7 @@ func (s *MongoDBEventStore) FollowNotifications(ctx context.Context) (<-chan eve
 		for cursor.Next(ctx) {
 			var note mongoDBNotification
 			if err := cursor.Decode(&note); err != nil {
-				s.err = err
+				s.setError(err)
 				return
 			}
 			out <- &note
@@ -625,12 +1196,77 @@ func (s *MongoDBEventStore) FollowNotifications(ctx context.Context) (<-chan eve
 	return out, nil
 }
 
+// mongoDBChange is the type representing an event from a change stream
+type mongoDBChange struct {
+	DocumentKey struct {
+		ID events.EventID `bson:"_id"`
+	} `bson:"documentKey"`
+}
+
+// follow notifications using a change stream on the events collection
+// The stream is opened before returning, so no inserts after this call are
+// missed. If the stream fails, e.g. because of a failover to a different
+// node, it is resumed after the last insert seen, using its resume token.
+func (s *MongoDBEventStore) followChangeStream(ctx context.Context) (<-chan events.Notification, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": "insert"}}}}
+	stream, err := s.events.Watch(ctx, pipeline)
+	if err != nil {
+		s.setError(err)
+		return nil, s.Error()
+	}
+
+	out := make(chan events.Notification)
+
+	// run code to pump notifications in a goroutine
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for {
+			// pump notifications
+			for stream.Next(ctx) {
+				var change mongoDBChange
+				if err := stream.Decode(&change); err != nil {
+					stream.Close(ctx)
+					s.setError(err)
+					return
+				}
+				select {
+				case out <- &mongoDBNotification{IDVal: change.DocumentKey.ID}:
+				case <-ctx.Done():
+					stream.Close(ctx)
+					return
+				}
+			}
+
+			token := stream.ResumeToken()
+			stream.Close(ctx)
+			if ctx.Err() != nil {
+				// cancelled by context
+				return
+			}
+
+			// reopen the stream where the previous one stopped
+			opts := options.ChangeStream()
+			if token != nil {
+				opts.SetResumeAfter(token)
+			}
+			stream, err = s.events.Watch(ctx, pipeline, opts)
+			if err != nil {
+				s.setError(err)
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
 // FollowEvents implements the EventStore interface.
-func (s *MongoDBEventStore) FollowEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
+func (s *MongoDBEventStore) FollowEvents(ctx context.Context, startAfter events.EventID, filter events.Filter) (<-chan events.Envelope, error) {
 	// don't do anything if the error state of the store is set already
-	s.connect(ctx)
-	if s.err != nil {
-		return nil, s.err
+	if err := s.connect(ctx); err != nil {
+		return nil, err
 	}
 
 	out := make(chan events.Envelope)
@@ -645,11 +1281,9 @@ func (s *MongoDBEventStore) FollowEvents(ctx context.Context, startAfter int32)
 			return
 		}
 
-		// load the referenced start object to verify the ID is valid
+		// verify that the referenced start event exists
 		if startAfter != 0 {
-			// TODO: actually handle the error here
-			ref, _ := s.RetrieveOne(ctx, startAfter)
-			if ref == nil {
+			if exists, err := s.eventExists(ctx, startAfter); err != nil || !exists {
 				return
 			}
 		}
@@ -664,24 +1298,37 @@ func (s *MongoDBEventStore) FollowEvents(ctx context.Context, startAfter int32)
 		id := startAfter
 		for {
 			// retrieve next envelope
-			envelope := s.retrieveNext(ctx, id)
+			envelope, err := s.retrieveNext(ctx, id, events.Range{}, filter)
+			var decodeErr *events.DecodeError
+			if errors.As(err, &decodeErr) && s.skipUndecodable(ctx, err) {
+				// move past the skipped event
+				id = decodeErr.EventID
+				continue
+			}
 			if s.Error() != nil {
 				return
 			}
 			if envelope == nil {
 				// no more documents after "id"
 				// When this happens, we just wait for notifications,
-				// which are emitted when new events are queued.
+				// which are emitted when new events are queued. Since
+				// writing a notification may fail, we also check for new
+				// events periodically.
+				timer := time.NewTimer(PollInterval)
 				select {
 				case <-ctx.Done():
 					// cancelled by context
+					timer.Stop()
 					return
 				case notification := <-nch:
+					timer.Stop()
 					if notification == nil {
 						// notification channel closed
 						return
 					}
 					continue
+				case <-timer.C:
+					continue
 				}
 			}
 
@@ -695,3 +1342,159 @@ func (s *MongoDBEventStore) FollowEvents(ctx context.Context, startAfter int32)
 
 	return out, nil
 }
+
+// LoadCheckpoint implements the CheckpointStore interface.
+func (s *MongoDBEventStore) LoadCheckpoint(ctx context.Context, consumer string) (events.EventID, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return 0, err
+	}
+
+	var checkpoint mongoDBCheckpoint
+	err := s.checkpoints.FindOne(ctx, bson.M{"_id": consumer}).Decode(&checkpoint)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return checkpoint.EventID, nil
+}
+
+// SaveCheckpoint implements the CheckpointStore interface.
+func (s *MongoDBEventStore) SaveCheckpoint(ctx context.Context, consumer string, id events.EventID) error {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"event_id": id, "updated": primitive.NewDateTimeFromTime(time.Now())}}
+	_, err := s.checkpoints.UpdateOne(ctx, bson.M{"_id": consumer}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// ListCheckpoints implements the CheckpointStore interface.
+func (s *MongoDBEventStore) ListCheckpoints(ctx context.Context) ([]events.Checkpoint, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.checkpoints.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var res []events.Checkpoint
+	for cursor.Next(ctx) {
+		var checkpoint mongoDBCheckpoint
+		if err := cursor.Decode(&checkpoint); err != nil {
+			return nil, err
+		}
+		res = append(res, events.Checkpoint{
+			Consumer: checkpoint.Consumer,
+			EventID:  checkpoint.EventID,
+			Updated:  checkpoint.Updated.Time(),
+		})
+	}
+	return res, cursor.Err()
+}
+
+// ResetCheckpoint implements the CheckpointStore interface.
+func (s *MongoDBEventStore) ResetCheckpoint(ctx context.Context, consumer string) error {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+
+	_, err := s.checkpoints.DeleteOne(ctx, bson.M{"_id": consumer})
+	return err
+}
+
+// LoadSnapshot implements the SnapshotStore interface.
+func (s *MongoDBEventStore) LoadSnapshot(ctx context.Context, consumer string) (*events.Snapshot, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	var snapshot mongoDBSnapshot
+	err := s.snapshots.FindOne(ctx, bson.M{"_id": consumer}).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &events.Snapshot{
+		Consumer: snapshot.Consumer,
+		EventID:  snapshot.EventID,
+		Data:     snapshot.Data,
+		Created:  snapshot.Created.Time(),
+	}, nil
+}
+
+// SaveSnapshot implements the SnapshotStore interface.
+// A document must not exceed 16MB, which limits the size of the snapshot.
+func (s *MongoDBEventStore) SaveSnapshot(ctx context.Context, consumer string, id events.EventID, data []byte) error {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+
+	snapshot := mongoDBSnapshot{
+		Consumer: consumer,
+		EventID:  id,
+		Data:     data,
+		Created:  primitive.NewDateTimeFromTime(time.Now()),
+	}
+	_, err := s.snapshots.ReplaceOne(ctx, bson.M{"_id": consumer}, snapshot, options.Replace().SetUpsert(true))
+	return err
+}
+
+// QuarantineEvent implements the Quarantine interface.
+func (s *MongoDBEventStore) QuarantineEvent(ctx context.Context, id events.EventID, class string, reason string) error {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return err
+	}
+
+	quarantined := mongoDBQuarantined{
+		EventID:     id,
+		Class:       class,
+		Reason:      reason,
+		Quarantined: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	_, err := s.quarantine.ReplaceOne(ctx, bson.M{"_id": id}, quarantined, options.Replace().SetUpsert(true))
+	return err
+}
+
+// ListQuarantined implements the Quarantine interface.
+func (s *MongoDBEventStore) ListQuarantined(ctx context.Context) ([]events.QuarantinedEvent, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.quarantine.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var res []events.QuarantinedEvent
+	for cursor.Next(ctx) {
+		var quarantined mongoDBQuarantined
+		if err := cursor.Decode(&quarantined); err != nil {
+			return nil, err
+		}
+		res = append(res, events.QuarantinedEvent{
+			EventID:     quarantined.EventID,
+			Class:       quarantined.Class,
+			Reason:      quarantined.Reason,
+			Quarantined: quarantined.Quarantined.Time(),
+		})
+	}
+	return res, cursor.Err()
+}
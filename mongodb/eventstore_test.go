@@ -2,7 +2,18 @@ package mongodb
 
 import (
 	"api-broker-prototype/events"
+	"api-broker-prototype/events/storetest"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func TestEnvelope(t *testing.T) {
@@ -16,3 +27,380 @@ func TestNotification(t *testing.T) {
 func TestEventstore(t *testing.T) {
 	var _ events.EventStore = &MongoDBEventStore{}
 }
+
+func TestStreamLoader(t *testing.T) {
+	var _ events.StreamLoader = &MongoDBEventStore{}
+}
+
+func TestCausationLoader(t *testing.T) {
+	var _ events.CausationLoader = &MongoDBEventStore{}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	var _ events.CheckpointStore = &MongoDBEventStore{}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	var _ events.SnapshotStore = &MongoDBEventStore{}
+}
+
+func TestMigrator(t *testing.T) {
+	var _ events.Migrator = &MongoDBEventStore{}
+}
+
+func TestQuarantine(t *testing.T) {
+	var _ events.Quarantine = &MongoDBEventStore{}
+}
+
+// create a store using a fresh DB on the server given by MONGODB_TEST_URI
+// The test is skipped if that environment variable is not set.
+func createStore(t *testing.T, config Config) *MongoDBEventStore {
+	return createStoreWithCodecs(t, config, storetest.Codecs(t))
+}
+
+// create a store like createStore, but using the given codecs
+func createStoreWithCodecs(t *testing.T, config Config, codecs *events.CodecRegistry) *MongoDBEventStore {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	config.URI = uri
+	config.Database = fmt.Sprintf("test_%d", time.Now().UnixNano())
+	config.AutoMigrate = true
+	store, err := NewEventStore(config, codecs)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() {
+		if store.events != nil {
+			store.events.Database().Drop(context.Background())
+		}
+		store.Close()
+	})
+	return store
+}
+
+func TestConcurrentInsert(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	store := createStore(t, Config{})
+
+	const writers = 16
+	const inserts = 50
+
+	// a follower started before the inserts must see every event
+	followed, err := store.FollowEvents(ctx, 0, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// every writer uses its own store, like separate processes would
+	writerStores := make([]*MongoDBEventStore, writers)
+	for i := range writerStores {
+		writerStore, err := NewEventStore(store.config, storetest.Codecs(t))
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		defer writerStore.Close()
+		writerStores[i] = writerStore
+	}
+
+	ids := make([][]events.EventID, writers)
+	var wg sync.WaitGroup
+	for i := 0; i != writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j != inserts; j++ {
+				env, err := writerStores[i].Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0)
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+					return
+				}
+				ids[i] = append(ids[i], env.ID())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// IDs are unique and increase for every writer
+	var inserted []events.EventID
+	for _, list := range ids {
+		for j, id := range list {
+			if j > 0 && id <= list[j-1] {
+				t.Errorf("ID %d not greater than %d", id, list[j-1])
+			}
+		}
+		inserted = append(inserted, list...)
+	}
+	slices.Sort(inserted)
+	if len(slices.Compact(slices.Clone(inserted))) != writers*inserts {
+		t.Fatalf("unexpected number of unique IDs in %v", inserted)
+	}
+
+	// the follower delivers all of them in order, without skipping any
+	var delivered []events.EventID
+	for len(delivered) < len(inserted) {
+		env := <-followed
+		if env == nil {
+			t.Fatalf("follower stopped after %v, error %v", delivered, store.Error())
+		}
+		delivered = append(delivered, env.ID())
+	}
+	if !slices.Equal(delivered, inserted) {
+		t.Errorf("follower delivered %v, expected %v", delivered, inserted)
+	}
+}
+
+func TestFollowEvents(t *testing.T) {
+	for _, mode := range []string{FollowCapped, FollowChangeStream} {
+		t.Run(mode, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			store := createStore(t, Config{FollowMode: mode})
+
+			if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			ch, err := store.FollowEvents(ctx, 0, events.Filter{})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			// existing events are emitted first
+			env := <-ch
+			if env == nil || env.ID() != 1 {
+				t.Fatalf("unexpected envelope %v", env)
+			}
+
+			// new events wake up the follower
+			if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			env = <-ch
+			if env == nil || env.ID() != 2 {
+				t.Fatalf("unexpected envelope %v", env)
+			}
+		})
+	}
+}
+
+func TestLostNotification(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := createStore(t, Config{FollowMode: FollowCapped})
+
+	ch, err := store.FollowEvents(ctx, 0, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// insert an event without writing a notification for it
+	newEvents := []events.NewEvent{{Event: events.SimpleEvent{Message: "lost"}}}
+	payload, schemaVersion, err := store.codecs.Serialize(newEvents[0].Event)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	envs := []mongoDBRawEnvelope{{Class: "simple", SchemaVersion: schemaVersion, Data: bson.M(payload)}}
+	if err := store.insertEnvelopes(ctx, envs, newEvents); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the follower picks it up by polling
+	start := time.Now()
+	env := <-ch
+	if env == nil || env.ID() != envs[0].ID {
+		t.Fatalf("unexpected envelope %v, error %v", env, store.Error())
+	}
+	if elapsed := time.Since(start); elapsed > 3*PollInterval {
+		t.Errorf("event delivered only after %v", elapsed)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	ctx := context.Background()
+	store := createStore(t, Config{})
+	if _, err := store.Migrate(ctx); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !store.transactions {
+		t.Skip("transactions require a replica set")
+	}
+
+	externalUUID := uuid.FromStringOrNil("22428f46-a2d8-4d51-b6b5-bc8551bd0921")
+	first, err := store.Insert(ctx, externalUUID, events.SimpleEvent{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the second event of the batch fails, so the first isn't stored either
+	_, err = store.InsertMany(ctx, []events.NewEvent{
+		{Event: events.SimpleEvent{Message: "rolled back"}, Stream: "a", ExpectedVersion: 0},
+		{Event: events.SimpleEvent{}, ExternalUUID: externalUUID},
+	})
+	if err != events.DuplicateEventUUID {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ch, err := store.LoadEvents(ctx, events.Range{}, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var ids []events.EventID
+	for env := range ch {
+		ids = append(ids, env.ID())
+	}
+	if !slices.Equal(ids, []events.EventID{first.ID()}) {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+
+	// neither the IDs nor the stream version were used up
+	envs, err := store.InsertMany(ctx, []events.NewEvent{{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: 0}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if envs[0].ID() != first.ID()+1 || envs[0].Version() != 1 {
+		t.Errorf("unexpected ID %d and version %d", envs[0].ID(), envs[0].Version())
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) events.EventStore {
+		store := createStore(t, Config{})
+		if err := store.connect(context.Background()); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		// batches need transactions
+		if !store.transactions {
+			t.Skip("MongoDB server doesn't support transactions")
+		}
+		return store
+	})
+}
+
+func TestDecodePolicy(t *testing.T) {
+	storetest.RunDecodePolicy(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, Config{}, codecs)
+	})
+}
+
+func TestEncodings(t *testing.T) {
+	storetest.RunEncodings(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, Config{}, codecs)
+	})
+}
+
+func TestInsertManyWithoutTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := createStore(t, Config{})
+	if err := store.connect(ctx); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if store.transactions {
+		t.Skip("MongoDB server supports transactions")
+	}
+
+	newEvents := []events.NewEvent{{Event: events.SimpleEvent{}}, {Event: events.SimpleEvent{}}}
+	if _, err := store.InsertMany(ctx, newEvents); !errors.Is(err, events.BatchesNotSupported) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestStreamsWithoutTransactions(t *testing.T) {
+	ctx := context.Background()
+	store := createStore(t, Config{})
+	if _, err := store.Migrate(ctx); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if store.transactions {
+		t.Skip("MongoDB server supports transactions")
+	}
+
+	// single events work without transactions, too
+	var envs []events.Envelope
+	for _, newEvent := range []events.NewEvent{
+		{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: 0},
+		{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: 1},
+		{Event: events.SimpleEvent{}, Stream: "b", ExpectedVersion: 0},
+		{Event: events.SimpleEvent{}},
+	} {
+		res, err := store.InsertMany(ctx, []events.NewEvent{newEvent})
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		envs = append(envs, res[0])
+	}
+	expected := []int32{1, 2, 1, 0}
+	for i, env := range envs {
+		if env.Version() != expected[i] {
+			t.Errorf("unexpected version %d of event %d", env.Version(), env.ID())
+		}
+	}
+
+	// a mismatching version fails with a conflict
+	_, err := store.InsertMany(ctx, []events.NewEvent{{Event: events.SimpleEvent{}, Stream: "a", ExpectedVersion: 1}})
+	var conflict *events.VersionConflictError
+	if !errors.As(err, &conflict) || conflict.Actual != 2 {
+		t.Errorf("unexpected error %v", err)
+	}
+	if store.Error() != nil {
+		t.Fatalf("unexpected error state %v", store.Error())
+	}
+
+	ch, err := store.LoadStream(ctx, "a", 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var ids []events.EventID
+	for env := range ch {
+		ids = append(ids, env.ID())
+	}
+	if len(ids) != 2 || ids[0] != envs[0].ID() || ids[1] != envs[1].ID() {
+		t.Errorf("unexpected IDs %v", ids)
+	}
+}
+
+func TestFilterQuery(t *testing.T) {
+	if query := filterQuery(events.Filter{}); len(query) != 0 {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	filter := events.Filter{
+		Classes:       []string{"request"},
+		CausationID:   42,
+		ExternalUUID:  events.Present,
+		CreatedBefore: time.Now(),
+	}
+	query := filterQuery(filter)
+	for _, key := range []string{"class", "causation_id", "external_uuid", "created"} {
+		if _, ok := query[key]; !ok {
+			t.Errorf("missing condition for %s in %v", key, query)
+		}
+	}
+	if query["causation_id"] != events.EventID(42) {
+		t.Errorf("unexpected causation condition %v", query["causation_id"])
+	}
+}
+
+func TestIDQuery(t *testing.T) {
+	if query := idQuery(events.Range{}, 0); len(query) != 0 {
+		t.Errorf("unexpected query %v", query)
+	}
+
+	forward := events.Range{StartAfter: 10, StartBefore: 100}
+	query := idQuery(forward, 20)
+	if query["$gt"] != events.EventID(20) || query["$lt"] != events.EventID(100) {
+		t.Errorf("unexpected forward query %v", query)
+	}
+
+	backward := events.Range{StartAfter: 10, Backward: true}
+	query = idQuery(backward, 0)
+	if query["$gt"] != events.EventID(10) || query["$lt"] != nil {
+		t.Errorf("unexpected backward query %v", query)
+	}
+	query = idQuery(backward, 50)
+	if query["$gt"] != events.EventID(10) || query["$lt"] != events.EventID(50) {
+		t.Errorf("unexpected backward query %v", query)
+	}
+}
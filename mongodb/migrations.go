@@ -0,0 +1,162 @@
+package mongodb
+
+// This file implements creation and migration of the DB schema.
+//
+// MongoDB creates most things implicitly, but it won't create the capped
+// collection for the notifications or the index guaranteeing unique external
+// UUIDs. The applied migrations are recorded as documents in the
+// "schema_version" collection (or the one configured instead). MongoDB
+// doesn't support DDL operations in transactions, so every migration must be
+// idempotent, in order to allow retrying it after a failure.
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Default name of the collection with the applied schema versions.
+const SchemaVersionCollectionName = "schema_version"
+
+// a single migration step
+type migration func(ctx context.Context, db *mongo.Database, config *Config) error
+
+// migrations to apply in order
+// The schema version after applying a migration is its index plus one. Never
+// modify existing entries, only append new ones. The first one is compatible
+// with DBs set up by the docker compose init script.
+var migrations = []migration{
+	// version 1: events and capped notifications collections
+	func(ctx context.Context, db *mongo.Database, config *Config) error {
+		if err := createCollection(ctx, db, config.EventCollection, nil); err != nil {
+			return err
+		}
+		_, err := db.Collection(config.EventCollection).Indexes().CreateOne(
+			ctx,
+			mongo.IndexModel{
+				Keys: bson.M{"external_uuid": 1},
+				Options: options.Index().
+					SetName("unique_external_uuid_constraint").
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"external_uuid": bson.M{"$type": "binData"}}),
+			},
+		)
+		if err != nil {
+			return err
+		}
+
+		opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(1000000)
+		if err := createCollection(ctx, db, config.NotificationCollection, opts); err != nil {
+			return err
+		}
+
+		// Create a document in the notifications collection, which is
+		// necessary, because you can't wait on an empty capped collection.
+		// This is a known bug, see https://jira.mongodb.org/browse/SERVER-13955.
+		_, err = db.Collection(config.NotificationCollection).UpdateOne(
+			ctx,
+			bson.M{"_id": 0},
+			bson.M{"$setOnInsert": bson.M{"_id": 0}},
+			options.Update().SetUpsert(true),
+		)
+		return err
+	},
+	// version 2: counter for allocating event IDs
+	func(ctx context.Context, db *mongo.Database, config *Config) error {
+		return syncCounter(ctx, db.Collection(config.EventCollection), db.Collection(config.CounterCollection))
+	},
+	// version 3: unique versions within streams
+	func(ctx context.Context, db *mongo.Database, config *Config) error {
+		_, err := db.Collection(config.EventCollection).Indexes().CreateOne(
+			ctx,
+			mongo.IndexModel{
+				Keys: bson.D{{Key: "stream", Value: 1}, {Key: "version", Value: 1}},
+				Options: options.Index().
+					SetName("unique_stream_version_constraint").
+					SetUnique(true).
+					SetPartialFilterExpression(bson.M{"stream": bson.M{"$type": "string"}}),
+			},
+		)
+		return err
+	},
+	// version 4: index for looking up the events caused by an event
+	func(ctx context.Context, db *mongo.Database, config *Config) error {
+		_, err := db.Collection(config.EventCollection).Indexes().CreateOne(
+			ctx,
+			mongo.IndexModel{
+				Keys:    bson.M{"causation_id": 1},
+				Options: options.Index().SetName("causation_id_index"),
+			},
+		)
+		return err
+	},
+}
+
+// create a collection unless it exists already
+func createCollection(ctx context.Context, db *mongo.Database, name string, opts *options.CreateCollectionOptions) error {
+	var err error
+	if opts == nil {
+		err = db.CreateCollection(ctx, name)
+	} else {
+		err = db.CreateCollection(ctx, name, opts)
+	}
+
+	// error code 48 is "NamespaceExists"
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 48 {
+		return nil
+	}
+	return err
+}
+
+// Migrate implements the Migrator interface.
+func (s *MongoDBEventStore) Migrate(ctx context.Context) (int, error) {
+	// don't do anything if the error state of the store is set already
+	if err := s.connect(ctx); err != nil {
+		return 0, err
+	}
+
+	return migrate(ctx, s.events.Database(), &s.config)
+}
+
+// apply all pending migrations to the given DB
+func migrate(ctx context.Context, db *mongo.Database, config *Config) (int, error) {
+	versions := db.Collection(config.SchemaVersionCollection)
+
+	// determine current schema version
+	version := 0
+	opts := options.FindOne().SetSort(bson.M{"_id": -1})
+	res := versions.FindOne(ctx, bson.M{}, opts)
+	switch res.Err() {
+	case nil:
+		var doc struct {
+			Version int `bson:"_id"`
+		}
+		if err := res.Decode(&doc); err != nil {
+			return 0, err
+		}
+		version = doc.Version
+	case mongo.ErrNoDocuments:
+		// not an error, no migrations were applied yet
+	default:
+		return 0, res.Err()
+	}
+
+	// apply pending migrations and record the new version after each one
+	for version < len(migrations) {
+		if err := migrations[version](ctx, db, config); err != nil {
+			return version, err
+		}
+		_, err := versions.InsertOne(ctx, bson.M{"_id": version + 1, "applied": time.Now()})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return version, err
+		}
+		version++
+	}
+
+	return version, nil
+}
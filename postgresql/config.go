@@ -0,0 +1,138 @@
+package postgresql
+
+// This file implements the configuration of the connection to the DB.
+//
+// The connection can either be described by a DSN or by individual settings.
+// When both are given, the individual settings override the according parts
+// of the DSN. Without a DSN, defaults matching the docker compose setup are
+// used for settings that are not given.
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaults used when no DSN is given
+const (
+	POSTGRES_HOST     = "localhost"
+	POSTGRES_USER     = "postgres"
+	POSTGRES_PASSWORD = "postgres"
+	POSTGRES_PORT     = "5432"
+	POSTGRES_DATABASE = "postgres"
+)
+
+// Config contains the settings for a PostgreSQLEventStore.
+// For the connection pool settings, zero selects the default of pgxpool.
+type Config struct {
+	// DSN is a connection string, either as URL ("postgres://...") or in
+	// keyword/value form ("host=... user=...").
+	DSN string
+	// Host is the hostname of the DB server.
+	Host string
+	// Port is the TCP port of the DB server.
+	Port string
+	// User is the name of the DB user.
+	User string
+	// Password is the password of the DB user.
+	Password string
+	// PasswordFile is the name of a file containing the password. It takes
+	// precedence over Password. A trailing line break is ignored.
+	PasswordFile string
+	// Database is the name of the DB.
+	Database string
+	// SSLMode is one of the values for libpq's "sslmode" parameter, e.g.
+	// "disable", "require" or "verify-full".
+	SSLMode string
+	// SSLCert is the name of a file containing the client certificate.
+	SSLCert string
+	// SSLKey is the name of a file containing the client certificate's key.
+	SSLKey string
+	// SSLRootCert is the name of a file containing the CA certificates used
+	// to verify the server.
+	SSLRootCert string
+	// AutoMigrate causes the DB schema to be created or updated on the first
+	// connection.
+	AutoMigrate bool
+	// MaxConns is the maximum number of connections in the pool.
+	MaxConns int32
+	// MinConns is the number of connections kept open even when idle.
+	MinConns int32
+	// MaxConnIdleTime is the duration after which an idle connection is closed.
+	MaxConnIdleTime time.Duration
+	// HealthCheckPeriod is the interval between checks of idle connections.
+	HealthCheckPeriod time.Duration
+}
+
+// build the connection string from the configuration
+// Settings that are empty are omitted, so that they are taken from the DSN.
+func (c *Config) connString() (string, error) {
+	password := c.Password
+	if c.PasswordFile != "" {
+		content, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	}
+
+	settings := [][2]string{
+		{"host", c.Host},
+		{"port", c.Port},
+		{"user", c.User},
+		{"password", password},
+		{"dbname", c.Database},
+		{"sslmode", c.SSLMode},
+		{"sslcert", c.SSLCert},
+		{"sslkey", c.SSLKey},
+		{"sslrootcert", c.SSLRootCert},
+	}
+
+	// without a DSN, fall back to the defaults
+	if c.DSN == "" {
+		defaults := []string{POSTGRES_HOST, POSTGRES_PORT, POSTGRES_USER, POSTGRES_PASSWORD, POSTGRES_DATABASE}
+		for i, value := range defaults {
+			if settings[i][1] == "" {
+				settings[i][1] = value
+			}
+		}
+	}
+
+	// For URLs, query parameters override the other parts, so the settings
+	// are added as such.
+	if strings.HasPrefix(c.DSN, "postgres://") || strings.HasPrefix(c.DSN, "postgresql://") {
+		u, err := url.Parse(c.DSN)
+		if err != nil {
+			return "", errors.New("failed to parse DSN")
+		}
+		query := u.Query()
+		for _, setting := range settings {
+			if setting[1] != "" {
+				query.Set(setting[0], setting[1])
+			}
+		}
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+
+	// In keyword/value form, later settings override earlier ones.
+	parts := []string{}
+	if c.DSN != "" {
+		parts = append(parts, c.DSN)
+	}
+	for _, setting := range settings {
+		if setting[1] != "" {
+			parts = append(parts, setting[0]+"="+quoteValue(setting[1]))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// quote a value for use in a keyword/value connection string
+func quoteValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
@@ -3,13 +3,19 @@ package postgresql
 // Eventstore built on top of a PostgreSQL DB
 // This uses Postgre's LISTEN/NOTIFY/pg_notify() feature to efficiently signal
 // new events.
+//
+// Connections are taken from a shared pool. The exception are the connections
+// used to LISTEN for notifications, because those are bound to the follower
+// for its whole lifetime. These are opened separately, outside of the pool.
 
 import (
 	"api-broker-prototype/events"
 	"context"
 	"errors"
-	"fmt"
+	"maps"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
@@ -17,39 +23,24 @@ import (
 	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-const (
-	POSTGRES_USER     = "postgres"
-	POSTGRES_PASSWORD = "postgres"
-	POSTGRES_PORT     = "5432"
-	POSTGRES_DATABASE = "postgres"
-)
-
-// The PostgreSQLEventCodec interface defines methods common to event codecs.
-// The codecs convert between the internal representation (Event) and the
-// general-purpose representation for PostgreSQL (JSONB).
-// See also the Event interface, which it is closely related to.
-type PostgreSQLEventCodec interface {
-	// Class returns a string that identifies the event type this codec handles.
-	Class() string
-	// Serialize the event in a way that allows writing it to a PostgreSQL DB.
-	Serialize(event events.Event) (pgtype.JSONB, error)
-	// Deserialize an event from data from a PostgreSQL DB.
-	Deserialize(data pgtype.JSONB) (events.Event, error)
-}
-
 // postgreSQLEnvelope implements the Envelope interface.
 type postgreSQLEnvelope struct {
-	IDVal           int32
-	ExternalUUIDVal *uuid.UUID
-	CreatedVal      time.Time
-	CausationIDVal  int32
-	EventVal        events.Event
+	IDVal            events.EventID
+	ExternalUUIDVal  *uuid.UUID
+	CreatedVal       time.Time
+	CausationIDVal   events.EventID
+	CorrelationIDVal events.EventID
+	MetadataVal      map[string]string
+	StreamVal        *string
+	VersionVal       *int32
+	EventVal         events.Event
 }
 
 // ID implements the Envelope interface.
-func (env *postgreSQLEnvelope) ID() int32 {
+func (env *postgreSQLEnvelope) ID() events.EventID {
 	return env.IDVal
 }
 
@@ -67,10 +58,36 @@ func (env *postgreSQLEnvelope) ExternalUUID() uuid.UUID {
 }
 
 // CausationID implements the Envelope interface.
-func (env *postgreSQLEnvelope) CausationID() int32 {
+func (env *postgreSQLEnvelope) CausationID() events.EventID {
 	return env.CausationIDVal
 }
 
+// CorrelationID implements the Envelope interface.
+func (env *postgreSQLEnvelope) CorrelationID() events.EventID {
+	return env.CorrelationIDVal
+}
+
+// Metadata implements the Envelope interface.
+func (env *postgreSQLEnvelope) Metadata() map[string]string {
+	return env.MetadataVal
+}
+
+// Stream implements the Envelope interface.
+func (env *postgreSQLEnvelope) Stream() string {
+	if env.StreamVal == nil {
+		return ""
+	}
+	return *env.StreamVal
+}
+
+// Version implements the Envelope interface.
+func (env *postgreSQLEnvelope) Version() int32 {
+	if env.VersionVal == nil {
+		return 0
+	}
+	return *env.VersionVal
+}
+
 // Event implements the Envelope interface.
 func (env *postgreSQLEnvelope) Event() events.Event {
 	return env.EventVal
@@ -78,40 +95,112 @@ func (env *postgreSQLEnvelope) Event() events.Event {
 
 // postgreSQLNotification implements the Notification interface.
 type postgreSQLNotification struct {
-	IDVal int32
+	IDVal events.EventID
 }
 
 // ID implements the Notification interface.
-func (note *postgreSQLNotification) ID() int32 {
+func (note *postgreSQLNotification) ID() events.EventID {
 	return note.IDVal
 }
 
 // PostgreSQLEventStore implements the EventStore interface using a PostgreSQL DB
 type PostgreSQLEventStore struct {
-	host   string
-	codecs map[string]PostgreSQLEventCodec
+	config Config
+	codecs *events.CodecRegistry
 	err    error
+	// mutex guarding the creation of the pool
+	mutex sync.Mutex
+	pool  *pgxpool.Pool
 }
 
 // connect to the PostgreSQL database
-// This will return the created connection instance. It will set the error
-// state of the eventstore instance and return `nil` on failure. Release the
-// returned connection using its `Close()` method.
-func (s *PostgreSQLEventStore) connect(ctx context.Context) *pgx.Conn {
+// This will return the shared connection pool, which is created on first use.
+// It will set the error state of the eventstore instance and return `nil` on
+// failure. The pool is released when the eventstore is closed.
+func (s *PostgreSQLEventStore) connect(ctx context.Context) *pgxpool.Pool {
+	return s.connectPool(ctx, true)
+}
+
+// connect to the PostgreSQL database, see connect()
+// Unless the schema is migrated automatically, creating the pool fails if
+// the schema is outdated and `requireSchema` is set.
+func (s *PostgreSQLEventStore) connectPool(ctx context.Context, requireSchema bool) *pgxpool.Pool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	// do nothing when there's already an error present
 	if s.err != nil {
 		return nil
 	}
+	if s.pool != nil {
+		return s.pool
+	}
 
-	cs := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s",
-		POSTGRES_USER,
-		POSTGRES_PASSWORD,
-		s.host,
-		POSTGRES_PORT,
-		POSTGRES_DATABASE,
-	)
-	conn, err := pgx.Connect(ctx, cs)
+	cs, err := s.config.connString()
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	config, err := pgxpool.ParseConfig(cs)
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	if s.config.MaxConns > 0 {
+		config.MaxConns = s.config.MaxConns
+	}
+	if s.config.MinConns > 0 {
+		config.MinConns = s.config.MinConns
+	}
+	if s.config.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = s.config.MaxConnIdleTime
+	}
+	if s.config.HealthCheckPeriod > 0 {
+		config.HealthCheckPeriod = s.config.HealthCheckPeriod
+	}
+	config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		pgxuuid.Register(conn.TypeMap())
+		return nil
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		s.err = err
+		return nil
+	}
+
+	// apply pending migrations before the pool is used, or at least make
+	// sure that there are none
+	if s.config.AutoMigrate {
+		if _, err := migrate(ctx, pool); err != nil {
+			pool.Close()
+			s.err = err
+			return nil
+		}
+	} else if requireSchema {
+		if err := checkSchema(ctx, pool); err != nil {
+			pool.Close()
+			s.err = err
+			return nil
+		}
+	}
+
+	s.pool = pool
+	return s.pool
+}
+
+// open a dedicated connection listening for notifications
+// This connection is not taken from the pool, because it is held for the
+// whole lifetime of a follower. It will set the error state of the
+// eventstore instance and return `nil` on failure. Release the returned
+// connection using its `Close()` method.
+func (s *PostgreSQLEventStore) listen(ctx context.Context) *pgx.Conn {
+	pool := s.connect(ctx)
+	if pool == nil {
+		return nil
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, pool.Config().ConnConfig)
 	if err != nil {
 		s.err = err
 		return nil
@@ -119,57 +208,48 @@ func (s *PostgreSQLEventStore) connect(ctx context.Context) *pgx.Conn {
 
 	pgxuuid.Register(conn.TypeMap())
 
+	// register as listening to notification channel
+	if _, err := conn.Exec(ctx, "LISTEN notification;"); err != nil {
+		conn.Close(ctx)
+		s.err = err
+		return nil
+	}
+
 	return conn
 }
 
 // NewEventStore creates and connects a PostgreSQLEventStore instance.
-func NewEventStore(host string) (*PostgreSQLEventStore, error) {
+// The codecs are used to convert the events for storage.
+func NewEventStore(config Config, codecs *events.CodecRegistry) (*PostgreSQLEventStore, error) {
+	if codecs == nil {
+		return nil, errors.New("codec registry is nil")
+	}
 	s := PostgreSQLEventStore{
-		host:   host,
-		codecs: make(map[string]PostgreSQLEventCodec),
+		config: config,
+		codecs: codecs,
 		err:    nil,
 	}
 
-	// register codecs
-	s.registerCodec(&simpleEventCodec{})
-	s.registerCodec(&configurationEventCodec{})
-	s.registerCodec(&requestEventCodec{})
-	s.registerCodec(&apiRequestEventCodec{})
-	s.registerCodec(&apiResponseEventCodec{})
-	s.registerCodec(&apiFailureEventCodec{})
-	s.registerCodec(&apiTimeoutEventCodec{})
-
 	return &s, nil
 }
 
-// registerCodec registers a codec that allows conversion of Events.
-func (s *PostgreSQLEventStore) registerCodec(codec PostgreSQLEventCodec) {
-	if codec == nil {
-		s.err = errors.New("nil codec registered")
-		return
-	}
-	s.codecs[codec.Class()] = codec
-}
-
-// decode event from the class name and JSON data
-func (s *PostgreSQLEventStore) decodeEvent(class string, payload pgtype.JSONB) (events.Event, error) {
-	// locate codec for the event class
-	codec := s.codecs[class]
-	if codec == nil {
-		return nil, errors.New("failed to locate codec for event")
+// decode event from the class name, schema version and payload
+// JSON payloads are stored in the `payload` column, others in `payload_data`.
+// Failures are returned as DecodeError.
+func (s *PostgreSQLEventStore) decodeEvent(id events.EventID, class string, schemaVersion int, contentType string, payload pgtype.JSONB, data []byte) (events.Event, error) {
+	if contentType == "" || contentType == events.ContentTypeJSON {
+		data = payload.Bytes
 	}
-
-	// decode event from storage
-	return codec.Deserialize(payload)
+	return s.codecs.Decode(id, class, schemaVersion, contentType, data)
 }
 
 // ParseEventID implements the EventStore interface.
-func (s *PostgreSQLEventStore) ParseEventID(str string) (int32, error) {
-	lp, err := strconv.ParseInt(str, 10, 32)
+func (s *PostgreSQLEventStore) ParseEventID(str string) (events.EventID, error) {
+	lp, err := strconv.ParseInt(str, 10, 64)
 	if err != nil {
 		return 0, err
 	}
-	return int32(lp), nil
+	return events.EventID(lp), nil
 }
 
 // Error implements the EventStore interface.
@@ -177,10 +257,17 @@ func (s *PostgreSQLEventStore) Error() error {
 	return s.err
 }
 
-// Error implements the EventStore and io.Closer interfaces.
+// Close implements the EventStore and io.Closer interfaces.
 // Note that this must correctly release any resources even with the error state set!
 func (s *PostgreSQLEventStore) Close() error {
-	// nothing to do, the eventstore doesn't bind any temporary resources
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// release the pooled connections
+	if s.pool != nil {
+		s.pool.Close()
+		s.pool = nil
+	}
 
 	// set this error to block any further calls
 	s.err = errors.New("eventstore is closed")
@@ -189,60 +276,134 @@ func (s *PostgreSQLEventStore) Close() error {
 }
 
 // Insert implements the EventStore interface.
-func (s *PostgreSQLEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID int32) (events.Envelope, error) {
-	// locate codec for the event class
-	class := event.Class()
-	codec := s.codecs[class]
-	if codec == nil {
-		return nil, errors.New("failed to locate codec for event")
-	}
-
-	// encode event for storage
-	payload, err := codec.Serialize(event)
+func (s *PostgreSQLEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID events.EventID) (events.Envelope, error) {
+	res, err := s.InsertMany(ctx, []events.NewEvent{{ExternalUUID: externalUUID, Event: event, CausationID: causationID}})
 	if err != nil {
 		return nil, err
 	}
+	return res[0], nil
+}
+
+// InsertMany implements the EventStore interface.
+func (s *PostgreSQLEventStore) InsertMany(ctx context.Context, newEvents []events.NewEvent) ([]events.Envelope, error) {
+	// encode events for storage, JSON payloads are stored as JSONB, so they
+	// remain searchable in the DB
+	classes := make([]string, len(newEvents))
+	schemaVersions := make([]int, len(newEvents))
+	contentTypes := make([]string, len(newEvents))
+	payloads := make([]pgtype.JSONB, len(newEvents))
+	payloadData := make([][]byte, len(newEvents))
+	metadata := make([]pgtype.JSONB, len(newEvents))
+	for i, newEvent := range newEvents {
+		classes[i] = newEvent.Event.Class()
+		payload, contentType, schemaVersion, err := s.codecs.Encode(newEvent.Event)
+		if err != nil {
+			return nil, err
+		}
+		schemaVersions[i] = schemaVersion
+		contentTypes[i] = contentType
+		if contentType == events.ContentTypeJSON {
+			payloads[i] = pgtype.JSONB{Bytes: payload, Status: pgtype.Present}
+		} else {
+			payloads[i] = pgtype.JSONB{Status: pgtype.Null}
+			payloadData[i] = payload
+		}
+
+		if metadata[i], err = metadataAsDBValue(newEvent.Metadata); err != nil {
+			return nil, err
+		}
+	}
 
 	// establish connection
-	conn := s.connect(ctx)
-	if conn == nil {
+	pool := s.connect(ctx)
+	if pool == nil {
 		return nil, s.err
 	}
-	defer conn.Close(ctx)
 
-	// init envelope with input values
-	res := postgreSQLEnvelope{
-		ExternalUUIDVal: uuidAsDBValue(externalUUID),
-		CreatedVal:      time.Now(),
-		CausationIDVal:  causationID,
-		EventVal:        event,
+	// insert all events in one transaction
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback(ctx)
+
+	created := time.Now()
+	res := make([]events.Envelope, 0, len(newEvents))
+	for i, newEvent := range newEvents {
+		// init envelope with input values
+		env := &postgreSQLEnvelope{
+			ExternalUUIDVal:  uuidAsDBValue(newEvent.ExternalUUID),
+			CreatedVal:       created,
+			CausationIDVal:   newEvent.CausationID,
+			CorrelationIDVal: newEvent.CorrelationID,
+			MetadataVal:      maps.Clone(newEvent.Metadata),
+			EventVal:         newEvent.Event,
+		}
 
-	// insert the event into the DB
-	row := conn.QueryRow(
-		ctx,
-		`INSERT INTO events (external_uuid, created, causation_id, class, payload) VALUES ($1, $2, $3, $4, $5) RETURNING id;`,
-		res.ExternalUUIDVal,
-		res.CreatedVal,
-		res.CausationIDVal,
-		class,
-		payload,
-	)
+		// determine the version within the stream
+		// The advisory lock serializes concurrent appends to the same stream
+		// until the end of the transaction.
+		if newEvent.Stream != "" {
+			if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2));`, streamLockClass, newEvent.Stream); err != nil {
+				return nil, err
+			}
+			row := tx.QueryRow(
+				ctx,
+				`SELECT COALESCE(MAX(version), 0) FROM events WHERE stream = $1;`,
+				newEvent.Stream,
+			)
+			var current int32
+			if err := row.Scan(&current); err != nil {
+				return nil, err
+			}
+			if err := events.CheckVersion(newEvent.Stream, newEvent.ExpectedVersion, current); err != nil {
+				return nil, err
+			}
+			stream := newEvent.Stream
+			version := current + 1
+			env.StreamVal = &stream
+			env.VersionVal = &version
+		}
 
-	// retrieve assigned ID from response
-	if err = row.Scan(&res.IDVal); err != nil {
-		switch err := err.(type) {
-		case *pgconn.PgError:
-			// check whether the reason is a duplicate UUID
-			if err.Code == "23505" && err.ConstraintName == "events_external_uuid_key" {
-				return nil, events.DuplicateEventUUID
+		// insert the event into the DB
+		row := tx.QueryRow(
+			ctx,
+			`INSERT INTO events (external_uuid, created, causation_id, class, schema_version, content_type, payload, payload_data, stream, version, correlation_id, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id;`,
+			env.ExternalUUIDVal,
+			env.CreatedVal,
+			env.CausationIDVal,
+			classes[i],
+			schemaVersions[i],
+			contentTypes[i],
+			payloads[i],
+			payloadData[i],
+			env.StreamVal,
+			env.VersionVal,
+			env.CorrelationIDVal,
+			metadata[i],
+		)
+
+		// retrieve assigned ID from response
+		if err = row.Scan(&env.IDVal); err != nil {
+			switch err := err.(type) {
+			case *pgconn.PgError:
+				// check whether the reason is a duplicate UUID
+				if err.Code == "23505" && err.ConstraintName == "events_external_uuid_key" {
+					return nil, events.DuplicateEventUUID
+				}
 			}
+			// unable to insert into the 'messages' table.
+			return nil, err
 		}
-		// unable to insert into the 'messages' table.
+		res = append(res, env)
+	}
+
+	// The notifications are only delivered on commit.
+	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
 
-	return &res, nil
+	return res, nil
 }
 
 // convert UUID to a parameter for the DB
@@ -258,28 +419,55 @@ func uuidAsDBValue(val uuid.UUID) *uuid.UUID {
 	return &val
 }
 
+// convert metadata to a parameter for the DB
+// Empty metadata is written as `NULL`.
+func metadataAsDBValue(val map[string]string) (pgtype.JSONB, error) {
+	res := pgtype.JSONB{Status: pgtype.Null}
+	if len(val) == 0 {
+		return res, nil
+	}
+
+	if err := res.Set(val); err != nil {
+		return pgtype.JSONB{}, err
+	}
+	return res, nil
+}
+
+// convert response from the DB to metadata
+// See `metadataAsDBValue()`.
+func dbValueAsMetadata(val pgtype.JSONB) (map[string]string, error) {
+	if val.Status != pgtype.Present {
+		return nil, nil
+	}
+
+	var res map[string]string
+	if err := val.AssignTo(&res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 // ResolveUUID implements the EventStore interface.
-func (s *PostgreSQLEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (int32, error) {
+func (s *PostgreSQLEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (events.EventID, error) {
 	if externalUUID == uuid.Nil {
 		return 0, errors.New("provided external UUID is null")
 	}
 
 	// establish connection
-	conn := s.connect(ctx)
-	if conn == nil {
+	pool := s.connect(ctx)
+	if pool == nil {
 		return 0, s.err
 	}
-	defer conn.Close(ctx)
 
 	// retrieve row from DB
-	row := conn.QueryRow(
+	row := pool.QueryRow(
 		ctx,
 		`SELECT id FROM events WHERE external_uuid = $1;`,
 		externalUUID,
 	)
 
 	// extract field from response
-	var id int32
+	var id events.EventID
 	if err := row.Scan(&id); err != nil {
 		return 0, err
 	}
@@ -288,23 +476,22 @@ func (s *PostgreSQLEventStore) ResolveUUID(ctx context.Context, externalUUID uui
 }
 
 // RetrieveOne implements the EventStore interface.
-func (s *PostgreSQLEventStore) RetrieveOne(ctx context.Context, id int32) (events.Envelope, error) {
+func (s *PostgreSQLEventStore) RetrieveOne(ctx context.Context, id events.EventID) (events.Envelope, error) {
 	// The ID must be valid.
 	if id == 0 {
 		return nil, errors.New("provided document ID is null")
 	}
 
 	// establish connection
-	conn := s.connect(ctx)
-	if conn == nil {
+	pool := s.connect(ctx)
+	if pool == nil {
 		return nil, s.err
 	}
-	defer conn.Close(ctx)
 
 	// retrieve row from DB
-	row := conn.QueryRow(
+	row := pool.QueryRow(
 		ctx,
-		`SELECT external_uuid, created, causation_id, class, payload FROM events WHERE id = $1;`,
+		`SELECT external_uuid, created, causation_id, class, schema_version, content_type, payload, payload_data, stream, version, correlation_id, metadata FROM events WHERE id = $1;`,
 		id,
 	)
 
@@ -313,13 +500,22 @@ func (s *PostgreSQLEventStore) RetrieveOne(ctx context.Context, id int32) (event
 		IDVal: id,
 	}
 	var class string
+	var schemaVersion int
+	var contentType string
 	var payload pgtype.JSONB
-	if err := row.Scan(&res.ExternalUUIDVal, &res.CreatedVal, &res.CausationIDVal, &class, &payload); err != nil {
+	var payloadData []byte
+	var metadata pgtype.JSONB
+	if err := row.Scan(&res.ExternalUUIDVal, &res.CreatedVal, &res.CausationIDVal, &class, &schemaVersion, &contentType, &payload, &payloadData, &res.StreamVal, &res.VersionVal, &res.CorrelationIDVal, &metadata); err != nil {
 		return nil, err
 	}
+	if md, err := dbValueAsMetadata(metadata); err != nil {
+		return nil, err
+	} else {
+		res.MetadataVal = md
+	}
 
 	// decode event
-	if ev, err := s.decodeEvent(class, payload); err != nil {
+	if ev, err := s.decodeEvent(id, class, schemaVersion, contentType, payload, payloadData); err != nil {
 		return nil, err
 	} else {
 		res.EventVal = ev
@@ -327,53 +523,236 @@ func (s *PostgreSQLEventStore) RetrieveOne(ctx context.Context, id int32) (event
 	}
 }
 
+// pump events in the given range into the channel
+// Only events selected by the filter are emitted. This returns the ID of the
+// last event emitted, or the start of the range if none was emitted. In case
+// of failure, it sets the error state of the store.
+func (s *PostgreSQLEventStore) pumpEvents(ctx context.Context, pool *pgxpool.Pool, bounds events.Range, filter events.Filter, out chan<- events.Envelope) (events.EventID, error) {
+	// retrieve rows from DB
+	startAfter := bounds.StartAfter
+	conditions, args := filterConditions(filter, []any{startAfter})
+	conditions, order, args := rangeClauses(bounds, conditions, args)
+	rows, err := pool.Query(
+		ctx,
+		`SELECT id, external_uuid, created, causation_id, class, schema_version, content_type, payload, payload_data, stream, version, correlation_id, metadata FROM events WHERE id > $1`+conditions+order+`;`,
+		args...,
+	)
+	if err != nil {
+		s.err = err
+		return startAfter, err
+	}
+
+	last, err := s.pumpRows(ctx, rows, out)
+	if last == 0 {
+		last = startAfter
+	}
+	return last, err
+}
+
+// pump the events from the query result into the channel
+// The rows must contain the columns `id`, `external_uuid`, `created`,
+// `causation_id`, `class`, `schema_version`, `content_type`, `payload`,
+// `payload_data`, `stream`, `version`, `correlation_id` and `metadata` in
+// that order.
+// This closes the rows and returns the ID of the last event emitted or
+// skipped, or zero if there was none. In case of failure, it sets the error
+// state of the store.
+// If the context is cancelled while waiting for the receiver, the rows are
+// closed as well, so the connection is returned to the pool.
+func (s *PostgreSQLEventStore) pumpRows(ctx context.Context, rows pgx.Rows, out chan<- events.Envelope) (events.EventID, error) {
+	defer rows.Close()
+
+	var last events.EventID
+	for rows.Next() {
+		// extract fields from response
+		var res postgreSQLEnvelope
+		var class string
+		var schemaVersion int
+		var contentType string
+		var payload pgtype.JSONB
+		var payloadData []byte
+		var metadata pgtype.JSONB
+		if err := rows.Scan(&res.IDVal, &res.ExternalUUIDVal, &res.CreatedVal, &res.CausationIDVal, &class, &schemaVersion, &contentType, &payload, &payloadData, &res.StreamVal, &res.VersionVal, &res.CorrelationIDVal, &metadata); err != nil {
+			s.err = err
+			return last, err
+		}
+		if md, err := dbValueAsMetadata(metadata); err != nil {
+			s.err = err
+			return last, err
+		} else {
+			res.MetadataVal = md
+		}
+
+		// decode event, undecodable events are handled according to the
+		// decode policy. Note that quarantining an event takes a second
+		// connection from the pool while the rows are still open.
+		if ev, err := s.decodeEvent(res.IDVal, class, schemaVersion, contentType, payload, payloadData); err != nil {
+			if err := s.codecs.HandleDecodeError(ctx, err, s); err != nil {
+				s.err = err
+				return last, err
+			}
+			// move past the skipped event
+			last = res.IDVal
+			continue
+		} else {
+			res.EventVal = ev
+		}
+
+		select {
+		case <-ctx.Done():
+			return last, ctx.Err()
+		case out <- &res:
+		}
+
+		// remember new position in stream
+		last = res.IDVal
+	}
+	if err := rows.Err(); err != nil {
+		s.err = err
+		return last, err
+	}
+
+	return last, nil
+}
+
+// build the SQL conditions for the filter
+// This returns the conditions, each with a leading "AND", and the arguments
+// extended by the values referenced by the conditions.
+func filterConditions(filter events.Filter, args []any) (string, []any) {
+	var conditions strings.Builder
+	param := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(filter.Classes) > 0 {
+		conditions.WriteString(" AND class = ANY(" + param(filter.Classes) + ")")
+	}
+	if filter.CausationID != 0 {
+		conditions.WriteString(" AND causation_id = " + param(filter.CausationID))
+	}
+	switch filter.ExternalUUID {
+	case events.Present:
+		conditions.WriteString(" AND external_uuid IS NOT NULL")
+	case events.Absent:
+		conditions.WriteString(" AND external_uuid IS NULL")
+	}
+	// The creation time is stored without time zone, like it is passed on
+	// insert, i.e. in local time.
+	if !filter.CreatedSince.IsZero() {
+		conditions.WriteString(" AND created >= " + param(filter.CreatedSince.Local()))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions.WriteString(" AND created < " + param(filter.CreatedBefore.Local()))
+	}
+	return conditions.String(), args
+}
+
+// build the SQL clauses for the range, apart from its start
+// This returns the conditions extended by the upper bound, the ORDER BY and
+// LIMIT clauses and the arguments extended by the values they reference.
+func rangeClauses(bounds events.Range, conditions string, args []any) (string, string, []any) {
+	if bounds.StartBefore != 0 {
+		args = append(args, bounds.StartBefore)
+		conditions += " AND id < $" + strconv.Itoa(len(args))
+	}
+	order := " ORDER BY id"
+	if bounds.Backward {
+		order += " DESC"
+	}
+	if bounds.Limit > 0 {
+		order += " LIMIT " + strconv.Itoa(bounds.Limit)
+	}
+	return conditions, order, args
+}
+
 // LoadEvents implements the EventStore interface.
-func (s *PostgreSQLEventStore) LoadEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
+func (s *PostgreSQLEventStore) LoadEvents(ctx context.Context, bounds events.Range, filter events.Filter) (<-chan events.Envelope, error) {
 	// establish connection
-	conn := s.connect(ctx)
-	if conn == nil {
+	pool := s.connect(ctx)
+	if pool == nil {
 		return nil, s.err
 	}
 
 	// run code to pump events in a goroutine
 	out := make(chan events.Envelope)
 	go func() {
-		// close connection on finish
-		defer conn.Close(ctx)
 		// close channel on finish
 		defer close(out)
 
-		// retrieve rows from DB
-		rows, err := conn.Query(
-			ctx,
-			`SELECT id, external_uuid, created, causation_id, class, payload FROM events WHERE id > $1;`,
-			startAfter,
+		s.pumpEvents(ctx, pool, bounds, filter, out)
+	}()
+
+	return out, nil
+}
+
+// LoadCaused implements the CausationLoader interface.
+func (s *PostgreSQLEventStore) LoadCaused(ctx context.Context, id events.EventID) (<-chan events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return nil, s.err
+	}
+
+	// retrieve rows from DB
+	// The tree is collected recursively using the index on `causation_id`.
+	rows, err := pool.Query(
+		ctx,
+		`WITH RECURSIVE tree (id) AS (
+			SELECT id FROM events WHERE causation_id = $1
+			UNION
+			SELECT events.id FROM events JOIN tree ON events.causation_id = tree.id
 		)
-		if err != nil {
-			s.err = err
-			return
-		}
+		SELECT id, external_uuid, created, causation_id, class, schema_version, content_type, payload, payload_data, stream, version, correlation_id, metadata FROM events WHERE id IN (SELECT id FROM tree) ORDER BY id;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-		for rows.Next() {
-			// extract fields from response
-			var res postgreSQLEnvelope
-			var class string
-			var payload pgtype.JSONB
-			if err := rows.Scan(&res.IDVal, &res.ExternalUUIDVal, &res.CreatedVal, &res.CausationIDVal, &class, &payload); err != nil {
-				s.err = err
-				return
-			}
+	// run code to pump events in a goroutine
+	out := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(out)
 
-			// decode event
-			if ev, err := s.decodeEvent(class, payload); err != nil {
-				s.err = err
-				return
-			} else {
-				res.EventVal = ev
-			}
+		s.pumpRows(ctx, rows, out)
+	}()
 
-			out <- &res
-		}
+	return out, nil
+}
+
+// LoadStream implements the StreamLoader interface.
+func (s *PostgreSQLEventStore) LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan events.Envelope, error) {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return nil, s.err
+	}
+
+	// retrieve rows from DB
+	rows, err := pool.Query(
+		ctx,
+		`SELECT id, external_uuid, created, causation_id, class, schema_version, content_type, payload, payload_data, stream, version, correlation_id, metadata FROM events WHERE stream = $1 AND version >= $2 ORDER BY version;`,
+		stream,
+		fromVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// run code to pump events in a goroutine
+	out := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		s.pumpRows(ctx, rows, out)
 	}()
 
 	return out, nil
@@ -382,7 +761,7 @@ func (s *PostgreSQLEventStore) LoadEvents(ctx context.Context, startAfter int32)
 // FollowNotifications implements the EventStore interface.
 func (s *PostgreSQLEventStore) FollowNotifications(ctx context.Context) (<-chan events.Notification, error) {
 	// establish connection
-	conn := s.connect(ctx)
+	conn := s.listen(ctx)
 	if conn == nil {
 		return nil, s.err
 	}
@@ -395,16 +774,6 @@ func (s *PostgreSQLEventStore) FollowNotifications(ctx context.Context) (<-chan
 		// close channel on finish
 		defer close(out)
 
-		// register as listening to notification channel
-		_, err := conn.Exec(
-			ctx,
-			"LISTEN notification;",
-		)
-		if err != nil {
-			s.err = err
-			return
-		}
-
 		// wait for notifications
 		for {
 			notification, err := conn.WaitForNotification(ctx)
@@ -419,8 +788,10 @@ func (s *PostgreSQLEventStore) FollowNotifications(ctx context.Context) (<-chan
 				s.err = err
 				return
 			}
-			out <- &postgreSQLNotification{
-				IDVal: id,
+			select {
+			case <-ctx.Done():
+				return
+			case out <- &postgreSQLNotification{IDVal: id}:
 			}
 		}
 	}()
@@ -429,12 +800,19 @@ func (s *PostgreSQLEventStore) FollowNotifications(ctx context.Context) (<-chan
 }
 
 // FollowEvents implements the EventStore interface.
-func (s *PostgreSQLEventStore) FollowEvents(ctx context.Context, startAfter int32) (<-chan events.Envelope, error) {
+func (s *PostgreSQLEventStore) FollowEvents(ctx context.Context, startAfter events.EventID, filter events.Filter) (<-chan events.Envelope, error) {
 	// establish connection
-	conn := s.connect(ctx)
+	// Note that the connection starts listening before the existing events
+	// are queried, so that no notification is missed in between.
+	conn := s.listen(ctx)
 	if conn == nil {
 		return nil, s.err
 	}
+	pool := s.connect(ctx)
+	if pool == nil {
+		conn.Close(ctx)
+		return nil, s.err
+	}
 
 	// run code to pump events in a goroutine
 	out := make(chan events.Envelope)
@@ -444,51 +822,12 @@ func (s *PostgreSQLEventStore) FollowEvents(ctx context.Context, startAfter int3
 		// close channel on finish
 		defer close(out)
 
-		// register as listening to notification channel
-		_, err := conn.Exec(
-			ctx,
-			"LISTEN notification;",
-		)
-		if err != nil {
-			s.err = err
-			return
-		}
-
 		for {
-			// retrieve rows from DB
-			rows, err := conn.Query(
-				ctx,
-				`SELECT id, external_uuid, created, causation_id, class, payload FROM events WHERE id > $1;`,
-				startAfter,
-			)
+			id, err := s.pumpEvents(ctx, pool, events.Range{StartAfter: startAfter}, filter, out)
 			if err != nil {
-				s.err = err
 				return
 			}
-
-			for rows.Next() {
-				// extract fields from response
-				var res postgreSQLEnvelope
-				var class string
-				var payload pgtype.JSONB
-				if err := rows.Scan(&res.IDVal, &res.ExternalUUIDVal, &res.CreatedVal, &res.CausationIDVal, &class, &payload); err != nil {
-					s.err = err
-					return
-				}
-
-				// decode event
-				if ev, err := s.decodeEvent(class, payload); err != nil {
-					s.err = err
-					return
-				} else {
-					res.EventVal = ev
-				}
-
-				out <- &res
-
-				// remember new position in stream
-				startAfter = res.IDVal
-			}
+			startAfter = id
 
 			// wait for notifications of new events
 			_, err = conn.WaitForNotification(ctx)
@@ -501,3 +840,159 @@ func (s *PostgreSQLEventStore) FollowEvents(ctx context.Context, startAfter int3
 
 	return out, nil
 }
+
+// LoadCheckpoint implements the CheckpointStore interface.
+func (s *PostgreSQLEventStore) LoadCheckpoint(ctx context.Context, consumer string) (events.EventID, error) {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return 0, s.err
+	}
+
+	var id events.EventID
+	row := pool.QueryRow(ctx, `SELECT event_id FROM checkpoints WHERE consumer = $1;`, consumer)
+	if err := row.Scan(&id); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+	return id, nil
+}
+
+// SaveCheckpoint implements the CheckpointStore interface.
+func (s *PostgreSQLEventStore) SaveCheckpoint(ctx context.Context, consumer string, id events.EventID) error {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return s.err
+	}
+
+	_, err := pool.Exec(
+		ctx,
+		`INSERT INTO checkpoints (consumer, event_id, updated) VALUES ($1, $2, $3)
+		ON CONFLICT (consumer) DO UPDATE SET event_id = excluded.event_id, updated = excluded.updated;`,
+		consumer,
+		id,
+		time.Now(),
+	)
+	return err
+}
+
+// ListCheckpoints implements the CheckpointStore interface.
+func (s *PostgreSQLEventStore) ListCheckpoints(ctx context.Context) ([]events.Checkpoint, error) {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return nil, s.err
+	}
+
+	rows, err := pool.Query(ctx, `SELECT consumer, event_id, updated FROM checkpoints ORDER BY consumer;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []events.Checkpoint
+	for rows.Next() {
+		var checkpoint events.Checkpoint
+		if err := rows.Scan(&checkpoint.Consumer, &checkpoint.EventID, &checkpoint.Updated); err != nil {
+			return nil, err
+		}
+		res = append(res, checkpoint)
+	}
+	return res, rows.Err()
+}
+
+// ResetCheckpoint implements the CheckpointStore interface.
+func (s *PostgreSQLEventStore) ResetCheckpoint(ctx context.Context, consumer string) error {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return s.err
+	}
+
+	_, err := pool.Exec(ctx, `DELETE FROM checkpoints WHERE consumer = $1;`, consumer)
+	return err
+}
+
+// LoadSnapshot implements the SnapshotStore interface.
+func (s *PostgreSQLEventStore) LoadSnapshot(ctx context.Context, consumer string) (*events.Snapshot, error) {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return nil, s.err
+	}
+
+	snapshot := events.Snapshot{Consumer: consumer}
+	row := pool.QueryRow(ctx, `SELECT event_id, data, created FROM snapshots WHERE consumer = $1;`, consumer)
+	if err := row.Scan(&snapshot.EventID, &snapshot.Data, &snapshot.Created); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// SaveSnapshot implements the SnapshotStore interface.
+func (s *PostgreSQLEventStore) SaveSnapshot(ctx context.Context, consumer string, id events.EventID, data []byte) error {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return s.err
+	}
+
+	_, err := pool.Exec(
+		ctx,
+		`INSERT INTO snapshots (consumer, event_id, data, created) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (consumer) DO UPDATE SET event_id = excluded.event_id, data = excluded.data, created = excluded.created;`,
+		consumer,
+		id,
+		data,
+		time.Now(),
+	)
+	return err
+}
+
+// QuarantineEvent implements the Quarantine interface.
+func (s *PostgreSQLEventStore) QuarantineEvent(ctx context.Context, id events.EventID, class string, reason string) error {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return s.err
+	}
+
+	_, err := pool.Exec(
+		ctx,
+		`INSERT INTO quarantine (event_id, class, reason, quarantined) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_id) DO UPDATE SET class = excluded.class, reason = excluded.reason, quarantined = excluded.quarantined;`,
+		id,
+		class,
+		reason,
+		time.Now(),
+	)
+	return err
+}
+
+// ListQuarantined implements the Quarantine interface.
+func (s *PostgreSQLEventStore) ListQuarantined(ctx context.Context) ([]events.QuarantinedEvent, error) {
+	// establish connection
+	pool := s.connect(ctx)
+	if pool == nil {
+		return nil, s.err
+	}
+
+	rows, err := pool.Query(ctx, `SELECT event_id, class, reason, quarantined FROM quarantine ORDER BY event_id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []events.QuarantinedEvent
+	for rows.Next() {
+		var event events.QuarantinedEvent
+		if err := rows.Scan(&event.EventID, &event.Class, &event.Reason, &event.Quarantined); err != nil {
+			return nil, err
+		}
+		res = append(res, event)
+	}
+	return res, rows.Err()
+}
@@ -2,7 +2,17 @@ package postgresql
 
 import (
 	"api-broker-prototype/events"
+	"api-broker-prototype/events/storetest"
+	"context"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v5"
 )
 
 func TestEnvelope(t *testing.T) {
@@ -16,3 +26,189 @@ func TestNotification(t *testing.T) {
 func TestEventstore(t *testing.T) {
 	var _ events.EventStore = &PostgreSQLEventStore{}
 }
+
+func TestStreamLoader(t *testing.T) {
+	var _ events.StreamLoader = &PostgreSQLEventStore{}
+}
+
+func TestCausationLoader(t *testing.T) {
+	var _ events.CausationLoader = &PostgreSQLEventStore{}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	var _ events.CheckpointStore = &PostgreSQLEventStore{}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	var _ events.SnapshotStore = &PostgreSQLEventStore{}
+}
+
+func TestMigrator(t *testing.T) {
+	var _ events.Migrator = &PostgreSQLEventStore{}
+}
+
+func TestQuarantine(t *testing.T) {
+	var _ events.Quarantine = &PostgreSQLEventStore{}
+}
+
+// create a store using a fresh DB on the server given by POSTGRESQL_TEST_DSN
+// The test is skipped if that environment variable is not set.
+func createStore(t *testing.T, config Config) *PostgreSQLEventStore {
+	return createStoreWithCodecs(t, config, storetest.Codecs(t))
+}
+
+// create a store like createStore, but using the given codecs
+func createStoreWithCodecs(t *testing.T, config Config, codecs *events.CodecRegistry) *PostgreSQLEventStore {
+	dsn := os.Getenv("POSTGRESQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRESQL_TEST_DSN not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	database := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE DATABASE "+database+";"); err != nil {
+		conn.Close(ctx)
+		t.Fatalf("failed to create DB: %v", err)
+	}
+
+	config.DSN = dsn
+	config.Database = database
+	store, err := NewEventStore(config, codecs)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		conn.Exec(ctx, "DROP DATABASE "+database+" WITH (FORCE);")
+		conn.Close(ctx)
+	})
+	return store
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) events.EventStore {
+		return createStore(t, Config{AutoMigrate: true})
+	})
+}
+
+func TestDecodePolicy(t *testing.T) {
+	storetest.RunDecodePolicy(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, Config{AutoMigrate: true}, codecs)
+	})
+}
+
+func TestEncodings(t *testing.T) {
+	storetest.RunEncodings(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, Config{AutoMigrate: true}, codecs)
+	})
+}
+
+func TestFollowEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	store := createStore(t, Config{AutoMigrate: true})
+
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ch, err := store.FollowEvents(ctx, 0, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// existing events are emitted first
+	env := <-ch
+	if env == nil || env.ID() != 1 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+
+	// new events wake up the follower
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env = <-ch
+	if env == nil || env.ID() != 2 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+}
+
+func TestOutdatedSchema(t *testing.T) {
+	ctx := context.Background()
+	store := createStore(t, Config{})
+
+	// without the migrations, operations fail early
+	_, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0)
+	if err == nil || !strings.Contains(err.Error(), "outdated") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	// empty metadata is stored as NULL
+	val, err := metadataAsDBValue(map[string]string{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if val.Status != pgtype.Null {
+		t.Errorf("unexpected status %v", val.Status)
+	}
+	if res, err := dbValueAsMetadata(val); err != nil || res != nil {
+		t.Errorf("unexpected metadata %v (error %v)", res, err)
+	}
+
+	val, err = metadataAsDBValue(map[string]string{"actor": "alice"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	res, err := dbValueAsMetadata(val)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(res) != 1 || res["actor"] != "alice" {
+		t.Errorf("unexpected metadata %v", res)
+	}
+}
+
+func TestFilterConditions(t *testing.T) {
+	conditions, args := filterConditions(events.Filter{}, []any{events.EventID(0)})
+	if conditions != "" || len(args) != 1 {
+		t.Errorf("unexpected conditions %q with %v", conditions, args)
+	}
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := events.Filter{
+		Classes:      []string{"request", "api-request"},
+		CausationID:  42,
+		ExternalUUID: events.Absent,
+		CreatedSince: since,
+	}
+	conditions, args = filterConditions(filter, []any{events.EventID(0)})
+	expected := " AND class = ANY($2) AND causation_id = $3 AND external_uuid IS NULL AND created >= $4"
+	if conditions != expected {
+		t.Errorf("unexpected conditions %q", conditions)
+	}
+	if len(args) != 4 || args[2] != events.EventID(42) || !args[3].(time.Time).Equal(since) {
+		t.Errorf("unexpected arguments %v", args)
+	}
+}
+
+func TestRangeClauses(t *testing.T) {
+	conditions, order, args := rangeClauses(events.Range{}, "", []any{events.EventID(0)})
+	if conditions != "" || order != " ORDER BY id" || len(args) != 1 {
+		t.Errorf("unexpected clauses %q %q with %v", conditions, order, args)
+	}
+
+	bounds := events.Range{StartBefore: 100, Limit: 50, Backward: true}
+	conditions, order, args = rangeClauses(bounds, " AND causation_id = $2", []any{events.EventID(0), events.EventID(42)})
+	if conditions != " AND causation_id = $2 AND id < $3" || order != " ORDER BY id DESC LIMIT 50" {
+		t.Errorf("unexpected clauses %q %q", conditions, order)
+	}
+	if len(args) != 3 || args[2] != events.EventID(100) {
+		t.Errorf("unexpected arguments %v", args)
+	}
+}
@@ -0,0 +1,202 @@
+package postgresql
+
+// This file implements creation and migration of the DB schema.
+//
+// The applied migrations are recorded in the `schema_version` table. Each
+// migration runs in a transaction together with the update of that table,
+// so a failed migration doesn't leave a partially migrated schema behind.
+// An advisory lock makes sure that only one process migrates at a time.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// key for the advisory lock taken while migrating
+const migrationLockKey = 0x6576656e7473
+
+// first part of the keys for the advisory locks taken while appending to a
+// stream, the second part is a hash of the stream name
+const streamLockClass = 0x73747265
+
+// migrations to apply in order
+// The schema version after applying a migration is its index plus one. Never
+// modify existing entries, only append new ones. The first one is compatible
+// with DBs set up by the docker compose init script.
+var migrations = []string{
+	// version 1: events table and notification trigger
+	`CREATE TABLE IF NOT EXISTS events (
+		id SERIAL PRIMARY KEY,
+		external_uuid UUID UNIQUE,
+		created timestamp NOT NULL,
+		causation_id INTEGER NOT NULL,
+		class TEXT NOT NULL,
+		payload JSONB NOT NULL
+	);
+	CREATE OR REPLACE FUNCTION emit_notification ()
+		RETURNS TRIGGER
+		LANGUAGE plpgsql
+		AS $$
+		begin
+			PERFORM pg_notify('notification', CAST(NEW.id AS text));
+			RETURN NULL;
+		end
+		$$;
+	DROP TRIGGER IF EXISTS on_insert ON events;
+	CREATE TRIGGER on_insert
+		AFTER INSERT ON events
+		FOR EACH ROW
+		EXECUTE FUNCTION emit_notification();`,
+	// version 2: streams
+	// Events without a stream have `NULL` in both columns, which the unique
+	// index ignores.
+	`ALTER TABLE events
+		ADD COLUMN IF NOT EXISTS stream TEXT,
+		ADD COLUMN IF NOT EXISTS version INTEGER;
+	CREATE UNIQUE INDEX IF NOT EXISTS events_stream_version_key ON events (stream, version);`,
+	// version 3: correlation ID and metadata
+	`ALTER TABLE events
+		ADD COLUMN IF NOT EXISTS correlation_id INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS metadata JSONB;`,
+	// version 4: 64-bit event IDs
+	// This rewrites the table, which can take a while for large tables.
+	`ALTER TABLE events
+		ALTER COLUMN id TYPE BIGINT,
+		ALTER COLUMN causation_id TYPE BIGINT,
+		ALTER COLUMN correlation_id TYPE BIGINT;
+	ALTER SEQUENCE events_id_seq AS BIGINT;`,
+	// version 5: index for looking up the events caused by an event
+	`CREATE INDEX IF NOT EXISTS events_causation_id_idx ON events (causation_id);`,
+	// version 6: consumer checkpoints
+	`CREATE TABLE IF NOT EXISTS checkpoints (
+		consumer TEXT PRIMARY KEY,
+		event_id BIGINT NOT NULL,
+		updated timestamp NOT NULL
+	);`,
+	// version 7: snapshots of consumer state
+	`CREATE TABLE IF NOT EXISTS snapshots (
+		consumer TEXT PRIMARY KEY,
+		event_id BIGINT NOT NULL,
+		data BYTEA NOT NULL,
+		created timestamp NOT NULL
+	);`,
+	// version 8: schema version of the event payload
+	// Events stored before have the first version.
+	`ALTER TABLE events ADD COLUMN IF NOT EXISTS schema_version INTEGER NOT NULL DEFAULT 1;`,
+	// version 9: quarantine of undecodable events
+	`CREATE TABLE IF NOT EXISTS quarantine (
+		event_id BIGINT PRIMARY KEY,
+		class TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		quarantined timestamp NOT NULL
+	);`,
+	// version 10: content type of the event payload
+	// Events stored before are JSON. JSON payloads remain in the `payload`
+	// column, others are stored in `payload_data` instead.
+	`ALTER TABLE events
+		ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'application/json',
+		ADD COLUMN IF NOT EXISTS payload_data BYTEA,
+		ALTER COLUMN payload DROP NOT NULL;`,
+}
+
+// Migrate implements the Migrator interface.
+func (s *PostgreSQLEventStore) Migrate(ctx context.Context) (int, error) {
+	// establish connection, which must not require the schema to be up to date
+	pool := s.connectPool(ctx, false)
+	if pool == nil {
+		return 0, s.err
+	}
+
+	return migrate(ctx, pool)
+}
+
+// apply all pending migrations using the given connection pool
+func migrate(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	_, err := pool.Exec(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			applied timestamp NOT NULL
+		);`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		done, version, err := migrateStep(ctx, pool)
+		if err != nil || done {
+			return version, err
+		}
+	}
+}
+
+// apply the next pending migration, if any
+// This returns whether the schema is up to date and the resulting version.
+func migrateStep(ctx context.Context, pool *pgxpool.Pool) (bool, int, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// serialize concurrent migrations
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1);`, migrationLockKey); err != nil {
+		return false, 0, err
+	}
+
+	// determine current schema version
+	var version int
+	row := tx.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version;`)
+	if err := row.Scan(&version); err != nil {
+		return false, 0, err
+	}
+	if version >= len(migrations) {
+		return true, version, nil
+	}
+
+	// apply migration and record the new version
+	if _, err := tx.Exec(ctx, migrations[version]); err != nil {
+		return false, version, err
+	}
+	version++
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO schema_version (version, applied) VALUES ($1, $2);`,
+		version,
+		time.Now(),
+	)
+	if err != nil {
+		return false, version - 1, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, version - 1, err
+	}
+	return false, version, nil
+}
+
+// check that all migrations were applied
+// The queries use the columns added by all migrations, so every operation
+// would fail on an outdated schema. This reports that early and clearly.
+func checkSchema(ctx context.Context, pool *pgxpool.Pool) error {
+	var version int
+	row := pool.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version;`)
+	if err := row.Scan(&version); err != nil {
+		// error code 42P01 is "undefined_table", i.e. no migrations were
+		// applied yet
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "42P01" {
+			return err
+		}
+	}
+	if version < len(migrations) {
+		return fmt.Errorf("DB schema version %d is outdated, version %d is required, run the migrations first", version, len(migrations))
+	}
+	return nil
+}
@@ -0,0 +1,1041 @@
+package sqlite
+
+// Eventstore built on top of an SQLite DB
+// This stores all events in a single local file, so it doesn't require a DB
+// server. SQLite doesn't have a way to signal changes to other connections.
+// Instead, followers are woken up directly by inserts from the same process
+// and additionally poll the DB in order to pick up inserts from other
+// processes.
+
+import (
+	"api-broker-prototype/events"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// PollInterval is the time between two checks for events inserted by other
+// processes.
+const PollInterval = 1 * time.Second
+
+// sqliteEnvelope implements the Envelope interface.
+type sqliteEnvelope struct {
+	IDVal            events.EventID
+	ExternalUUIDVal  uuid.UUID
+	CreatedVal       time.Time
+	CausationIDVal   events.EventID
+	CorrelationIDVal events.EventID
+	MetadataVal      map[string]string
+	StreamVal        string
+	VersionVal       int32
+	EventVal         events.Event
+}
+
+// ID implements the Envelope interface.
+func (env *sqliteEnvelope) ID() events.EventID {
+	return env.IDVal
+}
+
+// Created implements the Envelope interface.
+func (env *sqliteEnvelope) Created() time.Time {
+	return env.CreatedVal
+}
+
+// ExternalUUID implements the Envelope interface.
+func (env *sqliteEnvelope) ExternalUUID() uuid.UUID {
+	return env.ExternalUUIDVal
+}
+
+// CausationID implements the Envelope interface.
+func (env *sqliteEnvelope) CausationID() events.EventID {
+	return env.CausationIDVal
+}
+
+// CorrelationID implements the Envelope interface.
+func (env *sqliteEnvelope) CorrelationID() events.EventID {
+	return env.CorrelationIDVal
+}
+
+// Metadata implements the Envelope interface.
+func (env *sqliteEnvelope) Metadata() map[string]string {
+	return env.MetadataVal
+}
+
+// Stream implements the Envelope interface.
+func (env *sqliteEnvelope) Stream() string {
+	return env.StreamVal
+}
+
+// Version implements the Envelope interface.
+func (env *sqliteEnvelope) Version() int32 {
+	return env.VersionVal
+}
+
+// Event implements the Envelope interface.
+func (env *sqliteEnvelope) Event() events.Event {
+	return env.EventVal
+}
+
+// sqliteNotification implements the Notification interface.
+type sqliteNotification struct {
+	IDVal events.EventID
+}
+
+// ID implements the Notification interface.
+func (note *sqliteNotification) ID() events.EventID {
+	return note.IDVal
+}
+
+// SQLiteEventStore implements the EventStore interface using an SQLite DB
+type SQLiteEventStore struct {
+	path   string
+	db     *sql.DB
+	codecs *events.CodecRegistry
+	err    error
+	// mutex guarding the fields below
+	mutex sync.Mutex
+	// channel that is closed in order to wake up waiting followers
+	signal chan struct{}
+}
+
+// connect to the SQLite database
+// This opens the DB file on demand and migrates the schema in it. It will set
+// the error state of the eventstore instance and return `nil` on failure.
+func (s *SQLiteEventStore) connect(ctx context.Context) *sql.DB {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// do nothing when there's already an error present
+	if s.err != nil {
+		return nil
+	}
+	if s.db != nil {
+		return s.db
+	}
+
+	// Use the write-ahead log, which allows readers to proceed while a
+	// write is in progress, and wait for locks held by other connections
+	// instead of failing immediately.
+	params := url.Values{
+		"_pragma": []string{"busy_timeout(5000)", "journal_mode(WAL)"},
+		"_txlock": []string{"immediate"},
+	}
+	db, err := sql.Open("sqlite", s.path+"?"+params.Encode())
+	if err != nil {
+		s.err = err
+		return nil
+	}
+
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		s.err = err
+		return nil
+	}
+
+	s.db = db
+	return s.db
+}
+
+// NewEventStore creates an SQLiteEventStore instance.
+// The codecs are used to convert the events for storage. The DB file is
+// created on demand if it doesn't exist yet.
+func NewEventStore(path string, codecs *events.CodecRegistry) (*SQLiteEventStore, error) {
+	if path == "" {
+		return nil, errors.New("empty DB file path")
+	}
+	if codecs == nil {
+		return nil, errors.New("codec registry is nil")
+	}
+
+	s := SQLiteEventStore{
+		path:   path,
+		codecs: codecs,
+		signal: make(chan struct{}),
+	}
+
+	return &s, nil
+}
+
+// apply the decode policy to an error from loading one of several events
+// This returns true if the event is to be skipped. Otherwise, it sets the
+// error state of the store.
+func (s *SQLiteEventStore) skipUndecodable(ctx context.Context, err error) bool {
+	if err := s.codecs.HandleDecodeError(ctx, err, s); err != nil {
+		s.setError(err)
+		return false
+	}
+	return true
+}
+
+// set the error state of the store
+func (s *SQLiteEventStore) setError(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// wake up all followers waiting for new events
+func (s *SQLiteEventStore) notify() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	close(s.signal)
+	s.signal = make(chan struct{})
+}
+
+// retrieve the channel that is closed on the next insert
+func (s *SQLiteEventStore) waitChannel() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.signal
+}
+
+// ParseEventID implements the EventStore interface.
+func (s *SQLiteEventStore) ParseEventID(str string) (events.EventID, error) {
+	lp, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return events.EventID(lp), nil
+}
+
+// Error implements the EventStore interface.
+func (s *SQLiteEventStore) Error() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err
+}
+
+// Close implements the EventStore and io.Closer interfaces.
+// Note that this must correctly release any resources even with the error state set!
+func (s *SQLiteEventStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// set this error to block any further calls
+	s.err = errors.New("eventstore is closed")
+
+	// wake up any followers so they can terminate
+	close(s.signal)
+	s.signal = make(chan struct{})
+
+	if s.db == nil {
+		return nil
+	}
+	db := s.db
+	s.db = nil
+	return db.Close()
+}
+
+// Insert implements the EventStore interface.
+func (s *SQLiteEventStore) Insert(ctx context.Context, externalUUID uuid.UUID, event events.Event, causationID events.EventID) (events.Envelope, error) {
+	res, err := s.InsertMany(ctx, []events.NewEvent{{ExternalUUID: externalUUID, Event: event, CausationID: causationID}})
+	if err != nil {
+		return nil, err
+	}
+	return res[0], nil
+}
+
+// InsertMany implements the EventStore interface.
+func (s *SQLiteEventStore) InsertMany(ctx context.Context, newEvents []events.NewEvent) ([]events.Envelope, error) {
+	// encode events for storage, JSON payloads are stored as text, so they
+	// remain readable in the DB
+	classes := make([]string, len(newEvents))
+	schemaVersions := make([]int, len(newEvents))
+	contentTypes := make([]string, len(newEvents))
+	payloads := make([]any, len(newEvents))
+	for i, newEvent := range newEvents {
+		classes[i] = newEvent.Event.Class()
+		payload, contentType, schemaVersion, err := s.codecs.Encode(newEvent.Event)
+		if err != nil {
+			return nil, err
+		}
+		schemaVersions[i] = schemaVersion
+		contentTypes[i] = contentType
+		payloads[i] = payload
+		if contentType == events.ContentTypeJSON {
+			payloads[i] = string(payload)
+		}
+	}
+
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	// insert all events in one transaction
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created := time.Now()
+	res := make([]events.Envelope, 0, len(newEvents))
+	for i, newEvent := range newEvents {
+		// init envelope with input values
+		env := &sqliteEnvelope{
+			ExternalUUIDVal:  newEvent.ExternalUUID,
+			CreatedVal:       created,
+			CausationIDVal:   newEvent.CausationID,
+			CorrelationIDVal: newEvent.CorrelationID,
+			MetadataVal:      maps.Clone(newEvent.Metadata),
+			StreamVal:        newEvent.Stream,
+			EventVal:         newEvent.Event,
+		}
+
+		// determine the version within the stream
+		// The transaction holds the write lock, so the stream can't change
+		// between checking and inserting.
+		var stream *string
+		var version *int32
+		if newEvent.Stream != "" {
+			row := tx.QueryRowContext(
+				ctx,
+				`SELECT COALESCE(MAX(version), 0) FROM events WHERE stream = $1;`,
+				newEvent.Stream,
+			)
+			var current int32
+			if err := row.Scan(&current); err != nil {
+				return nil, err
+			}
+			if err := events.CheckVersion(newEvent.Stream, newEvent.ExpectedVersion, current); err != nil {
+				return nil, err
+			}
+			env.VersionVal = current + 1
+			stream = &env.StreamVal
+			version = &env.VersionVal
+		}
+
+		metadata, err := metadataAsDBValue(newEvent.Metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		// insert the event into the DB
+		row := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO events (external_uuid, created, causation_id, class, schema_version, content_type, payload, stream, version, correlation_id, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;`,
+			uuidAsDBValue(newEvent.ExternalUUID),
+			env.CreatedVal.UnixNano(),
+			env.CausationIDVal,
+			classes[i],
+			schemaVersions[i],
+			contentTypes[i],
+			payloads[i],
+			stream,
+			version,
+			env.CorrelationIDVal,
+			metadata,
+		)
+
+		// retrieve assigned ID from response
+		if err = row.Scan(&env.IDVal); err != nil {
+			var sqliteErr *sqlite.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+				// the only unique constraint besides the ID is the one on the UUID
+				return nil, events.DuplicateEventUUID
+			}
+			return nil, err
+		}
+		res = append(res, env)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// wake up followers in this process
+	s.notify()
+
+	return res, nil
+}
+
+// convert UUID to a parameter for the DB
+// We write nil UUID as `NULL`, so that the `UNIQUE` constraint is ignored.
+// All internal events have a nil external UUID, because they don't need
+// idempotent insert operations, but we also don't want those to be flagged
+// as duplicate values.
+func uuidAsDBValue(val uuid.UUID) *string {
+	if val == uuid.Nil {
+		return nil
+	}
+
+	res := val.String()
+	return &res
+}
+
+// convert response from the DB to UUID
+// See `uuidAsDBValue()`.
+func dbValueAsUUID(val *string) (uuid.UUID, error) {
+	if val == nil {
+		return uuid.Nil, nil
+	}
+
+	return uuid.FromString(*val)
+}
+
+// convert metadata to a parameter for the DB
+// Empty metadata is written as `NULL`, otherwise it is stored as JSON object.
+func metadataAsDBValue(val map[string]string) (*string, error) {
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	res := string(data)
+	return &res, nil
+}
+
+// convert response from the DB to metadata
+// See `metadataAsDBValue()`.
+func dbValueAsMetadata(val *string) (map[string]string, error) {
+	if val == nil {
+		return nil, nil
+	}
+
+	var res map[string]string
+	if err := json.Unmarshal([]byte(*val), &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ResolveUUID implements the EventStore interface.
+func (s *SQLiteEventStore) ResolveUUID(ctx context.Context, externalUUID uuid.UUID) (events.EventID, error) {
+	if externalUUID == uuid.Nil {
+		return 0, errors.New("provided external UUID is null")
+	}
+
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return 0, s.Error()
+	}
+
+	// retrieve row from DB
+	row := db.QueryRowContext(
+		ctx,
+		`SELECT id FROM events WHERE external_uuid = $1;`,
+		externalUUID.String(),
+	)
+
+	// extract field from response
+	var id events.EventID
+	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errors.New("document not found")
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// scanner is the common interface of sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// extract an envelope from a DB row
+// The row must contain the columns `id`, `external_uuid`, `created`,
+// `causation_id`, `class`, `schema_version`, `content_type`, `payload`,
+// `stream`, `version`, `correlation_id` and `metadata` in that order.
+func (s *SQLiteEventStore) scanEnvelope(row scanner) (*sqliteEnvelope, error) {
+	var res sqliteEnvelope
+	var externalUUID *string
+	var created int64
+	var class string
+	var schemaVersion int
+	var contentType string
+	var payload []byte
+	var stream sql.NullString
+	var version sql.NullInt32
+	var metadata *string
+	if err := row.Scan(&res.IDVal, &externalUUID, &created, &res.CausationIDVal, &class, &schemaVersion, &contentType, &payload, &stream, &version, &res.CorrelationIDVal, &metadata); err != nil {
+		return nil, err
+	}
+	res.StreamVal = stream.String
+	res.VersionVal = version.Int32
+
+	metadataVal, err := dbValueAsMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	res.MetadataVal = metadataVal
+
+	externalUUIDVal, err := dbValueAsUUID(externalUUID)
+	if err != nil {
+		return nil, err
+	}
+	res.ExternalUUIDVal = externalUUIDVal
+	res.CreatedVal = time.Unix(0, created)
+
+	// decode event
+	ev, err := s.codecs.Decode(res.IDVal, class, schemaVersion, contentType, payload)
+	if err != nil {
+		return nil, err
+	}
+	res.EventVal = ev
+
+	return &res, nil
+}
+
+// RetrieveOne implements the EventStore interface.
+func (s *SQLiteEventStore) RetrieveOne(ctx context.Context, id events.EventID) (events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	// retrieve row from DB
+	row := db.QueryRowContext(
+		ctx,
+		`SELECT id, external_uuid, created, causation_id, class, schema_version, content_type, payload, stream, version, correlation_id, metadata FROM events WHERE id = $1;`,
+		id,
+	)
+
+	res, err := s.scanEnvelope(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("document not found")
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+// pump events in the given range into the channel
+// Only events selected by the filter are emitted. This returns the ID of the
+// last event emitted, or the start of the range if none was emitted. In case
+// of failure, it sets the error state of the store.
+func (s *SQLiteEventStore) pumpEvents(ctx context.Context, db *sql.DB, bounds events.Range, filter events.Filter, out chan<- events.Envelope) (events.EventID, error) {
+	// retrieve rows from DB
+	startAfter := bounds.StartAfter
+	conditions, args := filterConditions(filter, []any{startAfter})
+	conditions, order, args := rangeClauses(bounds, conditions, args)
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT id, external_uuid, created, causation_id, class, schema_version, content_type, payload, stream, version, correlation_id, metadata FROM events WHERE id > $1`+conditions+order+`;`,
+		args...,
+	)
+	if err != nil {
+		s.setError(err)
+		return startAfter, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		res, err := s.scanEnvelope(rows)
+		var decodeErr *events.DecodeError
+		if errors.As(err, &decodeErr) && s.skipUndecodable(ctx, err) {
+			// move past the skipped event
+			startAfter = decodeErr.EventID
+			continue
+		}
+		if err != nil {
+			s.setError(err)
+			return startAfter, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return startAfter, ctx.Err()
+		case out <- res:
+		}
+
+		// remember new position in stream
+		startAfter = res.IDVal
+	}
+	if err := rows.Err(); err != nil {
+		s.setError(err)
+		return startAfter, err
+	}
+
+	return startAfter, nil
+}
+
+// build the SQL conditions for the filter
+// This returns the conditions, each with a leading "AND", and the arguments
+// extended by the values referenced by the conditions.
+func filterConditions(filter events.Filter, args []any) (string, []any) {
+	var conditions strings.Builder
+	param := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(filter.Classes) > 0 {
+		params := make([]string, len(filter.Classes))
+		for i, class := range filter.Classes {
+			params[i] = param(class)
+		}
+		conditions.WriteString(" AND class IN (" + strings.Join(params, ", ") + ")")
+	}
+	if filter.CausationID != 0 {
+		conditions.WriteString(" AND causation_id = " + param(filter.CausationID))
+	}
+	switch filter.ExternalUUID {
+	case events.Present:
+		conditions.WriteString(" AND external_uuid IS NOT NULL")
+	case events.Absent:
+		conditions.WriteString(" AND external_uuid IS NULL")
+	}
+	if !filter.CreatedSince.IsZero() {
+		conditions.WriteString(" AND created >= " + param(filter.CreatedSince.UnixNano()))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions.WriteString(" AND created < " + param(filter.CreatedBefore.UnixNano()))
+	}
+	return conditions.String(), args
+}
+
+// build the SQL clauses for the range, apart from its start
+// This returns the conditions extended by the upper bound, the ORDER BY and
+// LIMIT clauses and the arguments extended by the values they reference.
+func rangeClauses(bounds events.Range, conditions string, args []any) (string, string, []any) {
+	if bounds.StartBefore != 0 {
+		args = append(args, bounds.StartBefore)
+		conditions += " AND id < $" + strconv.Itoa(len(args))
+	}
+	order := " ORDER BY id"
+	if bounds.Backward {
+		order += " DESC"
+	}
+	if bounds.Limit > 0 {
+		order += " LIMIT " + strconv.Itoa(bounds.Limit)
+	}
+	return conditions, order, args
+}
+
+// LoadEvents implements the EventStore interface.
+func (s *SQLiteEventStore) LoadEvents(ctx context.Context, bounds events.Range, filter events.Filter) (<-chan events.Envelope, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	// run code to pump events in a goroutine
+	out := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		s.pumpEvents(ctx, db, bounds, filter, out)
+	}()
+
+	return out, nil
+}
+
+// LoadCaused implements the CausationLoader interface.
+func (s *SQLiteEventStore) LoadCaused(ctx context.Context, id events.EventID) (<-chan events.Envelope, error) {
+	// The ID must be valid.
+	if id == 0 {
+		return nil, errors.New("provided document ID is null")
+	}
+
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	// retrieve rows from DB
+	// The tree is collected recursively using the index on `causation_id`.
+	rows, err := db.QueryContext(
+		ctx,
+		`WITH RECURSIVE tree (id) AS (
+			SELECT id FROM events WHERE causation_id = $1
+			UNION
+			SELECT events.id FROM events JOIN tree ON events.causation_id = tree.id
+		)
+		SELECT id, external_uuid, created, causation_id, class, schema_version, content_type, payload, stream, version, correlation_id, metadata FROM events WHERE id IN (SELECT id FROM tree) ORDER BY id;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// run code to pump events in a goroutine
+	out := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(out)
+		defer rows.Close()
+
+		for rows.Next() {
+			res, err := s.scanEnvelope(rows)
+			if err != nil {
+				if s.skipUndecodable(ctx, err) {
+					continue
+				}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- res:
+			}
+		}
+		if err := rows.Err(); err != nil {
+			s.setError(err)
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadStream implements the StreamLoader interface.
+func (s *SQLiteEventStore) LoadStream(ctx context.Context, stream string, fromVersion int32) (<-chan events.Envelope, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	// retrieve rows from DB
+	rows, err := db.QueryContext(
+		ctx,
+		`SELECT id, external_uuid, created, causation_id, class, schema_version, content_type, payload, stream, version, correlation_id, metadata FROM events WHERE stream = $1 AND version >= $2 ORDER BY version;`,
+		stream,
+		fromVersion,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// run code to pump events in a goroutine
+	out := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(out)
+		defer rows.Close()
+
+		for rows.Next() {
+			res, err := s.scanEnvelope(rows)
+			if err != nil {
+				if s.skipUndecodable(ctx, err) {
+					continue
+				}
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case out <- res:
+			}
+		}
+		if err := rows.Err(); err != nil {
+			s.setError(err)
+		}
+	}()
+
+	return out, nil
+}
+
+// wait until new events may be available
+// This returns false when the context is cancelled or the store was closed.
+func (s *SQLiteEventStore) wait(ctx context.Context, signal <-chan struct{}) bool {
+	timer := time.NewTimer(PollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-signal:
+	case <-timer.C:
+	}
+	return s.Error() == nil
+}
+
+// FollowNotifications implements the EventStore interface.
+func (s *SQLiteEventStore) FollowNotifications(ctx context.Context) (<-chan events.Notification, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	// determine the current end of the stream, notifications are only
+	// emitted for events inserted afterwards
+	var id events.EventID
+	row := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM events;`)
+	if err := row.Scan(&id); err != nil {
+		return nil, err
+	}
+
+	// run code to pump notifications in a goroutine
+	out := make(chan events.Notification)
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for {
+			// get the signal channel before querying, so that no insert
+			// is missed in between
+			signal := s.waitChannel()
+
+			// retrieve IDs of new events from the DB
+			rows, err := db.QueryContext(
+				ctx,
+				`SELECT id FROM events WHERE id > $1 ORDER BY id;`,
+				id,
+			)
+			if err != nil {
+				s.setError(err)
+				return
+			}
+			for rows.Next() {
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					s.setError(err)
+					return
+				}
+				select {
+				case <-ctx.Done():
+					rows.Close()
+					return
+				case out <- &sqliteNotification{IDVal: id}:
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				s.setError(err)
+				return
+			}
+
+			// wait for notifications of new events
+			if !s.wait(ctx, signal) {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// FollowEvents implements the EventStore interface.
+func (s *SQLiteEventStore) FollowEvents(ctx context.Context, startAfter events.EventID, filter events.Filter) (<-chan events.Envelope, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	// run code to pump events in a goroutine
+	out := make(chan events.Envelope)
+	go func() {
+		// close channel on finish
+		defer close(out)
+
+		for {
+			// get the signal channel before querying, so that no insert
+			// is missed in between
+			signal := s.waitChannel()
+
+			id, err := s.pumpEvents(ctx, db, events.Range{StartAfter: startAfter}, filter, out)
+			if err != nil {
+				return
+			}
+			startAfter = id
+
+			// wait for notifications of new events
+			if !s.wait(ctx, signal) {
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// LoadCheckpoint implements the CheckpointStore interface.
+func (s *SQLiteEventStore) LoadCheckpoint(ctx context.Context, consumer string) (events.EventID, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return 0, s.Error()
+	}
+
+	var id events.EventID
+	row := db.QueryRowContext(ctx, `SELECT event_id FROM checkpoints WHERE consumer = $1;`, consumer)
+	if err := row.Scan(&id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	return id, nil
+}
+
+// SaveCheckpoint implements the CheckpointStore interface.
+func (s *SQLiteEventStore) SaveCheckpoint(ctx context.Context, consumer string, id events.EventID) error {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return s.Error()
+	}
+
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO checkpoints (consumer, event_id, updated) VALUES ($1, $2, $3)
+		ON CONFLICT (consumer) DO UPDATE SET event_id = excluded.event_id, updated = excluded.updated;`,
+		consumer,
+		id,
+		time.Now().UnixNano(),
+	)
+	return err
+}
+
+// ListCheckpoints implements the CheckpointStore interface.
+func (s *SQLiteEventStore) ListCheckpoints(ctx context.Context) ([]events.Checkpoint, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT consumer, event_id, updated FROM checkpoints ORDER BY consumer;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []events.Checkpoint
+	for rows.Next() {
+		var checkpoint events.Checkpoint
+		var updated int64
+		if err := rows.Scan(&checkpoint.Consumer, &checkpoint.EventID, &updated); err != nil {
+			return nil, err
+		}
+		checkpoint.Updated = time.Unix(0, updated)
+		res = append(res, checkpoint)
+	}
+	return res, rows.Err()
+}
+
+// ResetCheckpoint implements the CheckpointStore interface.
+func (s *SQLiteEventStore) ResetCheckpoint(ctx context.Context, consumer string) error {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return s.Error()
+	}
+
+	_, err := db.ExecContext(ctx, `DELETE FROM checkpoints WHERE consumer = $1;`, consumer)
+	return err
+}
+
+// LoadSnapshot implements the SnapshotStore interface.
+func (s *SQLiteEventStore) LoadSnapshot(ctx context.Context, consumer string) (*events.Snapshot, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	snapshot := events.Snapshot{Consumer: consumer}
+	var created int64
+	row := db.QueryRowContext(ctx, `SELECT event_id, data, created FROM snapshots WHERE consumer = $1;`, consumer)
+	if err := row.Scan(&snapshot.EventID, &snapshot.Data, &created); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	snapshot.Created = time.Unix(0, created)
+	return &snapshot, nil
+}
+
+// SaveSnapshot implements the SnapshotStore interface.
+func (s *SQLiteEventStore) SaveSnapshot(ctx context.Context, consumer string, id events.EventID, data []byte) error {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return s.Error()
+	}
+
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO snapshots (consumer, event_id, data, created) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (consumer) DO UPDATE SET event_id = excluded.event_id, data = excluded.data, created = excluded.created;`,
+		consumer,
+		id,
+		data,
+		time.Now().UnixNano(),
+	)
+	return err
+}
+
+// QuarantineEvent implements the Quarantine interface.
+func (s *SQLiteEventStore) QuarantineEvent(ctx context.Context, id events.EventID, class string, reason string) error {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return s.Error()
+	}
+
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO quarantine (event_id, class, reason, quarantined) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_id) DO UPDATE SET class = excluded.class, reason = excluded.reason, quarantined = excluded.quarantined;`,
+		id,
+		class,
+		reason,
+		time.Now().UnixNano(),
+	)
+	return err
+}
+
+// ListQuarantined implements the Quarantine interface.
+func (s *SQLiteEventStore) ListQuarantined(ctx context.Context) ([]events.QuarantinedEvent, error) {
+	// establish connection
+	db := s.connect(ctx)
+	if db == nil {
+		return nil, s.Error()
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT event_id, class, reason, quarantined FROM quarantine ORDER BY event_id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []events.QuarantinedEvent
+	for rows.Next() {
+		var event events.QuarantinedEvent
+		var quarantined int64
+		if err := rows.Scan(&event.EventID, &event.Class, &event.Reason, &quarantined); err != nil {
+			return nil, err
+		}
+		event.Quarantined = time.Unix(0, quarantined)
+		res = append(res, event)
+	}
+	return res, rows.Err()
+}
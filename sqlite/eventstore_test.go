@@ -0,0 +1,326 @@
+package sqlite
+
+import (
+	"api-broker-prototype/broker"
+	"api-broker-prototype/events"
+	"api-broker-prototype/events/storetest"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestEnvelope(t *testing.T) {
+	var _ events.Envelope = &sqliteEnvelope{}
+}
+
+func TestNotification(t *testing.T) {
+	var _ events.Notification = &sqliteNotification{}
+}
+
+func TestEventstore(t *testing.T) {
+	var _ events.EventStore = &SQLiteEventStore{}
+}
+
+func TestStreamLoader(t *testing.T) {
+	var _ events.StreamLoader = &SQLiteEventStore{}
+}
+
+func TestCausationLoader(t *testing.T) {
+	var _ events.CausationLoader = &SQLiteEventStore{}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	var _ events.CheckpointStore = &SQLiteEventStore{}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	var _ events.SnapshotStore = &SQLiteEventStore{}
+}
+
+func TestMigrator(t *testing.T) {
+	var _ events.Migrator = &SQLiteEventStore{}
+}
+
+func TestQuarantine(t *testing.T) {
+	var _ events.Quarantine = &SQLiteEventStore{}
+}
+
+// create a store using a file in a temporary directory
+func createStore(t *testing.T) *SQLiteEventStore {
+	return createStoreWithCodecs(t, storetest.Codecs(t))
+}
+
+// create a store using the given codecs
+func createStoreWithCodecs(t *testing.T, codecs *events.CodecRegistry) *SQLiteEventStore {
+	store, err := NewEventStore(filepath.Join(t.TempDir(), "events.sqlite"), codecs)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) events.EventStore {
+		return createStore(t)
+	})
+}
+
+func TestDecodePolicy(t *testing.T) {
+	storetest.RunDecodePolicy(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, codecs)
+	})
+}
+
+func TestEncodings(t *testing.T) {
+	storetest.RunEncodings(t, func(t *testing.T, codecs *events.CodecRegistry) events.EventStore {
+		return createStoreWithCodecs(t, codecs)
+	})
+}
+
+// load all events from the store
+func loadAll(t *testing.T, store events.EventStore) []events.Envelope {
+	ch, err := store.LoadEvents(context.Background(), events.Range{}, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var res []events.Envelope
+	for env := range ch {
+		res = append(res, env)
+	}
+	if err := store.Error(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return res
+}
+
+func TestInsert(t *testing.T) {
+	ctx := context.Background()
+	store := createStore(t)
+
+	externalUUID := uuid.FromStringOrNil("22428f46-a2d8-4d51-b6b5-bc8551bd0921")
+
+	first, err := store.Insert(ctx, externalUUID, broker.RequestEvent{Request: "first"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if first.ID() != 1 {
+		t.Errorf("unexpected ID %d", first.ID())
+	}
+
+	second, err := store.Insert(ctx, uuid.Nil, broker.APIRequestEvent{Attempt: 1}, first.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if second.ID() != 2 {
+		t.Errorf("unexpected ID %d", second.ID())
+	}
+
+	// a nil UUID is never considered a duplicate
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// a second insert with the same UUID fails
+	if _, err := store.Insert(ctx, externalUUID, events.SimpleEvent{}, 0); !errors.Is(err, events.DuplicateEventUUID) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	id, err := store.ResolveUUID(ctx, externalUUID)
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if id != first.ID() {
+		t.Errorf("unexpected ID %d", id)
+	}
+
+	env, err := store.RetrieveOne(ctx, second.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if env.CausationID() != first.ID() {
+		t.Errorf("unexpected causation ID %d", env.CausationID())
+	}
+	if env.ExternalUUID() != uuid.Nil {
+		t.Errorf("unexpected external UUID %v", env.ExternalUUID())
+	}
+	if env.Event() != (broker.APIRequestEvent{Attempt: 1}) {
+		t.Errorf("unexpected event %v", env.Event())
+	}
+
+	if _, err := store.RetrieveOne(ctx, 42); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
+
+func TestFollowEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store := createStore(t)
+
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ch, err := store.FollowEvents(ctx, 0, events.Filter{})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	notifications, err := store.FollowNotifications(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// existing events are emitted first
+	env := <-ch
+	if env == nil || env.ID() != 1 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+
+	// new events wake up the followers
+	if _, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env = <-ch
+	if env == nil || env.ID() != 2 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+	note := <-notifications
+	if note == nil || note.ID() != 2 {
+		t.Fatalf("unexpected notification %v", note)
+	}
+
+	// inserts from a second instance are picked up by polling
+	other, err := NewEventStore(store.path, storetest.Codecs(t))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	env = <-ch
+	if env == nil || env.ID() != 3 {
+		t.Fatalf("unexpected envelope %v", env)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	store := createStore(t)
+
+	// migrations are applied implicitly and can be repeated
+	for i := 0; i != 2; i++ {
+		version, err := store.Migrate(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if version != len(migrations) {
+			t.Errorf("unexpected version %d", version)
+		}
+	}
+}
+
+func TestLargeIDs(t *testing.T) {
+	ctx := context.Background()
+	store := createStore(t)
+
+	// continue with IDs beyond the range of 32-bit integers
+	first, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	const large = events.EventID(1) << 40
+	if _, err := store.db.ExecContext(ctx, `UPDATE sqlite_sequence SET seq = $1 WHERE name = 'events';`, large); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	second, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{}, first.ID())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if second.ID() != large+1 {
+		t.Errorf("unexpected ID %d", second.ID())
+	}
+
+	id, err := store.ParseEventID("1099511627777")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if id != second.ID() {
+		t.Errorf("unexpected ID %d", id)
+	}
+
+	envelopes := loadAll(t, store)
+	if len(envelopes) != 2 || envelopes[1].ID() != large+1 || envelopes[1].CausationID() != first.ID() {
+		t.Errorf("unexpected envelopes %v", envelopes)
+	}
+}
+
+// upcaster renaming the field of simple events from "text" to "message"
+type simpleEventUpcaster struct{}
+
+func (upcaster *simpleEventUpcaster) Class() string {
+	return "simple"
+}
+
+func (upcaster *simpleEventUpcaster) Version() int {
+	return 1
+}
+
+func (upcaster *simpleEventUpcaster) Upcast(data events.Payload) (events.Payload, error) {
+	return events.Payload{"message": data["text"]}, nil
+}
+
+func TestUpcast(t *testing.T) {
+	ctx := context.Background()
+	codecs := storetest.Codecs(t)
+	if err := codecs.RegisterUpcaster(&simpleEventUpcaster{}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store, err := NewEventStore(filepath.Join(t.TempDir(), "events.sqlite"), codecs)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	// new events are stored with the current schema version
+	current, err := store.Insert(ctx, uuid.Nil, events.SimpleEvent{Message: "current"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	var schemaVersion int
+	if err := store.db.QueryRowContext(ctx, `SELECT schema_version FROM events WHERE id = $1;`, current.ID()).Scan(&schemaVersion); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if schemaVersion != 2 {
+		t.Errorf("unexpected schema version %d", schemaVersion)
+	}
+
+	// events of an older schema version are converted on loading
+	if _, err := store.db.ExecContext(ctx, `INSERT INTO events (created, causation_id, class, schema_version, payload) VALUES (0, 0, 'simple', 1, '{"text": "old"}');`); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	envelopes := loadAll(t, store)
+	if len(envelopes) != 2 {
+		t.Fatalf("unexpected envelopes %v", envelopes)
+	}
+	for i, message := range []string{"current", "old"} {
+		if event := envelopes[i].Event().(events.SimpleEvent); event.Message != message {
+			t.Errorf("unexpected event %v", event)
+		}
+	}
+
+	// events of an unknown schema version can't be loaded
+	var id events.EventID
+	if err := store.db.QueryRowContext(ctx, `INSERT INTO events (created, causation_id, class, schema_version, payload) VALUES (0, 0, 'simple', 3, '{}') RETURNING id;`).Scan(&id); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := store.RetrieveOne(ctx, id); err == nil {
+		t.Errorf("expected error missing")
+	}
+}
@@ -0,0 +1,131 @@
+package sqlite
+
+// This file implements creation and migration of the DB schema.
+//
+// Since there is no separate DB server that could be set up in advance, the
+// migrations are always applied when the DB is opened. The schema version is
+// stored in SQLite's `user_version` field in the DB header. Each migration
+// runs in a transaction together with the update of that field.
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrations to apply in order
+// The schema version after applying a migration is its index plus one. Never
+// modify existing entries, only append new ones.
+var migrations = []string{
+	// version 1: events table
+	// The `AUTOINCREMENT` makes sure that IDs are strictly increasing and
+	// never reused, even if the latest event is deleted. The creation time
+	// is stored as nanoseconds since the Unix epoch.
+	`CREATE TABLE IF NOT EXISTS events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		external_uuid TEXT UNIQUE,
+		created INTEGER NOT NULL,
+		causation_id INTEGER NOT NULL,
+		class TEXT NOT NULL,
+		payload TEXT NOT NULL
+	);`,
+	// version 2: streams
+	// Events without a stream have `NULL` in both columns, which the unique
+	// index ignores.
+	`ALTER TABLE events ADD COLUMN stream TEXT;
+	ALTER TABLE events ADD COLUMN version INTEGER;
+	CREATE UNIQUE INDEX events_stream_version ON events (stream, version);`,
+	// version 3: correlation ID and metadata
+	// The metadata is stored as JSON object, or `NULL` if there is none.
+	`ALTER TABLE events ADD COLUMN correlation_id INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE events ADD COLUMN metadata TEXT;`,
+	// version 4: index for looking up the events caused by an event
+	`CREATE INDEX events_causation_id ON events (causation_id);`,
+	// version 5: consumer checkpoints
+	// The update time is stored as nanoseconds since the Unix epoch.
+	`CREATE TABLE checkpoints (
+		consumer TEXT PRIMARY KEY,
+		event_id INTEGER NOT NULL,
+		updated INTEGER NOT NULL
+	);`,
+	// version 6: snapshots of consumer state
+	// The creation time is stored as nanoseconds since the Unix epoch.
+	`CREATE TABLE snapshots (
+		consumer TEXT PRIMARY KEY,
+		event_id INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		created INTEGER NOT NULL
+	);`,
+	// version 7: schema version of the event payload
+	// Events stored before have the first version.
+	`ALTER TABLE events ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 1;`,
+	// version 8: quarantine of undecodable events
+	// The quarantine time is stored as nanoseconds since the Unix epoch.
+	`CREATE TABLE quarantine (
+		event_id INTEGER PRIMARY KEY,
+		class TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		quarantined INTEGER NOT NULL
+	);`,
+	// version 9: content type of the event payload
+	// Events stored before are JSON. JSON payloads are stored as text, others
+	// as blob.
+	`ALTER TABLE events ADD COLUMN content_type TEXT NOT NULL DEFAULT 'application/json';`,
+}
+
+// Migrate implements the Migrator interface.
+func (s *SQLiteEventStore) Migrate(ctx context.Context) (int, error) {
+	// establish connection, which applies the migrations
+	db := s.connect(ctx)
+	if db == nil {
+		return 0, s.Error()
+	}
+
+	var version int
+	row := db.QueryRowContext(ctx, `PRAGMA user_version;`)
+	if err := row.Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// apply all pending migrations to the given DB
+func migrate(ctx context.Context, db *sql.DB) error {
+	for {
+		done, err := migrateStep(ctx, db)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// apply the next pending migration, if any
+// This returns whether the schema is up to date.
+func migrateStep(ctx context.Context, db *sql.DB) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// determine current schema version
+	var version int
+	row := tx.QueryRowContext(ctx, `PRAGMA user_version;`)
+	if err := row.Scan(&version); err != nil {
+		return false, err
+	}
+	if version >= len(migrations) {
+		return true, nil
+	}
+
+	// apply migration and record the new version
+	if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+		return false, err
+	}
+	// Note that PRAGMA doesn't support parameters.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d;`, version+1)); err != nil {
+		return false, err
+	}
+
+	return false, tx.Commit()
+}